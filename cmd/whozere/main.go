@@ -8,11 +8,20 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"slices"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/delivery"
+	"github.com/xsddz/whozere/internal/detection"
+	"github.com/xsddz/whozere/internal/digest"
+	"github.com/xsddz/whozere/internal/filter"
+	"github.com/xsddz/whozere/internal/geoip"
 	"github.com/xsddz/whozere/internal/notifier"
+	"github.com/xsddz/whozere/internal/server"
+	"github.com/xsddz/whozere/internal/throttle"
 	"github.com/xsddz/whozere/internal/watcher"
 )
 
@@ -44,23 +53,81 @@ func main() {
 	if err := cfg.Validate(); err != nil {
 		log.Fatalf("Invalid config: %v", err)
 	}
+	if err := watcher.ValidateRules(cfg.Rules); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	if err := notifier.ValidateTemplates(cfg.Notifiers); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
+	filters, err := filter.New(cfg.Filters)
+	if err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+	dedup, err := throttle.NewDedup(cfg.Dedup)
+	if err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
+	// Key owners and per-method severities for SSH logins
+	auth, err := detection.NewAuthAnnotator(cfg)
+	if err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
+	// Location and network of source addresses, from local databases
+	geo, err := geoip.New(cfg.GeoIP)
+	if err != nil {
+		log.Fatalf("Failed to open GeoIP database: %v", err)
+	}
 
 	// Create notifiers
 	var notifiers []notifier.Notifier
-	for _, nc := range cfg.Notifiers {
+	var retries []config.RetryConfig
+	var routes []*filter.Route
+	var limits []*throttle.Limiter
+	var digests []*digest.Batcher
+	for i, nc := range cfg.Notifiers {
 		if !nc.Enabled {
 			continue
 		}
+		nc.Match = cfg.Detection.PrivilegeEscalation.RouteMatch(nc)
+		route, err := filter.NewRoute(nc)
+		if err != nil {
+			log.Fatalf("Invalid config: notifier[%d] (%s): %v", i, nc.Name, err)
+		}
+		limit, err := throttle.NewLimiter(nc.RateLimit)
+		if err != nil {
+			log.Fatalf("Invalid config: notifier[%d] (%s): %v", i, nc.Name, err)
+		}
+		batcher, err := digest.New(nc.Digest)
+		if err != nil {
+			log.Fatalf("Invalid config: notifier[%d] (%s): %v", i, nc.Name, err)
+		}
 		n, err := notifier.New(nc)
 		if err != nil {
 			log.Printf("Warning: failed to create notifier %s: %v", nc.Name, err)
 			continue
 		}
 		notifiers = append(notifiers, n)
+		retries = append(retries, nc.Retry)
+		routes = append(routes, route)
+		limits = append(limits, limit)
+		digests = append(digests, batcher)
 		log.Printf("Notifier enabled: %s", n.Name())
 	}
 
-	if len(notifiers) == 0 {
+	// Agent mode: events are forwarded to a server, which notifies
+	var agent *server.Agent
+	if cfg.Agent.Enabled() {
+		agent, err = server.NewAgent(cfg.Agent, version)
+		if err != nil {
+			log.Fatalf("Failed to create agent: %v", err)
+		}
+		log.Printf("Forwarding events to %s as agent %s", cfg.Agent.Server, agent.Name())
+	}
+
+	if len(notifiers) == 0 && agent == nil {
 		log.Fatal("No notifiers available")
 	}
 
@@ -68,6 +135,9 @@ func main() {
 	if *testNotify {
 		hostname, _ := os.Hostname()
 		testEvent := notifier.LoginEvent{
+			ID:        notifier.NewEventID(),
+			Kind:      notifier.KindLogin,
+			Severity:  notifier.SeverityInfo,
 			Username:  os.Getenv("USER"),
 			Hostname:  hostname,
 			Terminal:  "test",
@@ -76,8 +146,15 @@ func main() {
 		}
 
 		log.Println("Sending test notification...")
+		if agent != nil {
+			if err := agent.Send(context.Background(), []notifier.LoginEvent{testEvent}); err != nil {
+				log.Printf("Failed to send test to server: %v", err)
+			} else {
+				log.Printf("Test event sent to server %s", cfg.Agent.Server)
+			}
+		}
 		for _, n := range notifiers {
-			if err := n.Send(testEvent); err != nil {
+			if err := n.Send(context.Background(), testEvent); err != nil {
 				log.Printf("Failed to send test to %s: %v", n.Name(), err)
 			} else {
 				log.Printf("Test notification sent to %s", n.Name())
@@ -86,12 +163,45 @@ func main() {
 		return
 	}
 
-	// Create watcher
-	w, err := watcher.New()
+	// Deliver through retrying queues from here on
+	deliveries, err := delivery.New(cfg.Delivery)
 	if err != nil {
-		log.Fatalf("Failed to create watcher: %v", err)
+		log.Fatalf("Failed to set up delivery: %v", err)
+	}
+	for i, n := range notifiers {
+		q, err := deliveries.Wrap(n, retries[i])
+		if err != nil {
+			log.Fatalf("Failed to set up delivery: %v", err)
+		}
+		notifiers[i] = q
+	}
+
+	// Create watcher: one per configured source, or the single watcher
+	// A server may watch nothing itself
+	var w watcher.Watcher
+	var sources *watcher.Composite
+	if cfg.Watcher.Type == config.WatcherNone {
+		log.Println("Local watching disabled")
+	} else if len(cfg.Sources) > 0 {
+		sources, err = watcher.NewFromSources(cfg.Sources)
+		if err != nil {
+			log.Fatalf("Failed to create watcher: %v", err)
+		}
+		sources.OnStateChange = func(h watcher.SourceHealth) {
+			if h.State == watcher.SourceFailed || (h.State == watcher.SourceRunning && h.Restarts > 0) {
+				log.Printf("Source %s", h)
+			}
+		}
+		w = sources
+	} else {
+		w, err = watcher.NewFromConfig(cfg.Watcher)
+		if err != nil {
+			log.Fatalf("Failed to create watcher: %v", err)
+		}
+	}
+	if w != nil {
+		log.Printf("Using %s watcher", w.Name())
 	}
-	log.Printf("Using %s watcher", w.Name())
 
 	// Setup context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
@@ -104,22 +214,61 @@ func main() {
 		sig := <-sigChan
 		log.Printf("Received signal %v, shutting down...", sig)
 		cancel()
+		// A second signal skips flushing pending notifications
+		sig = <-sigChan
+		log.Printf("Received signal %v again, exiting now", sig)
+		os.Exit(1)
 	}()
 
+	if err := deliveries.Start(ctx); err != nil {
+		log.Fatalf("Failed to start delivery: %v", err)
+	}
+
 	// Create event channel
 	events := make(chan notifier.LoginEvent, 10)
 
 	// Start watcher with options
-	watchOpts := watcher.Options{Since: *since}
-	go func() {
-		if err := w.WatchWithOptions(ctx, events, watchOpts); err != nil && ctx.Err() == nil {
-			log.Printf("Watcher error: %v", err)
+	watchOpts := watcher.Options{Since: *since, Rules: cfg.Rules}
+	if cfg.StateFile != "" {
+		checkpoints, err := watcher.OpenCheckpointStore(cfg.StateFile)
+		if err != nil {
+			log.Fatalf("Failed to open state file: %v", err)
 		}
-	}()
+		defer func() {
+			if err := checkpoints.Save(); err != nil {
+				log.Printf("Failed to save state: %v", err)
+			}
+		}()
+		watchOpts.Checkpoints = checkpoints
+		log.Printf("Resuming from state file: %s", cfg.StateFile)
+	}
+	if w != nil {
+		go func() {
+			if err := w.WatchWithOptions(ctx, events, watchOpts); err != nil && ctx.Err() == nil {
+				log.Printf("Watcher error: %v", err)
+			}
+		}()
+	}
+
+	// Server mode: receive events from agents
+	if cfg.Server.Enabled() {
+		srv := server.New(cfg.Server, events)
+		if err := srv.Start(ctx); err != nil {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+		log.Printf("Receiving events from agents on %s", cfg.Server.Listen)
+	}
+
+	if agent != nil {
+		if sources != nil {
+			agent.Sources = sources.Health
+		}
+		go agent.Run(ctx)
+	}
 
 	// Start log integrity monitor if enabled and applicable
-	if *integrity {
-		logFiles := watcher.PlatformLogFiles()
+	if w != nil && *integrity {
+		logFiles := watcher.LogFiles(w)
 		if len(logFiles) > 0 {
 			monitor := watcher.NewLogIntegrityMonitor(logFiles, watcher.DefaultLogIntegrityOptions())
 			go func() {
@@ -131,31 +280,198 @@ func main() {
 		}
 	}
 
+	// Periodically report the state of each source
+	if sources != nil && cfg.SourceHealthInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(cfg.SourceHealthInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					for _, h := range sources.Health() {
+						log.Printf("Source %s", h)
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
 	if *since > 0 {
 		log.Printf("whozere v%s started, checking logins from %v ago and watching for new ones...", version, *since)
 	} else {
 		log.Printf("whozere v%s started, watching for logins...", version)
 	}
 
+	// Correlate failed attempts into brute-force alerts
+	var bruteForce *detection.BruteForceDetector
+	if cfg.Detection.BruteForce.Enabled {
+		bruteForce = detection.NewBruteForceDetector(cfg.Detection.BruteForce)
+	}
+
+	send := func(n notifier.Notifier, event notifier.LoginEvent) {
+		// Queued: failures are retried and logged by the delivery layer
+		if err := n.Send(ctx, event); err != nil {
+			log.Printf("Failed to queue notification via %s: %v", n.Name(), err)
+		}
+	}
+
+	// notify sends event to the notifiers it is routed to, in their next
+	// digest or right away within their rate limits
+	notify := func(event notifier.LoginEvent) {
+		log.Printf("Event detected [%s/%s]: %s", event.Kind, event.Severity, event.Summary())
+		for i, n := range notifiers {
+			if !routes[i].Accepts(event) {
+				continue
+			}
+			if digests[i] != nil && digests[i].Add(event, time.Now()) {
+				// A full batch goes out now, not at the next sweep
+				if d := digests[i].Due(time.Now()); d != nil {
+					send(n, *d)
+				}
+				continue
+			}
+			if limits[i] != nil && !limits[i].Allow(event, time.Now()) {
+				log.Printf("Rate limited via %s: %s", n.Name(), event.Summary())
+				continue
+			}
+			send(n, event)
+		}
+	}
+
+	dispatch := func(event notifier.LoginEvent) {
+		// Apply filters
+		if filters.Ignore(event) {
+			log.Printf("Filtered: %s@%s (%s)", event.Username, event.Hostname, event.Terminal)
+			return
+		}
+		if dedup != nil && !dedup.Allow(event, time.Now()) {
+			log.Printf("Duplicate: %s", event.Summary())
+			return
+		}
+		notify(event)
+	}
+
+	// followUps sends the digests that are due and reports the events
+	// suppressed by dedup and rate limits once their window is over, or
+	// all of them on shutdown
+	followUps := func(now time.Time, final bool) {
+		if dedup != nil {
+			var events []notifier.LoginEvent
+			if final {
+				events = dedup.Drain()
+			} else {
+				events = dedup.FollowUps(now)
+			}
+			for _, event := range events {
+				notify(event)
+			}
+		}
+		for i, batcher := range digests {
+			if batcher == nil {
+				continue
+			}
+			var d *notifier.LoginEvent
+			if final {
+				d = batcher.Drain()
+			} else {
+				d = batcher.Due(now)
+			}
+			if d != nil {
+				send(notifiers[i], *d)
+			}
+		}
+		for i, limit := range limits {
+			if limit == nil {
+				continue
+			}
+			var events []notifier.LoginEvent
+			if final {
+				events = limit.Drain()
+			} else {
+				events = limit.FollowUps(now)
+			}
+			for _, event := range events {
+				send(notifiers[i], event)
+			}
+		}
+	}
+	var sweep <-chan time.Time
+	if dedup != nil || slices.ContainsFunc(limits, func(l *throttle.Limiter) bool { return l != nil }) ||
+		slices.ContainsFunc(digests, func(b *digest.Batcher) bool { return b != nil }) {
+		ticker := time.NewTicker(throttle.SweepInterval)
+		defer ticker.Stop()
+		sweep = ticker.C
+	}
+
 	// Process events
 	for {
 		select {
 		case event := <-events:
-			// Apply filters
-			if cfg.Filters.ShouldIgnore(event.Username, event.Terminal) {
-				log.Printf("Filtered: %s@%s (%s)", event.Username, event.Hostname, event.Terminal)
-				continue
+			event.FillDefaults()
+
+			// Local events go to the server; those received from agents
+			// (in server mode) are notified here
+			if agent != nil && event.Fields[notifier.FieldAgent] == "" {
+				agent.Forward(event)
+				if len(notifiers) == 0 {
+					continue
+				}
+			}
+
+			auth.Annotate(&event)
+			if geo != nil {
+				geo.Enrich(&event)
 			}
 
-			log.Printf("Login detected: %s@%s (%s)", event.Username, event.Hostname, event.Terminal)
-			for _, n := range notifiers {
-				go func(n notifier.Notifier) {
-					if err := n.Send(event); err != nil {
-						log.Printf("Failed to send notification via %s: %v", n.Name(), err)
+			if event.Kind == notifier.KindFailedAuth {
+				if bruteForce != nil {
+					if alert := bruteForce.Observe(event); alert != nil {
+						dispatch(*alert)
 					}
-				}(n)
+				}
+				if !cfg.Detection.NotifyFailedAuth {
+					log.Printf("Failed login: %s@%s from %s (%s)", event.Username, event.Hostname, event.IP, event.Terminal)
+					continue
+				}
+			}
+
+			if event.Kind == notifier.KindLogout {
+				duration, _ := time.ParseDuration(event.Fields[notifier.FieldDuration])
+				if !cfg.Detection.Logout.ShouldNotify(duration) {
+					log.Printf("Logout: %s@%s (%s) after %v", event.Username, event.Hostname, event.Terminal, duration)
+					continue
+				}
 			}
+
+			if event.Kind == notifier.KindPrivilegeEscalation {
+				target, command := event.Fields[notifier.FieldTargetUser], event.Fields[notifier.FieldCommand]
+				if !cfg.Detection.PrivilegeEscalation.ShouldNotify(event.Username, target, command) {
+					log.Printf("Privilege escalation: %s", event.Summary())
+					continue
+				}
+			}
+
+			dispatch(event)
+		case now := <-sweep:
+			followUps(now, false)
 		case <-ctx.Done():
+			followUps(time.Now(), true)
+
+			// Deliver what is still pending, for a bounded time
+			timeout := cfg.ShutdownTimeout
+			if timeout <= 0 {
+				timeout = config.DefaultShutdownTimeout
+			}
+			flushCtx, cancelFlush := context.WithTimeout(context.Background(), timeout)
+			var flushing sync.WaitGroup
+			if agent != nil {
+				flushing.Go(func() { agent.Flush(flushCtx) })
+			}
+			deliveries.Flush(flushCtx)
+			flushing.Wait()
+			cancelFlush()
 			log.Println("Shutdown complete")
 			return
 		}
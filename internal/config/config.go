@@ -2,7 +2,11 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -11,8 +15,328 @@ import (
 type Config struct {
 	Notifiers []NotifierConfig `yaml:"notifiers"`
 	Filters   FilterConfig     `yaml:"filters"`
+	Detection DetectionConfig  `yaml:"detection"`
+	// Rules are custom detection rules, tried before the built-in ones
+	Rules []RuleConfig `yaml:"rules"`
+	// KnownKeys maps SSH key fingerprints (SHA256:...) to their owners
+	KnownKeys map[string]string `yaml:"known_keys"`
+	// Watcher selects where login events are read from
+	Watcher WatcherConfig `yaml:"watcher"`
+	// Sources watches several log sources at once, replacing Watcher
+	Sources []SourceConfig `yaml:"sources"`
+	// SourceHealthInterval is how often the state of each source is logged
+	// Zero disables the report; failures are always logged
+	SourceHealthInterval time.Duration `yaml:"source_health_interval"`
+	// StateFile stores read positions so restarts neither lose nor repeat events
+	// Empty disables checkpointing
+	StateFile string `yaml:"state_file"`
+	// Server receives events from remote agents
+	Server ServerConfig `yaml:"server"`
+	// Agent forwards events to a server
+	Agent AgentConfig `yaml:"agent"`
+	// Delivery controls retries of failed notifications
+	Delivery DeliveryConfig `yaml:"delivery"`
+	// GeoIP adds the location and network of source addresses to events
+	GeoIP GeoIPConfig `yaml:"geoip"`
+	// Dedup suppresses repeats of an event before they are notified
+	Dedup DedupConfig `yaml:"dedup"`
+	// ShutdownTimeout bounds how long pending notifications are flushed on
+	// shutdown (default 10s)
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
 }
 
+// DefaultShutdownTimeout is the default time allowed to flush on shutdown
+const DefaultShutdownTimeout = 10 * time.Second
+
+// GeoIPConfig points to local MaxMind DB (.mmdb) files; lookups never use
+// the network
+type GeoIPConfig struct {
+	// Database has countries or cities (e.g. GeoLite2-City.mmdb)
+	Database string `yaml:"database"`
+	// ASNDatabase has autonomous systems (e.g. GeoLite2-ASN.mmdb)
+	ASNDatabase string `yaml:"asn_database"`
+}
+
+// Enabled reports whether any database is set
+func (g GeoIPConfig) Enabled() bool {
+	return g.Database != "" || g.ASNDatabase != ""
+}
+
+// WatcherConfig selects the login event source
+type WatcherConfig struct {
+	// Type is auto (default), authlog, journal, wtmp, auditd, file
+	// (Linux only), syslog or none
+	Type string `yaml:"type"`
+	// Path overrides the file read by the authlog, wtmp and auditd types
+	// and is required by the file type
+	Path string `yaml:"path"`
+	// Syslog configures the syslog type
+	Syslog SyslogConfig `yaml:"syslog"`
+}
+
+// SyslogConfig configures the syslog receiver, which accepts RFC3164 and
+// RFC5424 messages forwarded by other hosts
+type SyslogConfig struct {
+	// Listen is the address to listen on (default ":514")
+	Listen string `yaml:"listen"`
+	// Protocol is udp (default), tcp or tls
+	Protocol string `yaml:"protocol"`
+	// CertFile and KeyFile hold the server certificate for tls
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile, if set, requires tls clients to present a certificate
+	// signed by one of its CAs
+	ClientCAFile string `yaml:"client_ca_file"`
+	// Allow lists the sender addresses or CIDRs accepted
+	// Empty accepts messages from anyone
+	Allow []string `yaml:"allow"`
+}
+
+// Syslog protocols
+const (
+	SyslogUDP = "udp"
+	SyslogTCP = "tcp"
+	SyslogTLS = "tls"
+)
+
+// DefaultSyslogListen is the standard syslog address
+const DefaultSyslogListen = ":514"
+
+// validate checks the protocol, TLS files and allowed senders
+func (s SyslogConfig) validate() error {
+	switch s.Protocol {
+	case "", SyslogUDP, SyslogTCP:
+	case SyslogTLS:
+		if s.CertFile == "" || s.KeyFile == "" {
+			return fmt.Errorf("syslog: cert_file and key_file are required for tls")
+		}
+	default:
+		return fmt.Errorf("syslog: unknown protocol %q", s.Protocol)
+	}
+	if s.ClientCAFile != "" && s.Protocol != SyslogTLS {
+		return fmt.Errorf("syslog: client_ca_file requires protocol tls")
+	}
+	for _, a := range s.Allow {
+		if _, err := ParsePrefix(a); err != nil {
+			return fmt.Errorf("syslog: allow: %w", err)
+		}
+	}
+	return nil
+}
+
+// ParsePrefix parses a CIDR ("10.0.0.0/8") or a single address ("10.0.0.1")
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Watcher types
+const (
+	WatcherAuto    = "auto"
+	WatcherAuthLog = "authlog"
+	WatcherJournal = "journal"
+	WatcherWtmp    = "wtmp"
+	WatcherAuditd  = "auditd"
+	// WatcherFile is any text log, matched only by custom rules
+	WatcherFile = "file"
+	// WatcherSyslog receives syslog messages over the network
+	WatcherSyslog = "syslog"
+	// WatcherNone watches nothing locally (a server only relaying agents)
+	WatcherNone = "none"
+)
+
+// validate checks the watcher type and its path
+func (w WatcherConfig) validate() error {
+	switch w.Type {
+	case "", WatcherAuto, WatcherAuthLog, WatcherJournal, WatcherWtmp, WatcherAuditd, WatcherNone:
+	case WatcherFile:
+		if w.Path == "" {
+			return fmt.Errorf("path is required for type %s", w.Type)
+		}
+	case WatcherSyslog:
+		return w.Syslog.validate()
+	default:
+		return fmt.Errorf("unknown type %q", w.Type)
+	}
+	return nil
+}
+
+// SourceConfig is one of several log sources watched at once
+type SourceConfig struct {
+	// Name identifies the source on events and in health reports
+	// Defaults to the type
+	Name string `yaml:"name"`
+	// Type and Path select the watcher, as in the watcher section
+	WatcherConfig `yaml:",inline"`
+	// Rules are tried before the global rules and the built-in ones
+	Rules []RuleConfig `yaml:"rules"`
+}
+
+// SourceName returns the name of the source, defaulting to its type
+func (s SourceConfig) SourceName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	if s.Type != "" {
+		return s.Type
+	}
+	return WatcherAuto
+}
+
+// DetectionConfig defines how failed authentication attempts are handled
+type DetectionConfig struct {
+	// NotifyFailedAuth sends a notification for every failed authentication attempt
+	NotifyFailedAuth bool `yaml:"notify_failed_auth"`
+	// BruteForce raises a single alert when failures pile up in a short window
+	BruteForce BruteForceConfig `yaml:"brute_force"`
+	// Logout controls notifications for session ends
+	Logout LogoutConfig `yaml:"logout"`
+	// PrivilegeEscalation controls notifications for sudo and su
+	PrivilegeEscalation PrivilegeEscalationConfig `yaml:"privilege_escalation"`
+	// MethodSeverity sets the severity of logins by authentication method
+	// (e.g. password: high, publickey: info)
+	MethodSeverity map[string]string `yaml:"method_severity"`
+}
+
+// LogoutConfig defines which session ends are notified
+type LogoutConfig struct {
+	// Notify enables logout notifications
+	Notify bool `yaml:"notify"`
+	// ShorterThan only notifies sessions shorter than this (e.g. 10s to spot scripted logins)
+	ShorterThan time.Duration `yaml:"shorter_than"`
+	// LongerThan only notifies sessions longer than this
+	LongerThan time.Duration `yaml:"longer_than"`
+}
+
+// ShouldNotify checks if a session that lasted duration should be notified
+// With no thresholds every logout is notified; with thresholds a session
+// must be shorter than ShorterThan or longer than LongerThan
+func (l *LogoutConfig) ShouldNotify(duration time.Duration) bool {
+	if !l.Notify {
+		return false
+	}
+	if l.ShorterThan == 0 && l.LongerThan == 0 {
+		return true
+	}
+	if l.ShorterThan > 0 && duration < l.ShorterThan {
+		return true
+	}
+	if l.LongerThan > 0 && duration > l.LongerThan {
+		return true
+	}
+	return false
+}
+
+// PrivilegeEscalationConfig defines which sudo/su events are notified and where
+type PrivilegeEscalationConfig struct {
+	// Notify enables privilege escalation notifications
+	Notify bool `yaml:"notify"`
+	// IgnoreUsers is a list of invoking users to ignore
+	IgnoreUsers []string `yaml:"ignore_users"`
+	// IgnoreTargets is a list of target users to ignore (e.g. a service account)
+	IgnoreTargets []string `yaml:"ignore_targets"`
+	// IgnoreCommands is a list of command patterns to ignore; * matches anything
+	IgnoreCommands []string `yaml:"ignore_commands"`
+	// Notifiers limits these events to the named notifiers (default: all)
+	// A shorthand for the match entries of the other notifiers, see
+	// RouteMatch
+	Notifiers []string `yaml:"notifiers"`
+}
+
+// ShouldNotify checks if user switching to target to run command should be notified
+func (p *PrivilegeEscalationConfig) ShouldNotify(user, target, command string) bool {
+	if !p.Notify {
+		return false
+	}
+	for _, u := range p.IgnoreUsers {
+		if u == user {
+			return false
+		}
+	}
+	for _, t := range p.IgnoreTargets {
+		if t == target {
+			return false
+		}
+	}
+	for _, c := range p.IgnoreCommands {
+		if matchWildcard(c, command) {
+			return false
+		}
+	}
+	return true
+}
+
+// notPrivilegeEscalation is the condition added by RouteMatch
+const notPrivilegeEscalation = `kind != "privilege_escalation"`
+
+// RouteMatch returns the match entries of notifier n with the Notifiers
+// shorthand applied: the entries of a notifier not listed there also
+// require kind != "privilege_escalation"
+func (p *PrivilegeEscalationConfig) RouteMatch(n NotifierConfig) []MatchConfig {
+	if len(p.Notifiers) == 0 || slices.Contains(p.Notifiers, n.DisplayName()) {
+		return n.Match
+	}
+	if len(n.Match) == 0 {
+		return []MatchConfig{{When: notPrivilegeEscalation}}
+	}
+	match := make([]MatchConfig, len(n.Match))
+	for i, m := range n.Match {
+		if m.When == "" {
+			m.When = notPrivilegeEscalation
+		} else {
+			m.When = "(" + m.When + ") && " + notPrivilegeEscalation
+		}
+		match[i] = m
+	}
+	return match
+}
+
+// matchWildcard matches s against pattern, where * matches any run of
+// characters (including "/" and spaces, unlike path.Match)
+func matchWildcard(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// BruteForceConfig defines brute-force detection thresholds
+type BruteForceConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Threshold is the number of failures that triggers an alert (default 5)
+	Threshold int `yaml:"threshold"`
+	// Window is the sliding window failures are counted in (default 5m)
+	Window time.Duration `yaml:"window"`
+}
+
+// Default brute-force detection settings
+const (
+	DefaultBruteForceThreshold = 5
+	DefaultBruteForceWindow    = 5 * time.Minute
+)
+
 // FilterConfig defines event filtering rules
 type FilterConfig struct {
 	// IgnoreTerminals is a list of terminal types to ignore (e.g., cron, su, sudo)
@@ -21,6 +345,16 @@ type FilterConfig struct {
 	IgnoreUsers []string `yaml:"ignore_users"`
 	// IgnoreCombinations is a list of user+terminal combinations to ignore
 	IgnoreCombinations []FilterCombination `yaml:"ignore_combinations"`
+	// IgnoreMethods is a list of authentication methods to ignore (e.g., publickey)
+	IgnoreMethods []string `yaml:"ignore_methods"`
+	// IgnoreKnownKeys ignores logins with a key listed in known_keys
+	IgnoreKnownKeys bool `yaml:"ignore_known_keys"`
+	// Ignore drops events matching any entry, like the lists above
+	Ignore []MatchConfig `yaml:"ignore"`
+	// Only, if set, drops events matching none of its entries
+	// Ignoring takes precedence: an event both ignored and matched by
+	// Only is dropped
+	Only []MatchConfig `yaml:"only"`
 }
 
 // FilterCombination defines a specific user+terminal combination to ignore
@@ -55,12 +389,62 @@ func (f *FilterConfig) ShouldIgnore(username, terminal string) bool {
 	return false
 }
 
+// ShouldIgnoreAuth checks if a login should be ignored based on how it
+// authenticated; keyOwner is the known_keys owner of the key, if any
+func (f *FilterConfig) ShouldIgnoreAuth(method, keyOwner string) bool {
+	if f.IgnoreKnownKeys && keyOwner != "" {
+		return true
+	}
+	for _, m := range f.IgnoreMethods {
+		if m == method || strings.HasPrefix(method, m+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 // NotifierConfig represents a notification channel configuration
 type NotifierConfig struct {
 	Type    string            `yaml:"type"`    // webhook, dingtalk, wecom, telegram, slack, email
 	Name    string            `yaml:"name"`    // optional friendly name
 	Enabled bool              `yaml:"enabled"` // enable/disable this notifier
 	Config  map[string]string `yaml:"config"`  // type-specific configuration
+	// Retry overrides delivery.retry for this notifier
+	Retry RetryConfig `yaml:"retry"`
+	// Template is a text/template for the message, or the name of a
+	// built-in one (default, compact, zh-CN)
+	Template string `yaml:"template"`
+	// SubjectTemplate is a text/template for the email subject
+	SubjectTemplate string `yaml:"subject_template"`
+	// Match limits the events sent to this notifier to those matching any
+	// entry (default: all events)
+	Match []MatchConfig `yaml:"match"`
+	// RateLimit limits how many notifications are sent
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	// Digest batches events into periodic summaries
+	Digest DigestConfig `yaml:"digest"`
+}
+
+// defaultNotifierNames are the names of notifiers without one, by type
+var defaultNotifierNames = map[string]string{
+	"webhook":  "Webhook",
+	"dingtalk": "DingTalk",
+	"wecom":    "WeCom",
+	"telegram": "Telegram",
+	"slack":    "Slack",
+	"email":    "Email",
+	"feishu":   "Feishu",
+}
+
+// DisplayName returns the name of the notifier, defaulting to its type
+func (n NotifierConfig) DisplayName() string {
+	if n.Name != "" {
+		return n.Name
+	}
+	if name, ok := defaultNotifierNames[n.Type]; ok {
+		return name
+	}
+	return n.Type
 }
 
 // Load reads configuration from a YAML file
@@ -80,23 +464,118 @@ func Load(path string) (*Config, error) {
 
 // Validate checks if the configuration is valid
 func (c *Config) Validate() error {
-	if len(c.Notifiers) == 0 {
+	// Agents may leave notifying to the server
+	if len(c.Notifiers) == 0 && !c.Agent.Enabled() {
 		return fmt.Errorf("at least one notifier must be configured")
 	}
 
 	hasEnabled := false
+	enabled := make(map[string]bool)
 	for i, n := range c.Notifiers {
 		if n.Type == "" {
 			return fmt.Errorf("notifier[%d]: type is required", i)
 		}
 		if n.Enabled {
 			hasEnabled = true
+			enabled[n.DisplayName()] = true
+		}
+		if err := n.Retry.validate(); err != nil {
+			return fmt.Errorf("notifier[%d]: retry: %w", i, err)
+		}
+		if err := validateMatches("match", n.Match); err != nil {
+			return fmt.Errorf("notifier[%d]: %w", i, err)
+		}
+		if err := n.RateLimit.validate(); err != nil {
+			return fmt.Errorf("notifier[%d]: rate_limit: %w", i, err)
+		}
+		if err := n.Digest.validate(); err != nil {
+			return fmt.Errorf("notifier[%d]: digest: %w", i, err)
 		}
 	}
 
-	if !hasEnabled {
+	if !hasEnabled && !c.Agent.Enabled() {
 		return fmt.Errorf("at least one notifier must be enabled")
 	}
 
+	if err := validateMatches("filters.ignore", c.Filters.Ignore); err != nil {
+		return err
+	}
+	if err := validateMatches("filters.only", c.Filters.Only); err != nil {
+		return err
+	}
+
+	if err := c.Dedup.validate(); err != nil {
+		return fmt.Errorf("dedup: %w", err)
+	}
+
+	if c.Detection.BruteForce.Threshold < 0 {
+		return fmt.Errorf("detection.brute_force: threshold must not be negative")
+	}
+	if c.Detection.BruteForce.Window < 0 {
+		return fmt.Errorf("detection.brute_force: window must not be negative")
+	}
+	if c.Detection.Logout.ShorterThan < 0 || c.Detection.Logout.LongerThan < 0 {
+		return fmt.Errorf("detection.logout: thresholds must not be negative")
+	}
+	for _, name := range c.Detection.PrivilegeEscalation.Notifiers {
+		if !enabled[name] {
+			return fmt.Errorf("detection.privilege_escalation: notifiers: no enabled notifier named %q", name)
+		}
+	}
+
+	if err := c.Watcher.validate(); err != nil {
+		return fmt.Errorf("watcher: %w", err)
+	}
+	if c.Watcher.Type == WatcherFile && len(c.Rules) == 0 {
+		return fmt.Errorf("watcher: type file needs rules")
+	}
+	if len(c.Sources) > 0 && c.Watcher.Type != "" {
+		return fmt.Errorf("watcher and sources cannot both be set")
+	}
+	sources := make(map[string]bool)
+	for i, src := range c.Sources {
+		name := src.SourceName()
+		if err := src.validate(); err != nil {
+			return fmt.Errorf("sources[%d] (%s): %w", i, name, err)
+		}
+		if src.Type == WatcherNone {
+			return fmt.Errorf("sources[%d] (%s): type none is only valid for watcher", i, name)
+		}
+		if src.Type == WatcherFile && len(src.Rules) == 0 && len(c.Rules) == 0 {
+			return fmt.Errorf("sources[%d] (%s): type file needs rules", i, name)
+		}
+		if sources[name] {
+			return fmt.Errorf("sources[%d]: duplicate source name %q", i, name)
+		}
+		sources[name] = true
+		if err := validateRules(src.Rules); err != nil {
+			return fmt.Errorf("sources[%d] (%s): %w", i, name, err)
+		}
+	}
+	if c.SourceHealthInterval < 0 {
+		return fmt.Errorf("source_health_interval must not be negative")
+	}
+
+	if err := c.Delivery.Retry.validate(); err != nil {
+		return fmt.Errorf("delivery: retry: %w", err)
+	}
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown_timeout must not be negative")
+	}
+
+	if err := c.Server.validate(); err != nil {
+		return err
+	}
+	if err := c.Agent.validate(); err != nil {
+		return err
+	}
+	if c.Watcher.Type == WatcherNone && !c.Server.Enabled() {
+		return fmt.Errorf("watcher: type none requires server mode")
+	}
+
+	if err := validateRules(c.Rules); err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -2,7 +2,9 @@ package config
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -83,6 +85,463 @@ func TestValidateConfig(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "rule with invalid pattern",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Rules: []RuleConfig{{Name: "broken", Pattern: "(unclosed"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "rule with unknown field",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Rules: []RuleConfig{{Name: "x", Pattern: "x", Fields: map[string]string{"uid": "$1"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate rule names",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Rules: []RuleConfig{{Name: "x", Pattern: "x"}, {Name: "x", Pattern: "y"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "disabled built-in rule needs no pattern",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Rules: []RuleConfig{{Name: "pam-session", Disabled: true}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown watcher type",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Watcher: WatcherConfig{Type: "syslog-ng"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "sources",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Sources: []SourceConfig{
+					{WatcherConfig: WatcherConfig{Type: WatcherAuthLog}},
+					{Name: "vpn", WatcherConfig: WatcherConfig{Type: WatcherFile, Path: "/var/log/vpn.log"},
+						Rules: []RuleConfig{{Name: "vpn", Pattern: `user (?P<user>\S+) connected`}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "duplicate source names",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Sources: []SourceConfig{
+					{WatcherConfig: WatcherConfig{Type: WatcherWtmp}},
+					{WatcherConfig: WatcherConfig{Type: WatcherWtmp, Path: "/var/log/wtmp.1"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "file source without rules",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Sources: []SourceConfig{{WatcherConfig: WatcherConfig{Type: WatcherFile, Path: "/var/log/app.log"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "watcher and sources",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Watcher: WatcherConfig{Type: WatcherJournal},
+				Sources: []SourceConfig{{WatcherConfig: WatcherConfig{Type: WatcherWtmp}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "syslog watcher",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Watcher: WatcherConfig{Type: WatcherSyslog, Syslog: SyslogConfig{Protocol: SyslogTCP, Allow: []string{"10.0.0.0/8", "192.0.2.1"}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "syslog tls without certificate",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Watcher: WatcherConfig{Type: WatcherSyslog, Syslog: SyslogConfig{Protocol: SyslogTLS}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "syslog invalid allow",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Watcher: WatcherConfig{Type: WatcherSyslog, Syslog: SyslogConfig{Allow: []string{"10.0.0.0/33"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "privilege escalation to named notifier",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Name: "Security", Enabled: true},
+					{Type: "slack", Enabled: true},
+				},
+				Detection: DetectionConfig{PrivilegeEscalation: PrivilegeEscalationConfig{Notifiers: []string{"Security", "Slack"}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "privilege escalation to unknown notifier",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Name: "Security", Enabled: true},
+				},
+				Detection: DetectionConfig{PrivilegeEscalation: PrivilegeEscalationConfig{Notifiers: []string{"Securty"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "privilege escalation to disabled notifier",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+					{Type: "slack", Name: "Security", Enabled: false},
+				},
+				Detection: DetectionConfig{PrivilegeEscalation: PrivilegeEscalationConfig{Notifiers: []string{"Security"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "agent without notifiers",
+			config: Config{
+				Agent: AgentConfig{Server: "https://whozere.example.com:8443", Token: "secret"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "agent without credentials",
+			config: Config{
+				Agent: AgentConfig{Server: "https://whozere.example.com:8443"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "agent with invalid server",
+			config: Config{
+				Agent: AgentConfig{Server: "whozere.example.com", Token: "secret"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "agent token over http",
+			config: Config{
+				Agent: AgentConfig{Server: "http://whozere.example.com:8443", Token: "secret"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "agent token over http, insecure",
+			config: Config{
+				Agent: AgentConfig{Server: "http://whozere.example.com:8443", Token: "secret", Insecure: true},
+			},
+			wantErr: false,
+		},
+		{
+			name: "server without authentication",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Server: ServerConfig{Listen: ":8443"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "server only",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Server:  ServerConfig{Listen: ":8443", Token: "secret", CertFile: "server.crt", KeyFile: "server.key"},
+				Watcher: WatcherConfig{Type: WatcherNone},
+			},
+			wantErr: false,
+		},
+		{
+			name: "server token without tls",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Server:  ServerConfig{Listen: ":8443", Token: "secret"},
+				Watcher: WatcherConfig{Type: WatcherNone},
+			},
+			wantErr: true,
+		},
+		{
+			name: "server token without tls, insecure",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Server:  ServerConfig{Listen: ":8443", Token: "secret", Insecure: true},
+				Watcher: WatcherConfig{Type: WatcherNone},
+			},
+			wantErr: false,
+		},
+		{
+			name: "no watcher without server",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Watcher: WatcherConfig{Type: WatcherNone},
+			},
+			wantErr: true,
+		},
+		{
+			name: "auditd watcher",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Watcher: WatcherConfig{Type: WatcherAuditd, Path: "/var/log/audit/audit.log"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "notifier retry",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true, Retry: RetryConfig{MaxAttempts: 10, InitialDelay: time.Second, MaxDelay: time.Hour}},
+				},
+				Delivery: DeliveryConfig{Outbox: "/var/lib/whozere/outbox"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "notifier retry with negative attempts",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true, Retry: RetryConfig{MaxAttempts: -1}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "notifier match",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "telegram", Enabled: true, Match: []MatchConfig{
+						{Users: []string{"root"}, IPs: []string{"10.0.0.0/8", "192.0.2.1"}, Hours: "22:00-06:00"},
+						{Kinds: []string{"integrity"}},
+					}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "notifier match with invalid CIDR",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "telegram", Enabled: true, Match: []MatchConfig{{IPs: []string{"10.0.0.0/40"}}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "notifier match with invalid hours",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "telegram", Enabled: true, Match: []MatchConfig{{Hours: "18:00"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "filters ignore and only",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Filters: FilterConfig{
+					Ignore: []MatchConfig{{Users: []string{"deploy-*"}, Days: []string{"mon-fri"}, Hours: "02:00-03:00"}},
+					Only:   []MatchConfig{{IPs: []string{"10.0.0.0/8"}}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "filters with invalid regex",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Filters: FilterConfig{Ignore: []MatchConfig{{Hosts: []string{"re:web(1"}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "filters with unknown day",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Filters: FilterConfig{Only: []MatchConfig{{Days: []string{"someday"}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "filters with when expression",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true, Match: []MatchConfig{{When: `severity >= "high" || fields.has("command")`}}},
+				},
+				Filters: FilterConfig{Ignore: []MatchConfig{{When: `kind == "login" && user == "root" && !ip.in("10.0.0.0/8")`}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "filters with invalid when expression",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Filters: FilterConfig{Only: []MatchConfig{{When: `kind == "logon"`}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "dedup and rate limit",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true, RateLimit: RateLimitConfig{Rate: 10, Per: time.Minute, By: []string{"user", "ip"}}},
+				},
+				Dedup: DedupConfig{Window: 10 * time.Second, Fields: []string{"kind", "user", "fields.method"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "dedup with unknown field",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Dedup: DedupConfig{Window: time.Minute, Fields: []string{"username"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "geoip filters",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true, Match: []MatchConfig{{Countries: []string{"cn"}, ASNs: []int{4134}}}},
+				},
+				GeoIP:   GeoIPConfig{Database: "/usr/share/GeoIP/GeoLite2-City.mmdb"},
+				Filters: FilterConfig{Ignore: []MatchConfig{{Countries: []string{"CN", "US"}}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid country code",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Filters: FilterConfig{Only: []MatchConfig{{Countries: []string{"USA"}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "digest",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "slack", Enabled: true, Digest: DigestConfig{Interval: 15 * time.Minute, MaxEvents: 50}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "digest with negative interval",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "slack", Enabled: true, Digest: DigestConfig{Interval: -time.Minute}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "digest with unknown bypass severity",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "slack", Enabled: true, Digest: DigestConfig{Interval: time.Minute, BypassSeverity: "urgent"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative rate limit",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true, RateLimit: RateLimitConfig{Rate: -1}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative shutdown timeout",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				ShutdownTimeout: -time.Second,
+			},
+			wantErr: true,
+		},
+		{
+			name: "delivery retry initial delay above max delay",
+			config: Config{
+				Notifiers: []NotifierConfig{
+					{Type: "webhook", Enabled: true},
+				},
+				Delivery: DeliveryConfig{Retry: RetryConfig{InitialDelay: time.Hour, MaxDelay: time.Minute}},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -94,3 +553,328 @@ func TestValidateConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadDetectionConfig(t *testing.T) {
+	content := `
+notifiers:
+  - type: webhook
+    enabled: true
+    config:
+      url: "https://example.com/webhook"
+detection:
+  notify_failed_auth: true
+  brute_force:
+    enabled: true
+    threshold: 10
+    window: 2m
+`
+	tmpfile, err := os.CreateTemp("", "config*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	cfg, err := Load(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if !cfg.Detection.NotifyFailedAuth {
+		t.Error("Expected notify_failed_auth to be true")
+	}
+	if !cfg.Detection.BruteForce.Enabled {
+		t.Error("Expected brute_force to be enabled")
+	}
+	if cfg.Detection.BruteForce.Threshold != 10 {
+		t.Errorf("Expected threshold 10, got %d", cfg.Detection.BruteForce.Threshold)
+	}
+	if cfg.Detection.BruteForce.Window != 2*time.Minute {
+		t.Errorf("Expected window 2m, got %v", cfg.Detection.BruteForce.Window)
+	}
+}
+
+func TestLoadSourcesConfig(t *testing.T) {
+	content := `
+notifiers:
+  - type: webhook
+    enabled: true
+    config:
+      url: "https://example.com/webhook"
+sources:
+  - type: authlog
+  - name: vpn
+    type: file
+    path: /var/log/vpn.log
+    rules:
+      - name: vpn-login
+        pattern: 'user (?P<user>\S+) connected from (?P<ip>\S+)'
+source_health_interval: 1h
+`
+	tmpfile, err := os.CreateTemp("", "config*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	cfg, err := Load(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() failed: %v", err)
+	}
+
+	if len(cfg.Sources) != 2 {
+		t.Fatalf("Expected 2 sources, got %d", len(cfg.Sources))
+	}
+	if name := cfg.Sources[0].SourceName(); name != "authlog" {
+		t.Errorf("Expected default name 'authlog', got '%s'", name)
+	}
+	vpn := cfg.Sources[1]
+	if vpn.SourceName() != "vpn" || vpn.Type != WatcherFile || vpn.Path != "/var/log/vpn.log" {
+		t.Errorf("Unexpected source: %+v", vpn)
+	}
+	if len(vpn.Rules) != 1 || vpn.Rules[0].Name != "vpn-login" {
+		t.Errorf("Expected the vpn-login rule, got %+v", vpn.Rules)
+	}
+	if cfg.SourceHealthInterval != time.Hour {
+		t.Errorf("Expected source_health_interval 1h, got %v", cfg.SourceHealthInterval)
+	}
+}
+
+func TestLogoutShouldNotify(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      LogoutConfig
+		duration time.Duration
+		want     bool
+	}{
+		{"disabled", LogoutConfig{}, time.Second, false},
+		{"all logouts", LogoutConfig{Notify: true}, time.Hour, true},
+		{"short session", LogoutConfig{Notify: true, ShorterThan: 10 * time.Second}, 2 * time.Second, true},
+		{"normal session with short threshold", LogoutConfig{Notify: true, ShorterThan: 10 * time.Second}, time.Hour, false},
+		{"long session", LogoutConfig{Notify: true, LongerThan: 8 * time.Hour}, 9 * time.Hour, true},
+		{"between thresholds", LogoutConfig{Notify: true, ShorterThan: 10 * time.Second, LongerThan: 8 * time.Hour}, time.Hour, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cfg.ShouldNotify(tt.duration); got != tt.want {
+				t.Errorf("ShouldNotify(%v) = %v, want %v", tt.duration, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShouldIgnoreAuth(t *testing.T) {
+	f := FilterConfig{IgnoreMethods: []string{"publickey", "keyboard-interactive"}}
+
+	tests := []struct {
+		method string
+		owner  string
+		want   bool
+	}{
+		{"publickey", "", true},
+		{"keyboard-interactive/pam", "", true},
+		{"password", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := f.ShouldIgnoreAuth(tt.method, tt.owner); got != tt.want {
+			t.Errorf("ShouldIgnoreAuth(%q, %q) = %v, want %v", tt.method, tt.owner, got, tt.want)
+		}
+	}
+
+	f = FilterConfig{IgnoreKnownKeys: true}
+	if !f.ShouldIgnoreAuth("publickey", "alice laptop") {
+		t.Error("Expected login with a known key to be ignored")
+	}
+	if f.ShouldIgnoreAuth("publickey", "") {
+		t.Error("Expected login with an unknown key not to be ignored")
+	}
+}
+
+func TestPrivilegeEscalationShouldNotify(t *testing.T) {
+	p := PrivilegeEscalationConfig{
+		Notify:         true,
+		IgnoreUsers:    []string{"ansible"},
+		IgnoreTargets:  []string{"postgres"},
+		IgnoreCommands: []string{"/usr/bin/systemctl status *", "*/check_disk"},
+	}
+
+	tests := []struct {
+		user, target, command string
+		want                  bool
+	}{
+		{"alice", "root", "/usr/bin/apt update", true},
+		{"ansible", "root", "/bin/sh -c echo", false},
+		{"alice", "postgres", "/usr/bin/psql", false},
+		{"alice", "root", "/usr/bin/systemctl status nginx", false},
+		{"nagios", "root", "/usr/lib/nagios/plugins/check_disk", false},
+		{"alice", "root", "/usr/bin/systemctl restart nginx", true},
+		{"alice", "root", "", true},
+	}
+	for _, tt := range tests {
+		if got := p.ShouldNotify(tt.user, tt.target, tt.command); got != tt.want {
+			t.Errorf("ShouldNotify(%q, %q, %q) = %v, want %v", tt.user, tt.target, tt.command, got, tt.want)
+		}
+	}
+
+	p.Notify = false
+	if p.ShouldNotify("alice", "root", "/bin/bash") {
+		t.Error("Expected no notification when disabled")
+	}
+}
+
+func TestPrivilegeEscalationRouteMatch(t *testing.T) {
+	slack := NotifierConfig{Type: "slack", Match: []MatchConfig{{Users: []string{"root"}}, {When: `hour < 8`}}}
+	var p PrivilegeEscalationConfig
+	if got := p.RouteMatch(slack); len(got) != 2 || got[0].When != "" {
+		t.Errorf("Expected the match entries unchanged without a notifiers list, got %+v", got)
+	}
+
+	p.Notifiers = []string{"security"}
+	if got := p.RouteMatch(NotifierConfig{Type: "webhook", Name: "security"}); got != nil {
+		t.Errorf("Expected a listed notifier to keep receiving every event, got %+v", got)
+	}
+	got := p.RouteMatch(slack)
+	if len(got) != 2 || got[0].When != `kind != "privilege_escalation"` || got[1].When != `(hour < 8) && kind != "privilege_escalation"` {
+		t.Errorf("Expected privilege escalation excluded from every entry, got %+v", got)
+	}
+	if slack.Match[1].When != `hour < 8` {
+		t.Error("Expected the notifier's own entries to be left alone")
+	}
+	if got := p.RouteMatch(NotifierConfig{Type: "email"}); len(got) != 1 || got[0].When != `kind != "privilege_escalation"` {
+		t.Errorf("Expected one entry excluding privilege escalation, got %+v", got)
+	}
+}
+
+func TestRetryWithDefaults(t *testing.T) {
+	defaults := RetryConfig{MaxAttempts: 3, InitialDelay: time.Second}
+
+	got := RetryConfig{MaxAttempts: 8}.WithDefaults(defaults)
+	want := RetryConfig{MaxAttempts: 8, InitialDelay: time.Second, MaxDelay: DefaultMaxDelay}
+	if got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	got = RetryConfig{}.WithDefaults(RetryConfig{})
+	want = RetryConfig{MaxAttempts: DefaultMaxAttempts, InitialDelay: DefaultInitialDelay, MaxDelay: DefaultMaxDelay}
+	if got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestParseHours(t *testing.T) {
+	tests := []struct {
+		window     string
+		start, end time.Duration
+		wantErr    bool
+	}{
+		{"09:00-18:00", 9 * time.Hour, 18 * time.Hour, false},
+		{"22:30 - 06:00", 22*time.Hour + 30*time.Minute, 6 * time.Hour, false},
+		{"00:00-24:00", 0, 24 * time.Hour, false},
+		{"09:00", 0, 0, true},
+		{"09:00-25:00", 0, 0, true},
+		{"09:00-09:00", 0, 0, true},
+	}
+	for _, tt := range tests {
+		start, end, err := ParseHours(tt.window)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseHours(%q) error = %v, wantErr %v", tt.window, err, tt.wantErr)
+			continue
+		}
+		if start != tt.start || end != tt.end {
+			t.Errorf("ParseHours(%q) = %v-%v, expected %v-%v", tt.window, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"root", "root", true},
+		{"root", "rooted", false},
+		{"a.b", "axb", false},
+		{"dev-*", "dev-alice", true},
+		{"dev-*", "prod-alice", false},
+		{"pts/*", "pts/12", true},
+		{"user?", "user1", true},
+		{"user?", "user12", false},
+		{"web[0-9]", "web7", true},
+		{"web[!0-9]", "web7", false},
+		{"张*", "张三", true},
+		{"张?", "张三", true},
+		{"*三", "李四", false},
+		{"[张李]四", "李四", true},
+		{"café", "café", true},
+		{"re:^deploy-\\d+$", "deploy-42", true},
+		{"re:admin", "sysadmin", true},
+	}
+	for _, tt := range tests {
+		re, err := CompilePattern(tt.pattern)
+		if err != nil {
+			t.Errorf("CompilePattern(%q) failed: %v", tt.pattern, err)
+			continue
+		}
+		if got := re.MatchString(tt.s); got != tt.want {
+			t.Errorf("%q matching %q = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"re:(", "web[0-9"} {
+		if _, err := CompilePattern(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestValidateWhenPosition(t *testing.T) {
+	cfg := Config{
+		Notifiers: []NotifierConfig{{Type: "webhook", Enabled: true}},
+		Filters:   FilterConfig{Ignore: []MatchConfig{{When: `user == "root" && kind == "logon"`}}},
+	}
+	err := cfg.Validate()
+	want := `filters.ignore[0]: when: 1:27: unknown value "logon"`
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Expected an error containing %q, got %v", want, err)
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	days, err := ParseDays([]string{"mon-wed", "Sat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		want := d >= time.Monday && d <= time.Wednesday || d == time.Saturday
+		if days[d] != want {
+			t.Errorf("Expected %v for %s, got %v", want, d, days[d])
+		}
+	}
+
+	days, err = ParseDays([]string{"fri-mon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(days) != 4 || !days[time.Sunday] || days[time.Tuesday] {
+		t.Errorf("Expected fri-mon to wrap over the weekend, got %v", days)
+	}
+
+	if _, err := ParseDays([]string{"monday"}); err == nil {
+		t.Error("Expected an error for an unknown day")
+	}
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// DeliveryConfig controls how notifications are retried and kept
+type DeliveryConfig struct {
+	// Outbox is a directory holding notifications not yet delivered, so
+	// they survive restarts; empty keeps them in memory only
+	Outbox string `yaml:"outbox"`
+	// DeadLetter is a file that notifications which could never be
+	// delivered are appended to (JSON lines); empty only logs them
+	DeadLetter string `yaml:"dead_letter"`
+	// Retry applies to notifiers without retry settings of their own
+	Retry RetryConfig `yaml:"retry"`
+}
+
+// RetryConfig controls retries of failed notifications
+// Delays double after each failure, with random jitter
+type RetryConfig struct {
+	// MaxAttempts is how many times a notification is tried (default 5);
+	// 1 disables retries
+	MaxAttempts int `yaml:"max_attempts"`
+	// InitialDelay is the delay before the first retry (default 5s)
+	InitialDelay time.Duration `yaml:"initial_delay"`
+	// MaxDelay caps the delay between attempts (default 10m)
+	MaxDelay time.Duration `yaml:"max_delay"`
+}
+
+// Default retry settings
+const (
+	DefaultMaxAttempts  = 5
+	DefaultInitialDelay = 5 * time.Second
+	DefaultMaxDelay     = 10 * time.Minute
+)
+
+// WithDefaults fills unset values from defaults, then from the built-in
+// defaults
+func (r RetryConfig) WithDefaults(defaults RetryConfig) RetryConfig {
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = defaults.MaxAttempts
+	}
+	if r.InitialDelay == 0 {
+		r.InitialDelay = defaults.InitialDelay
+	}
+	if r.MaxDelay == 0 {
+		r.MaxDelay = defaults.MaxDelay
+	}
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = DefaultMaxAttempts
+	}
+	if r.InitialDelay <= 0 {
+		r.InitialDelay = DefaultInitialDelay
+	}
+	if r.MaxDelay <= 0 {
+		r.MaxDelay = DefaultMaxDelay
+	}
+	return r
+}
+
+func (r RetryConfig) validate() error {
+	if r.MaxAttempts < 0 {
+		return fmt.Errorf("max_attempts must not be negative")
+	}
+	if r.InitialDelay < 0 || r.MaxDelay < 0 {
+		return fmt.Errorf("delays must not be negative")
+	}
+	if r.MaxDelay > 0 && r.InitialDelay > r.MaxDelay {
+		return fmt.Errorf("initial_delay must not exceed max_delay")
+	}
+	return nil
+}
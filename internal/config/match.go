@@ -0,0 +1,257 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/xsddz/whozere/internal/expr"
+)
+
+// EventKinds lists the known event kinds
+var EventKinds = []string{"login", "logout", "failed_auth", "brute_force", "privilege_escalation", "integrity", "agent_silent", "digest"}
+
+// Severities lists the known severities from least to most urgent
+var Severities = []string{"info", "low", "medium", "high", "critical"}
+
+// EventSchema declares the event fields available to "when" expressions
+var EventSchema = expr.Schema{
+	"kind":     expr.Enum(EventKinds...),
+	"severity": expr.OrderedEnum(Severities...),
+	"user":     expr.String,
+	"host":     expr.String,
+	"ip":       expr.IP,
+	"terminal": expr.String,
+	"source":   expr.String,
+	"os":       expr.String,
+	"detail":   expr.String,
+	"id":       expr.String,
+	"fields":   expr.Map,
+	"hour":     expr.Int,
+	"weekday":  expr.Enum("sun", "mon", "tue", "wed", "thu", "fri", "sat"),
+	"country":  expr.String,
+	"city":     expr.String,
+	"asn":      expr.Int,
+	"as_org":   expr.String,
+}
+
+// MatchConfig selects events by their fields
+// Every condition that is set must hold; a list condition holds if any
+// of its entries does. Users, terminals and hosts are patterns (see
+// CompilePattern)
+type MatchConfig struct {
+	// Users are the usernames to match
+	Users []string `yaml:"users"`
+	// Terminals are the terminals to match (e.g. ssh, pts/*, cron)
+	Terminals []string `yaml:"terminals"`
+	// IPs are the source addresses or CIDRs to match; events without an
+	// address never match
+	IPs []string `yaml:"ips"`
+	// Kinds are the event kinds to match (login, failed_auth, integrity, ...)
+	Kinds []string `yaml:"kinds"`
+	// MinSeverity matches events at least this severe
+	MinSeverity string `yaml:"min_severity"`
+	// Hosts are the hostnames to match
+	Hosts []string `yaml:"hosts"`
+	// Hours matches events in a local time-of-day window ("09:00-18:00"),
+	// which may wrap past midnight ("22:00-06:00")
+	Hours string `yaml:"hours"`
+	// Days matches events on these local weekdays: mon, tue, ... or
+	// ranges such as mon-fri
+	Days []string `yaml:"days"`
+	// Countries are the ISO codes of the countries to match (e.g. CN, US),
+	// from GeoIP; events without a known country never match
+	Countries []string `yaml:"countries"`
+	// ASNs are the autonomous system numbers to match, from GeoIP
+	ASNs []int `yaml:"asns"`
+	// When is an expression over the event fields (see EventSchema), e.g.
+	// kind == "login" && user == "root" && !ip.in("10.0.0.0/8")
+	When string `yaml:"when"`
+}
+
+// validate checks patterns, addresses, countries, ASNs, hours, days and
+// when; kinds and severities are checked when the match is compiled
+func (m *MatchConfig) validate() error {
+	for _, list := range []struct {
+		field    string
+		patterns []string
+	}{{"users", m.Users}, {"terminals", m.Terminals}, {"hosts", m.Hosts}} {
+		for _, p := range list.patterns {
+			if _, err := CompilePattern(p); err != nil {
+				return fmt.Errorf("%s: %w", list.field, err)
+			}
+		}
+	}
+	for _, ip := range m.IPs {
+		if _, err := ParsePrefix(ip); err != nil {
+			return fmt.Errorf("ips: invalid address or CIDR %q", ip)
+		}
+	}
+	for _, c := range m.Countries {
+		if !isCountryCode(c) {
+			return fmt.Errorf("countries: invalid country code %q (expected two letters, e.g. US)", c)
+		}
+	}
+	for _, asn := range m.ASNs {
+		if asn <= 0 {
+			return fmt.Errorf("asns: invalid ASN %d", asn)
+		}
+	}
+	if m.Hours != "" {
+		if _, _, err := ParseHours(m.Hours); err != nil {
+			return fmt.Errorf("hours: %w", err)
+		}
+	}
+	if _, err := ParseDays(m.Days); err != nil {
+		return fmt.Errorf("days: %w", err)
+	}
+	if m.When != "" {
+		if _, err := expr.Compile(m.When, EventSchema); err != nil {
+			return fmt.Errorf("when: %w", err)
+		}
+	}
+	return nil
+}
+
+// isCountryCode reports whether s is a two-letter country code
+func isCountryCode(s string) bool {
+	if len(s) != 2 {
+		return false
+	}
+	for _, c := range strings.ToUpper(s) {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// CompilePattern compiles a pattern matched against a whole string:
+//   - "re:" followed by a regular expression, which may match anywhere
+//     (anchor it with ^ and $)
+//   - a glob, if it contains *, ? or [...]; * and ? match any characters,
+//     including "/", and [!...] negates a class
+//   - otherwise the literal string
+func CompilePattern(pattern string) (*regexp.Regexp, error) {
+	if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", expr, err)
+		}
+		return re, nil
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid glob %q: unterminated [", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if negated, ok := strings.CutPrefix(class, "!"); ok {
+				class = "^" + negated
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			// Quote the literal run as a whole, keeping multibyte runes intact
+			end := strings.IndexAny(pattern[i:], "*?[")
+			if end < 0 {
+				end = len(pattern) - i
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+end]))
+			i += end - 1
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+	}
+	return re, nil
+}
+
+// weekdays maps day names to weekdays
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseDays parses weekday names ("mon") and ranges ("mon-fri", which may
+// wrap: "fri-mon") into the set of days they cover
+// Returns nil for an empty list
+func ParseDays(days []string) (map[time.Weekday]bool, error) {
+	if len(days) == 0 {
+		return nil, nil
+	}
+	set := make(map[time.Weekday]bool)
+	for _, d := range days {
+		from, to, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(d)), "-")
+		first, ok := weekdays[from]
+		if !ok {
+			return nil, fmt.Errorf("unknown day %q (expected mon, tue, ... or a range like mon-fri)", d)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[to]; !ok {
+				return nil, fmt.Errorf("unknown day %q (expected mon, tue, ... or a range like mon-fri)", d)
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			set[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return set, nil
+}
+
+// ParseHours parses a time-of-day window "HH:MM-HH:MM" into its start and
+// end as offsets from midnight; the end is exclusive
+func ParseHours(s string) (start, end time.Duration, err error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid window %q (expected HH:MM-HH:MM)", s)
+	}
+	if start, err = parseClock(strings.TrimSpace(from)); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseClock(strings.TrimSpace(to)); err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("empty window %q", s)
+	}
+	return start, end, nil
+}
+
+// parseClock parses "HH:MM"; "24:00" is the end of the day
+func parseClock(s string) (time.Duration, error) {
+	if s == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// validateMatches checks the list of match entries named name
+func validateMatches(name string, matches []MatchConfig) error {
+	for i := range matches {
+		if err := matches[i].validate(); err != nil {
+			return fmt.Errorf("%s[%d]: %w", name, i, err)
+		}
+	}
+	return nil
+}
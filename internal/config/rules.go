@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RuleConfig defines a detection rule: a regex over log lines whose
+// capture groups are mapped to event fields
+type RuleConfig struct {
+	// Name identifies the rule; a rule named like a built-in replaces it
+	Name string `yaml:"name"`
+	// Pattern is the regex a line must match
+	// Named groups (?P<user>...) map to the field of the same name
+	Pattern string `yaml:"pattern"`
+	// Exclude skips lines matching this regex even if Pattern matches
+	Exclude string `yaml:"exclude"`
+	// Kind is the event kind (default login)
+	Kind string `yaml:"kind"`
+	// Severity overrides the kind's default severity
+	Severity string `yaml:"severity"`
+	// Fields maps event fields to values, expanded like regexp.Expand:
+	// "$1" or "${user}" refer to capture groups, other text is literal
+	Fields map[string]string `yaml:"fields"`
+	// Detail is an optional description, expanded like Fields
+	Detail string `yaml:"detail"`
+	// Disabled turns off the built-in rule of the same name
+	Disabled bool `yaml:"disabled"`
+}
+
+// Event fields a rule can set
+const (
+	RuleFieldUser     = "user"
+	RuleFieldIP       = "ip"
+	RuleFieldPort     = "port"
+	RuleFieldTerminal = "terminal"
+	RuleFieldMethod   = "method"
+
+	// SSH public key and certificate details
+	RuleFieldKeyType       = "key_type"
+	RuleFieldFingerprint   = "fingerprint"
+	RuleFieldCertID        = "cert_id"
+	RuleFieldCertSerial    = "cert_serial"
+	RuleFieldCAFingerprint = "ca_fingerprint"
+
+	// Privilege escalation details
+	RuleFieldTargetUser = "target_user"
+	RuleFieldCommand    = "command"
+)
+
+// RuleFields lists the event fields a rule can set
+var RuleFields = []string{
+	RuleFieldUser, RuleFieldIP, RuleFieldPort, RuleFieldTerminal, RuleFieldMethod,
+	RuleFieldKeyType, RuleFieldFingerprint, RuleFieldCertID, RuleFieldCertSerial, RuleFieldCAFingerprint,
+	RuleFieldTargetUser, RuleFieldCommand,
+}
+
+// validateRules checks rule names, patterns and field mappings
+// Kinds and severities are checked when the watcher compiles the rules
+func validateRules(rules []RuleConfig) error {
+	names := make(map[string]bool)
+	for i, r := range rules {
+		if r.Name == "" {
+			return fmt.Errorf("rules[%d]: name is required", i)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("rules[%d]: duplicate rule name %q", i, r.Name)
+		}
+		names[r.Name] = true
+
+		if r.Disabled {
+			continue
+		}
+		if r.Pattern == "" {
+			return fmt.Errorf("rules[%d] (%s): pattern is required", i, r.Name)
+		}
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("rules[%d] (%s): invalid pattern: %w", i, r.Name, err)
+		}
+		if r.Exclude != "" {
+			if _, err := regexp.Compile(r.Exclude); err != nil {
+				return fmt.Errorf("rules[%d] (%s): invalid exclude: %w", i, r.Name, err)
+			}
+		}
+		for field := range r.Fields {
+			if !isRuleField(field) {
+				return fmt.Errorf("rules[%d] (%s): unknown field %q (expected one of %v)", i, r.Name, field, RuleFields)
+			}
+		}
+	}
+	return nil
+}
+
+func isRuleField(field string) bool {
+	for _, f := range RuleFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// ServerConfig enables server mode: events from remote agents are
+// received over HTTPS and sent through this instance's notifiers
+type ServerConfig struct {
+	// Listen is the address of the agent API (e.g. ":8443")
+	// Empty disables server mode
+	Listen string `yaml:"listen"`
+	// Token is a shared secret agents send as a bearer token
+	// Tokens are shared trust: an agent using one may report under any
+	// name, while a client certificate binds the name to its common name
+	Token string `yaml:"token"`
+	// CertFile and KeyFile serve the API over TLS; they are required with
+	// a token, which would otherwise be sent in the clear
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// Insecure allows a token over plain HTTP, e.g. behind a TLS proxy
+	Insecure bool `yaml:"insecure"`
+	// ClientCAFile requires agents to present a certificate signed by one
+	// of its CAs (mTLS); the certificate's common name is the agent name
+	ClientCAFile string `yaml:"client_ca_file"`
+	// SilentAfter is how long an agent may go without reporting before
+	// an alert is raised (default 5m)
+	SilentAfter time.Duration `yaml:"silent_after"`
+	// Agents lists agents expected to report, so that one which never
+	// connects after a server restart is noticed too
+	Agents []string `yaml:"agents"`
+	// DedupWindow is how long received events are remembered to drop
+	// duplicates, e.g. resent after a timeout (default 10m)
+	DedupWindow time.Duration `yaml:"dedup_window"`
+}
+
+// Default server settings
+const (
+	DefaultSilentAfter = 5 * time.Minute
+	DefaultDedupWindow = 10 * time.Minute
+)
+
+// Enabled reports whether server mode is configured
+func (s ServerConfig) Enabled() bool {
+	return s.Listen != ""
+}
+
+// AgentConfig enables agent mode: events are forwarded to a whozere
+// server, which notifies centrally
+type AgentConfig struct {
+	// Server is the base URL of the server (e.g. https://whozere.example.com:8443)
+	// Empty disables agent mode
+	Server string `yaml:"server"`
+	// Name identifies this agent (default: hostname)
+	// Ignored by servers using mTLS, which take the certificate's name
+	Name string `yaml:"name"`
+	// Token is the server's shared secret; it requires an https server
+	Token string `yaml:"token"`
+	// Insecure allows a token over plain HTTP
+	Insecure bool `yaml:"insecure"`
+	// CAFile verifies the server certificate (default: system roots)
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are the client certificate for mTLS
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// Heartbeat is how often the agent reports in when idle (default 1m)
+	Heartbeat time.Duration `yaml:"heartbeat"`
+}
+
+// DefaultHeartbeat is the default agent heartbeat interval
+const DefaultHeartbeat = time.Minute
+
+// Enabled reports whether agent mode is configured
+func (a AgentConfig) Enabled() bool {
+	return a.Server != ""
+}
+
+// validate checks that the server authenticates agents
+func (s ServerConfig) validate() error {
+	if !s.Enabled() {
+		return nil
+	}
+	if s.Token == "" && s.ClientCAFile == "" {
+		return fmt.Errorf("server: token or client_ca_file is required")
+	}
+	if (s.CertFile == "") != (s.KeyFile == "") {
+		return fmt.Errorf("server: cert_file and key_file must be set together")
+	}
+	if s.ClientCAFile != "" && s.CertFile == "" {
+		return fmt.Errorf("server: client_ca_file requires cert_file and key_file")
+	}
+	if s.Token != "" && s.CertFile == "" && !s.Insecure {
+		return fmt.Errorf("server: token requires cert_file and key_file (or insecure: true)")
+	}
+	if s.SilentAfter < 0 || s.DedupWindow < 0 {
+		return fmt.Errorf("server: durations must not be negative")
+	}
+	return nil
+}
+
+// validate checks the server URL and credentials
+func (a AgentConfig) validate() error {
+	if !a.Enabled() {
+		return nil
+	}
+	u, err := url.Parse(a.Server)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("agent: server must be an http(s) URL, got %q", a.Server)
+	}
+	if (a.CertFile == "") != (a.KeyFile == "") {
+		return fmt.Errorf("agent: cert_file and key_file must be set together")
+	}
+	if a.Token == "" && a.CertFile == "" {
+		return fmt.Errorf("agent: token or cert_file is required")
+	}
+	if a.Token != "" && u.Scheme != "https" && !a.Insecure {
+		return fmt.Errorf("agent: token requires an https server (or insecure: true)")
+	}
+	if a.Heartbeat < 0 {
+		return fmt.Errorf("agent: heartbeat must not be negative")
+	}
+	return nil
+}
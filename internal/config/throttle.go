@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// KeyFields are the event fields that identify similar events, besides
+// "fields.<name>" for a structured field
+var KeyFields = []string{"kind", "severity", "user", "host", "ip", "terminal", "source", "os", "detail"}
+
+// DefaultDedupFields identify repeats of an event by default
+var DefaultDedupFields = []string{"kind", "user", "host", "ip"}
+
+// DedupConfig suppresses repeats of an event, such as the several log
+// lines a single SSH login produces
+type DedupConfig struct {
+	// Window is how long repeats are suppressed after an event is
+	// notified; zero disables deduplication
+	Window time.Duration `yaml:"window"`
+	// Fields identify repeats (default kind, user, host, ip); see KeyFields
+	Fields []string `yaml:"fields"`
+}
+
+// RateLimitConfig limits how many notifications a notifier sends, as a
+// token bucket: Burst notifications at once, refilled at Rate per Per
+type RateLimitConfig struct {
+	// Rate is how many notifications may be sent per Per; zero disables
+	// the limit
+	Rate int `yaml:"rate"`
+	// Per is the period of Rate (default 1m)
+	Per time.Duration `yaml:"per"`
+	// Burst is how many notifications may be sent at once (default Rate)
+	Burst int `yaml:"burst"`
+	// By keeps a separate limit per value of these fields (e.g. [user] or
+	// [ip]); empty limits the notifier as a whole. See KeyFields
+	By []string `yaml:"by"`
+}
+
+// DefaultRateLimitPer is the default period of a rate limit
+const DefaultRateLimitPer = time.Minute
+
+// DigestConfig batches events into a single summary message, sent after
+// Interval or once MaxEvents are collected; severe events are still sent
+// right away
+type DigestConfig struct {
+	// Interval is how long events are collected before the digest is sent
+	// (default 15m if only MaxEvents is set)
+	Interval time.Duration `yaml:"interval"`
+	// MaxEvents sends the digest early once this many events are collected
+	MaxEvents int `yaml:"max_events"`
+	// BypassSeverity is the severity from which events skip the digest
+	// (default high)
+	BypassSeverity string `yaml:"bypass_severity"`
+}
+
+// Digest defaults
+const (
+	DefaultDigestInterval       = 15 * time.Minute
+	DefaultDigestBypassSeverity = "high"
+)
+
+// Enabled reports whether events are batched
+func (d DigestConfig) Enabled() bool {
+	return d.Interval > 0 || d.MaxEvents > 0
+}
+
+func (d DigestConfig) validate() error {
+	if d.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	if d.MaxEvents < 0 {
+		return fmt.Errorf("max_events must not be negative")
+	}
+	if d.BypassSeverity != "" && !slices.Contains(Severities, d.BypassSeverity) {
+		return fmt.Errorf("unknown bypass_severity %q (expected one of %s)", d.BypassSeverity, strings.Join(Severities, ", "))
+	}
+	return nil
+}
+
+func (d DedupConfig) validate() error {
+	if d.Window < 0 {
+		return fmt.Errorf("window must not be negative")
+	}
+	return validateKeyFields("fields", d.Fields)
+}
+
+func (r RateLimitConfig) validate() error {
+	if r.Rate < 0 || r.Burst < 0 {
+		return fmt.Errorf("rate and burst must not be negative")
+	}
+	if r.Per < 0 {
+		return fmt.Errorf("per must not be negative")
+	}
+	return validateKeyFields("by", r.By)
+}
+
+// validateKeyFields checks that each entry names a key field
+func validateKeyFields(name string, fields []string) error {
+	for _, f := range fields {
+		if key, ok := strings.CutPrefix(f, "fields."); ok && key != "" {
+			continue
+		}
+		if !slices.Contains(KeyFields, f) {
+			return fmt.Errorf("%s: unknown field %q (expected one of %s or fields.<name>)", name, f, strings.Join(KeyFields, ", "))
+		}
+	}
+	return nil
+}
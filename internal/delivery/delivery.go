@@ -0,0 +1,332 @@
+// Package delivery retries failed notifications with backoff, keeping
+// them in an outbox until they are delivered or given up
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// Manager owns the delivery queues of all notifiers and their shared
+// outbox and dead letter file
+type Manager struct {
+	cfg        config.DeliveryConfig
+	outbox     *outbox
+	deadLetter *deadLetter
+	queues     map[string]*Queue
+
+	// sendCtx bounds attempts; it outlives the ctx passed to Start so that
+	// shutdown does not abort a request midway, and is cancelled by Flush
+	sendCtx     context.Context
+	cancelSends context.CancelFunc
+	started     bool
+}
+
+// New creates a manager, opening the outbox if one is configured
+func New(cfg config.DeliveryConfig) (*Manager, error) {
+	m := &Manager{
+		cfg:        cfg,
+		deadLetter: &deadLetter{path: cfg.DeadLetter},
+		queues:     make(map[string]*Queue),
+	}
+	m.sendCtx, m.cancelSends = context.WithCancel(context.Background())
+	if cfg.Outbox != "" {
+		o, err := openOutbox(cfg.Outbox)
+		if err != nil {
+			return nil, err
+		}
+		m.outbox = o
+	}
+	return m, nil
+}
+
+// Wrap returns a queue delivering to n with the given retry settings
+// (falling back to the delivery defaults)
+// Notifier names must be unique: they tie outbox entries to notifiers
+func (m *Manager) Wrap(n notifier.Notifier, retry config.RetryConfig) (*Queue, error) {
+	if _, ok := m.queues[n.Name()]; ok {
+		return nil, fmt.Errorf("delivery: duplicate notifier name %q", n.Name())
+	}
+	q := &Queue{
+		n:          n,
+		retry:      retry.WithDefaults(m.cfg.Retry),
+		outbox:     m.outbox,
+		deadLetter: m.deadLetter,
+		wake:       make(chan struct{}, 1),
+		stopped:    make(chan struct{}),
+	}
+	m.queues[n.Name()] = q
+	return q, nil
+}
+
+// Start reloads the outbox and starts delivering until ctx is cancelled
+// Entries for notifiers that are no longer configured are given up
+func (m *Manager) Start(ctx context.Context) error {
+	entries, err := m.outbox.load()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		q, ok := m.queues[e.Notifier]
+		if !ok {
+			e.LastError = "notifier no longer configured"
+			m.deadLetter.add(e)
+			m.outbox.remove(e)
+			continue
+		}
+		q.push(e)
+	}
+	if len(entries) > 0 {
+		log.Printf("Resuming delivery of %d notifications from the outbox", len(entries))
+	}
+
+	m.started = true
+	for _, q := range m.queues {
+		go func() {
+			defer close(q.stopped)
+			q.run(ctx, m.sendCtx)
+		}()
+	}
+	return nil
+}
+
+// Flush makes a last attempt at delivering pending notifications, once
+// the ctx passed to Start is done, and returns when they are delivered or
+// ctx is done; attempts still running then are aborted
+// Notifications left over stay in the outbox for the next run
+func (m *Manager) Flush(ctx context.Context) {
+	stop := context.AfterFunc(ctx, m.cancelSends)
+	defer stop()
+	defer m.cancelSends()
+
+	var wg sync.WaitGroup
+	for _, q := range m.queues {
+		wg.Go(func() {
+			// Let an attempt in progress finish first
+			if m.started {
+				select {
+				case <-q.stopped:
+				case <-ctx.Done():
+					return
+				}
+			}
+			q.flush(m.sendCtx)
+		})
+	}
+	wg.Wait()
+
+	left := 0
+	for _, q := range m.queues {
+		left += q.Pending()
+	}
+	switch {
+	case left == 0:
+	case m.outbox != nil:
+		log.Printf("%d notifications not delivered before shutdown, kept in the outbox", left)
+	default:
+		log.Printf("%d notifications not delivered before shutdown were lost", left)
+	}
+}
+
+// Queue delivers events to one notifier in the background, retrying
+// failures with jittered exponential backoff
+// It implements notifier.Notifier: Send only queues the event
+type Queue struct {
+	n          notifier.Notifier
+	retry      config.RetryConfig
+	outbox     *outbox
+	deadLetter *deadLetter
+	wake       chan struct{}
+	stopped    chan struct{} // closed when run returns
+
+	mu      sync.Mutex
+	pending []*entry
+	failing bool // the last attempt failed
+}
+
+// Name returns the name of the wrapped notifier
+func (q *Queue) Name() string {
+	return q.n.Name()
+}
+
+// Send queues event for delivery in the background, so ctx is not used
+// Returns an error only if the event could not be stored in the outbox;
+// it is still delivered while this process runs
+func (q *Queue) Send(_ context.Context, event notifier.LoginEvent) error {
+	now := time.Now()
+	e := &entry{
+		Notifier:    q.n.Name(),
+		Event:       event,
+		NextAttempt: now,
+		Created:     now,
+	}
+	err := q.outbox.save(e)
+	q.push(e)
+	return err
+}
+
+// Pending returns the number of notifications not yet delivered
+func (q *Queue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+func (q *Queue) push(e *entry) {
+	q.mu.Lock()
+	q.pending = append(q.pending, e)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the oldest entry and how long until it is due
+// Later entries wait for it, so that notifications arrive in order
+func (q *Queue) next() (*entry, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		return nil, 0
+	}
+	first := q.pending[0]
+	return first, time.Until(first.NextAttempt)
+}
+
+// run attempts deliveries one at a time, in order, until ctx is cancelled
+func (q *Queue) run(ctx, sendCtx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		e, wait := q.next()
+		if e != nil && wait <= 0 {
+			q.attempt(sendCtx, e)
+			continue
+		}
+
+		var due <-chan time.Time
+		if e != nil {
+			timer.Reset(wait)
+			due = timer.C
+		}
+		select {
+		case <-due:
+		case <-q.wake:
+		case <-ctx.Done():
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// flush attempts every pending entry once, regardless of backoff, in the
+// order they were queued; it stops at the first failure that is not
+// permanent, as the notifier is likely down
+func (q *Queue) flush(ctx context.Context) {
+	q.mu.Lock()
+	pending := append([]*entry(nil), q.pending...)
+	q.mu.Unlock()
+	for _, e := range pending {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := q.attempt(ctx, e); err != nil && !notifier.IsPermanent(err) {
+			return
+		}
+	}
+}
+
+// attempt sends e once and reschedules, completes or gives it up
+// Permanent errors are given up right away; an attempt cut short by ctx
+// does not count
+func (q *Queue) attempt(ctx context.Context, e *entry) error {
+	err := q.n.Send(ctx, e.Event)
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+	e.Attempts++
+
+	// Entries leave the queue last, so that Pending only drops once the
+	// outbox and dead letter file are up to date
+	if err == nil {
+		q.outbox.remove(e)
+		q.remove(e)
+		q.mu.Lock()
+		recovered := q.failing
+		q.failing = false
+		if recovered {
+			// The endpoint is back: drain what piled up right away
+			now := time.Now()
+			for _, p := range q.pending {
+				p.NextAttempt = now
+			}
+		}
+		q.mu.Unlock()
+		return nil
+	}
+
+	e.LastError = err.Error()
+	if notifier.IsPermanent(err) {
+		// Retrying would only hold up the entries queued after it
+		q.deadLetter.add(e)
+		q.outbox.remove(e)
+		q.remove(e)
+		return err
+	}
+	q.mu.Lock()
+	q.failing = true
+	q.mu.Unlock()
+
+	if e.Attempts >= q.retry.MaxAttempts {
+		q.deadLetter.add(e)
+		q.outbox.remove(e)
+		q.remove(e)
+		return err
+	}
+
+	delay := backoff(q.retry, e.Attempts)
+	log.Printf("Failed to send notification via %s (attempt %d/%d), retrying in %v: %v",
+		q.n.Name(), e.Attempts, q.retry.MaxAttempts, delay.Round(time.Second), err)
+	q.mu.Lock()
+	e.NextAttempt = time.Now().Add(delay)
+	q.mu.Unlock()
+	if err := q.outbox.save(e); err != nil {
+		log.Printf("%v", err)
+	}
+	return err
+}
+
+func (q *Queue) remove(e *entry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, p := range q.pending {
+		if p == e {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// backoff returns the delay after the given number of failed attempts:
+// InitialDelay doubled per further attempt, capped at MaxDelay, with the
+// upper half randomized so that many queued alerts do not retry at once
+func backoff(retry config.RetryConfig, attempts int) time.Duration {
+	delay := retry.InitialDelay
+	for i := 1; i < attempts && delay < retry.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, retry.MaxDelay)
+	half := delay / 2
+	return half + rand.N(delay-half+1)
+}
@@ -0,0 +1,321 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// fakeNotifier fails its first failures sends and rejects the events in
+// rejected for good; with hang set, sends block until cancelled
+type fakeNotifier struct {
+	name     string
+	failures int
+	rejected map[string]bool
+	hang     bool
+
+	mu       sync.Mutex
+	attempts int
+	sent     []string
+}
+
+func (f *fakeNotifier) Name() string { return f.name }
+
+func (f *fakeNotifier) Send(ctx context.Context, event notifier.LoginEvent) error {
+	if f.hang {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts++
+	if f.rejected[event.ID] {
+		return notifier.Permanent(errors.New("message too long"))
+	}
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("endpoint unavailable")
+	}
+	f.sent = append(f.sent, event.ID)
+	return nil
+}
+
+func (f *fakeNotifier) delivered() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.sent...)
+}
+
+var fastRetry = config.RetryConfig{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestQueueRetriesUntilDelivered(t *testing.T) {
+	dir := t.TempDir()
+	m, err := New(config.DeliveryConfig{Outbox: filepath.Join(dir, "outbox")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNotifier{name: "slack", failures: 2}
+	q, err := m.Wrap(n, fastRetry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Send(context.Background(), notifier.LoginEvent{ID: "e1", Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Send(context.Background(), notifier.LoginEvent{ID: "e2", Username: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "delivery", func() bool { return len(n.delivered()) == 2 })
+	if got := n.delivered(); got[0] != "e1" || got[1] != "e2" {
+		t.Errorf("Expected e1 then e2, got %v", got)
+	}
+	waitFor(t, "empty queue", func() bool { return q.Pending() == 0 })
+
+	files, _ := os.ReadDir(filepath.Join(dir, "outbox"))
+	if len(files) != 0 {
+		t.Errorf("Expected an empty outbox, got %d files", len(files))
+	}
+}
+
+func TestQueueGivesUpToDeadLetter(t *testing.T) {
+	dir := t.TempDir()
+	deadLetterPath := filepath.Join(dir, "dead.jsonl")
+	m, err := New(config.DeliveryConfig{DeadLetter: deadLetterPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNotifier{name: "webhook", failures: 100}
+	q, err := m.Wrap(n, fastRetry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	q.Send(context.Background(), notifier.LoginEvent{ID: "e1", Username: "alice"})
+
+	waitFor(t, "dead letter", func() bool { return q.Pending() == 0 })
+
+	data, err := os.ReadFile(deadLetterPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected 1 dead letter, got %d", len(lines))
+	}
+	var record deadLetterRecord
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Notifier != "webhook" || record.Attempts != 3 || record.Event.ID != "e1" || record.Error != "endpoint unavailable" {
+		t.Errorf("Unexpected dead letter: %+v", record)
+	}
+}
+
+func TestQueuePermanentFailure(t *testing.T) {
+	dir := t.TempDir()
+	deadLetterPath := filepath.Join(dir, "dead.jsonl")
+	m, err := New(config.DeliveryConfig{DeadLetter: deadLetterPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNotifier{name: "slack", rejected: map[string]bool{"e1": true}}
+	// Retrying e1 would hold up e2 for an hour
+	q, err := m.Wrap(n, config.RetryConfig{MaxAttempts: 5, InitialDelay: time.Hour, MaxDelay: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	q.Send(context.Background(), notifier.LoginEvent{ID: "e1", Username: "alice"})
+	q.Send(context.Background(), notifier.LoginEvent{ID: "e2", Username: "bob"})
+
+	waitFor(t, "delivery", func() bool { return q.Pending() == 0 })
+	if got := n.delivered(); len(got) != 1 || got[0] != "e2" {
+		t.Errorf("Expected e2 to be delivered, got %v", got)
+	}
+	data, err := os.ReadFile(deadLetterPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record deadLetterRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Event.ID != "e1" || record.Attempts != 1 {
+		t.Errorf("Expected e1 given up after 1 attempt, got %+v", record)
+	}
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.DeliveryConfig{
+		Outbox:     filepath.Join(dir, "outbox"),
+		DeadLetter: filepath.Join(dir, "dead.jsonl"),
+	}
+
+	// First run: queued but never started, as if killed right away
+	m, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := m.Wrap(&fakeNotifier{name: "slack"}, fastRetry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Wrap(&fakeNotifier{name: "email"}, fastRetry); err != nil {
+		t.Fatal(err)
+	}
+	q.Send(context.Background(), notifier.LoginEvent{ID: "e1", Username: "alice"})
+	m.queues["email"].Send(context.Background(), notifier.LoginEvent{ID: "e1", Username: "alice"})
+
+	// Second run: slack is still configured, email is not
+	m, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNotifier{name: "slack"}
+	if _, err := m.Wrap(n, fastRetry); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "resumed delivery", func() bool { return len(n.delivered()) == 1 })
+	if got := n.delivered(); got[0] != "e1" {
+		t.Errorf("Expected e1, got %v", got)
+	}
+	data, err := os.ReadFile(cfg.DeadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"notifier":"email"`) {
+		t.Errorf("Expected the email entry to be given up, got %s", data)
+	}
+}
+
+func TestFlushOnShutdown(t *testing.T) {
+	dir := t.TempDir()
+	m, err := New(config.DeliveryConfig{Outbox: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first attempt fails, and the retry is not due before shutdown
+	n := &fakeNotifier{name: "slack", failures: 1}
+	q, err := m.Wrap(n, config.RetryConfig{MaxAttempts: 3, InitialDelay: time.Hour, MaxDelay: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &fakeNotifier{name: "webhook", hang: true}
+	stuck, err := m.Wrap(h, fastRetry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	q.Send(context.Background(), notifier.LoginEvent{ID: "e1", Username: "alice"})
+	stuck.Send(context.Background(), notifier.LoginEvent{ID: "e2", Username: "bob"})
+	waitFor(t, "first attempt", func() bool {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		return n.attempts == 1
+	})
+	cancel()
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelFlush()
+	start := time.Now()
+	m.Flush(flushCtx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Flush to return at the deadline, took %v", elapsed)
+	}
+
+	if got := n.delivered(); len(got) != 1 || got[0] != "e1" {
+		t.Errorf("Expected e1 delivered on flush, got %v", got)
+	}
+	if stuck.Pending() != 1 {
+		t.Errorf("Expected the stuck notification to stay pending, got %d", stuck.Pending())
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("Expected the stuck notification in the outbox, got %d files", len(files))
+	}
+}
+
+func TestWrapDuplicateName(t *testing.T) {
+	m, err := New(config.DeliveryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Wrap(&fakeNotifier{name: "slack"}, config.RetryConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Wrap(&fakeNotifier{name: "slack"}, config.RetryConfig{}); err == nil {
+		t.Error("Expected an error for a duplicate notifier name")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	retry := config.RetryConfig{MaxAttempts: 10, InitialDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		attempts int
+		max      time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{9, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			got := backoff(retry, tt.attempts)
+			if got < tt.max/2 || got > tt.max {
+				t.Errorf("backoff(%d) = %v, expected between %v and %v", tt.attempts, got, tt.max/2, tt.max)
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package delivery
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// entry is a notification waiting to be delivered
+type entry struct {
+	Notifier    string              `json:"notifier"`
+	Event       notifier.LoginEvent `json:"event"`
+	Attempts    int                 `json:"attempts"`
+	NextAttempt time.Time           `json:"next_attempt"`
+	LastError   string              `json:"last_error,omitempty"`
+	Created     time.Time           `json:"created"`
+}
+
+// key identifies the entry; file names are derived from it because event
+// IDs received from agents are not trusted to be safe paths
+func (e *entry) key() string {
+	sum := sha256.Sum256([]byte(e.Notifier + "\x00" + e.Event.ID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// outbox stores pending entries as one JSON file each, so they survive
+// restarts; a nil outbox stores nothing
+// Safe for concurrent use
+type outbox struct {
+	dir string
+}
+
+// openOutbox creates the outbox directory if needed
+func openOutbox(dir string) (*outbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("delivery: failed to create outbox: %w", err)
+	}
+	return &outbox{dir: dir}, nil
+}
+
+// save writes e atomically
+func (o *outbox) save(e *entry) error {
+	if o == nil {
+		return nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("delivery: failed to encode outbox entry: %w", err)
+	}
+	tmp, err := os.CreateTemp(o.dir, ".entry-*")
+	if err != nil {
+		return fmt.Errorf("delivery: failed to write outbox entry: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("delivery: failed to write outbox entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("delivery: failed to write outbox entry: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), o.path(e)); err != nil {
+		return fmt.Errorf("delivery: failed to write outbox entry: %w", err)
+	}
+	return nil
+}
+
+// remove deletes e once it is delivered or given up
+func (o *outbox) remove(e *entry) {
+	if o == nil {
+		return
+	}
+	if err := os.Remove(o.path(e)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove outbox entry: %v", err)
+	}
+}
+
+// load returns the entries left by a previous run, oldest first
+// Unreadable files are skipped and reported
+func (o *outbox) load() ([]*entry, error) {
+	if o == nil {
+		return nil, nil
+	}
+	files, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, fmt.Errorf("delivery: failed to read outbox: %w", err)
+	}
+	var entries []*entry
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(o.dir, f.Name()))
+		if err != nil {
+			log.Printf("Skipping outbox entry %s: %v", f.Name(), err)
+			continue
+		}
+		var e entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			log.Printf("Skipping outbox entry %s: %v", f.Name(), err)
+			continue
+		}
+		entries = append(entries, &e)
+	}
+	slices.SortStableFunc(entries, func(a, b *entry) int { return a.Created.Compare(b.Created) })
+	return entries, nil
+}
+
+func (o *outbox) path(e *entry) string {
+	return filepath.Join(o.dir, e.key()+".json")
+}
+
+// deadLetter records notifications that could never be delivered
+// Safe for concurrent use
+type deadLetter struct {
+	path string
+	mu   sync.Mutex
+}
+
+// deadLetterRecord is one line of the dead letter file
+type deadLetterRecord struct {
+	Time     time.Time           `json:"time"`
+	Notifier string              `json:"notifier"`
+	Attempts int                 `json:"attempts"`
+	Error    string              `json:"error"`
+	Event    notifier.LoginEvent `json:"event"`
+}
+
+// add logs e and appends it to the dead letter file, if any
+func (d *deadLetter) add(e *entry) {
+	log.Printf("Giving up on notification via %s after %d attempts: %s (%s)", e.Notifier, e.Attempts, e.Event.Summary(), e.LastError)
+	if d.path == "" {
+		return
+	}
+
+	data, err := json.Marshal(deadLetterRecord{
+		Time:     time.Now(),
+		Notifier: e.Notifier,
+		Attempts: e.Attempts,
+		Error:    e.LastError,
+		Event:    e.Event,
+	})
+	if err != nil {
+		log.Printf("Failed to encode dead letter: %v", err)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := os.OpenFile(d.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("Failed to write dead letter: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to write dead letter: %v", err)
+	}
+}
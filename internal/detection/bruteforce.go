@@ -0,0 +1,141 @@
+package detection
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// BruteForceDetector correlates failed authentication events and raises
+// a single alert when too many failures come from one IP or target one
+// user within a sliding window
+type BruteForceDetector struct {
+	threshold int
+	window    time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]*failureWindow
+	lastSweep time.Time
+}
+
+// failureWindow tracks recent failures for a single IP or user
+type failureWindow struct {
+	times   []time.Time
+	alerted bool // an alert was raised for the current burst
+}
+
+// NewBruteForceDetector creates a detector from configuration
+// Zero threshold or window fall back to the defaults
+func NewBruteForceDetector(cfg config.BruteForceConfig) *BruteForceDetector {
+	threshold := cfg.Threshold
+	if threshold <= 0 {
+		threshold = config.DefaultBruteForceThreshold
+	}
+	window := cfg.Window
+	if window <= 0 {
+		window = config.DefaultBruteForceWindow
+	}
+
+	return &BruteForceDetector{
+		threshold: threshold,
+		window:    window,
+		keys:      make(map[string]*failureWindow),
+	}
+}
+
+// Observe records a failed authentication event
+// Returns a brute-force alert when the event crosses the threshold for its
+// source IP or target user, or nil otherwise. Only one alert is raised per
+// burst; the key is re-armed after a full window without failures.
+func (d *BruteForceDetector) Observe(event notifier.LoginEvent) *notifier.LoginEvent {
+	if event.Kind != notifier.KindFailedAuth {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := event.Timestamp
+	d.sweep(now)
+
+	var byIP, byUser int
+	if event.IP != "" {
+		byIP = d.record("ip:"+event.IP, now)
+	}
+	if event.Username != "" {
+		byUser = d.record("user:"+event.Username, now)
+	}
+
+	alert := event
+	alert.ID = notifier.NewEventID()
+	alert.Kind = notifier.KindBruteForce
+	alert.Severity = notifier.SeverityHigh
+	switch {
+	case byIP > 0:
+		alert.Detail = fmt.Sprintf("%d failed attempts from %s within %v", byIP, event.IP, d.window)
+	case byUser > 0:
+		alert.Detail = fmt.Sprintf("%d failed attempts against user %s within %v", byUser, event.Username, d.window)
+	default:
+		return nil
+	}
+
+	// One alert covers both keys when they trip together
+	if event.IP != "" {
+		d.keys["ip:"+event.IP].alerted = true
+	}
+	if event.Username != "" {
+		d.keys["user:"+event.Username].alerted = true
+	}
+
+	return &alert
+}
+
+// record adds a failure for key and returns the failure count if this
+// failure should raise an alert, or 0 otherwise
+func (d *BruteForceDetector) record(key string, now time.Time) int {
+	w, ok := d.keys[key]
+	if !ok {
+		w = &failureWindow{}
+		d.keys[key] = w
+	}
+
+	w.prune(now.Add(-d.window))
+	if len(w.times) == 0 {
+		// Quiet for a full window: the previous burst is over
+		w.alerted = false
+	}
+	w.times = append(w.times, now)
+
+	if w.alerted || len(w.times) < d.threshold {
+		return 0
+	}
+	return len(w.times)
+}
+
+// sweep drops keys that have seen no failures for a full window
+func (d *BruteForceDetector) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.window {
+		return
+	}
+	d.lastSweep = now
+
+	cutoff := now.Add(-d.window)
+	for key, w := range d.keys {
+		w.prune(cutoff)
+		if len(w.times) == 0 {
+			delete(d.keys, key)
+		}
+	}
+}
+
+// prune removes failures older than cutoff
+func (w *failureWindow) prune(cutoff time.Time) {
+	i := 0
+	for i < len(w.times) && w.times[i].Before(cutoff) {
+		i++
+	}
+	w.times = w.times[i:]
+}
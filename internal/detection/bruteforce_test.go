@@ -0,0 +1,104 @@
+package detection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+func failure(user, ip string, at time.Time) notifier.LoginEvent {
+	return notifier.LoginEvent{
+		Kind:      notifier.KindFailedAuth,
+		Username:  user,
+		IP:        ip,
+		Hostname:  "testhost",
+		Terminal:  "ssh",
+		Timestamp: at,
+	}
+}
+
+func TestBruteForceFromOneIP(t *testing.T) {
+	d := NewBruteForceDetector(config.BruteForceConfig{Enabled: true, Threshold: 3, Window: time.Minute})
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	users := []string{"root", "admin", "oracle", "test", "ubuntu"}
+	var alerts []*notifier.LoginEvent
+	for i, u := range users {
+		if alert := d.Observe(failure(u, "203.0.113.7", start.Add(time.Duration(i)*time.Second))); alert != nil {
+			alerts = append(alerts, alert)
+		}
+	}
+
+	if len(alerts) != 1 {
+		t.Fatalf("Expected exactly 1 alert, got %d", len(alerts))
+	}
+	if alerts[0].Kind != notifier.KindBruteForce {
+		t.Errorf("Expected kind %s, got %s", notifier.KindBruteForce, alerts[0].Kind)
+	}
+	if alerts[0].IP != "203.0.113.7" {
+		t.Errorf("Expected IP '203.0.113.7', got '%s'", alerts[0].IP)
+	}
+}
+
+func TestBruteForceAgainstOneUser(t *testing.T) {
+	d := NewBruteForceDetector(config.BruteForceConfig{Enabled: true, Threshold: 3, Window: time.Minute})
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	ips := []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"}
+	var alert *notifier.LoginEvent
+	for i, ip := range ips {
+		alert = d.Observe(failure("root", ip, start.Add(time.Duration(i)*time.Second)))
+	}
+
+	if alert == nil {
+		t.Fatal("Expected an alert for distributed attack against root")
+	}
+	if alert.Username != "root" {
+		t.Errorf("Expected username 'root', got '%s'", alert.Username)
+	}
+}
+
+func TestBruteForceWindow(t *testing.T) {
+	d := NewBruteForceDetector(config.BruteForceConfig{Enabled: true, Threshold: 3, Window: time.Minute})
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	// Failures spread wider than the window never trip the threshold
+	for i := 0; i < 5; i++ {
+		if alert := d.Observe(failure("root", "192.0.2.1", start.Add(time.Duration(i)*40*time.Second))); alert != nil {
+			t.Fatalf("Unexpected alert at failure %d", i)
+		}
+	}
+}
+
+func TestBruteForceRearmsAfterQuietWindow(t *testing.T) {
+	d := NewBruteForceDetector(config.BruteForceConfig{Enabled: true, Threshold: 2, Window: time.Minute})
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	count := 0
+	burst := func(at time.Time) {
+		for i := 0; i < 4; i++ {
+			if d.Observe(failure("root", "192.0.2.1", at.Add(time.Duration(i)*time.Second))) != nil {
+				count++
+			}
+		}
+	}
+
+	burst(start)
+	burst(start.Add(10 * time.Minute))
+
+	if count != 2 {
+		t.Errorf("Expected one alert per burst (2), got %d", count)
+	}
+}
+
+func TestBruteForceIgnoresOtherKinds(t *testing.T) {
+	d := NewBruteForceDetector(config.BruteForceConfig{Enabled: true, Threshold: 1})
+	event := failure("root", "192.0.2.1", time.Now())
+	event.Kind = notifier.KindLogin
+
+	if d.Observe(event) != nil {
+		t.Error("Successful logins must not count as failures")
+	}
+}
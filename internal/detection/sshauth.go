@@ -0,0 +1,61 @@
+package detection
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// AuthAnnotator adds what is known about how a login authenticated:
+// the owner of a known SSH key and a severity per authentication method
+type AuthAnnotator struct {
+	knownKeys      map[string]string
+	methodSeverity map[string]notifier.Severity
+}
+
+// NewAuthAnnotator creates an annotator from configuration
+// Returns an error if a method severity is not a known severity
+func NewAuthAnnotator(cfg *config.Config) (*AuthAnnotator, error) {
+	a := &AuthAnnotator{
+		knownKeys:      cfg.KnownKeys,
+		methodSeverity: make(map[string]notifier.Severity),
+	}
+	for method, s := range cfg.Detection.MethodSeverity {
+		severity := notifier.Severity(s)
+		if !severity.Valid() {
+			return nil, fmt.Errorf("detection.method_severity: unknown severity %q for %s", s, method)
+		}
+		a.methodSeverity[method] = severity
+	}
+	return a, nil
+}
+
+// Annotate sets the key owner and method severity on a login event
+// Methods like "keyboard-interactive/pam" match "keyboard-interactive"
+// A severity set by a custom rule wins over the method severity, unless it
+// is the default severity of logins
+func (a *AuthAnnotator) Annotate(event *notifier.LoginEvent) {
+	if fingerprint := event.Fields[notifier.FieldFingerprint]; fingerprint != "" {
+		if owner, ok := a.knownKeys[fingerprint]; ok {
+			event.SetField(notifier.FieldKeyOwner, owner)
+		}
+	}
+
+	method := event.Fields[notifier.FieldMethod]
+	if method == "" || event.EventKind() != notifier.KindLogin {
+		return
+	}
+	if event.EventSeverity() != notifier.KindLogin.DefaultSeverity() {
+		return
+	}
+	severity, ok := a.methodSeverity[method]
+	if !ok {
+		base, _, _ := strings.Cut(method, "/")
+		severity, ok = a.methodSeverity[base]
+	}
+	if ok {
+		event.Severity = severity
+	}
+}
@@ -0,0 +1,57 @@
+package detection
+
+import (
+	"testing"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+func TestAuthAnnotator(t *testing.T) {
+	cfg := &config.Config{
+		KnownKeys: map[string]string{"SHA256:Zm9vYmFy": "alice laptop"},
+		Detection: config.DetectionConfig{
+			MethodSeverity: map[string]string{
+				"password":             "high",
+				"keyboard-interactive": "medium",
+			},
+		},
+	}
+	a, err := NewAuthAnnotator(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create annotator: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		fields   map[string]string
+		ruleSev  notifier.Severity
+		owner    string
+		severity notifier.Severity
+	}{
+		{"password", map[string]string{notifier.FieldMethod: "password"}, "", "", notifier.SeverityHigh},
+		{"default severity", map[string]string{notifier.FieldMethod: "password"}, notifier.SeverityInfo, "", notifier.SeverityHigh},
+		{"rule severity wins", map[string]string{notifier.FieldMethod: "password"}, notifier.SeverityCritical, "", notifier.SeverityCritical},
+		{"pam suffix", map[string]string{notifier.FieldMethod: "keyboard-interactive/pam"}, "", "", notifier.SeverityMedium},
+		{"known key", map[string]string{notifier.FieldMethod: "publickey", notifier.FieldFingerprint: "SHA256:Zm9vYmFy"}, "", "alice laptop", ""},
+		{"unknown key", map[string]string{notifier.FieldMethod: "publickey", notifier.FieldFingerprint: "SHA256:b3RoZXI"}, "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := notifier.LoginEvent{Severity: tt.ruleSev, Fields: tt.fields}
+			a.Annotate(&event)
+			if got := event.Fields[notifier.FieldKeyOwner]; got != tt.owner {
+				t.Errorf("Expected key owner '%s', got '%s'", tt.owner, got)
+			}
+			if event.Severity != tt.severity {
+				t.Errorf("Expected severity '%s', got '%s'", tt.severity, event.Severity)
+			}
+		})
+	}
+
+	cfg.Detection.MethodSeverity = map[string]string{"password": "loud"}
+	if _, err := NewAuthAnnotator(cfg); err == nil {
+		t.Error("Expected error for unknown severity")
+	}
+}
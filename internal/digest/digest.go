@@ -0,0 +1,251 @@
+// Package digest batches events into periodic summary messages
+package digest
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// maxGroups bounds the lines of a digest, to fit chat messages
+const maxGroups = 20
+
+// maxTrackedGroups bounds the memory of a batch; events of further users
+// and addresses are only counted
+const maxTrackedGroups = 1000
+
+// Batcher collects events for one notifier and summarizes them in a
+// digest event, grouped by user and source IP
+// Events are counted as they are added, not kept
+type Batcher struct {
+	interval  time.Duration
+	maxEvents int
+	bypass    notifier.Severity
+
+	mu      sync.Mutex
+	pending *summary  // nil without pending events
+	started time.Time // when the first pending event was added
+}
+
+// New creates a batcher from configuration
+// Returns nil if batching is disabled, or an error for an unknown severity
+func New(cfg config.DigestConfig) (*Batcher, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	b := &Batcher{
+		interval:  cfg.Interval,
+		maxEvents: cfg.MaxEvents,
+		bypass:    notifier.Severity(cfg.BypassSeverity),
+	}
+	if b.interval <= 0 {
+		b.interval = config.DefaultDigestInterval
+	}
+	if b.bypass == "" {
+		b.bypass = config.DefaultDigestBypassSeverity
+	}
+	if !b.bypass.Valid() {
+		return nil, fmt.Errorf("digest: unknown bypass_severity %q", cfg.BypassSeverity)
+	}
+	return b, nil
+}
+
+// Add batches event, unless it is severe enough to be sent right away
+// Returns false if the event was not batched
+func (b *Batcher) Add(event notifier.LoginEvent, now time.Time) bool {
+	if event.EventSeverity().AtLeast(b.bypass) {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pending == nil {
+		b.pending = newSummary()
+		b.started = now
+	}
+	b.pending.add(event)
+	return true
+}
+
+// Due returns the digest once the interval is over or enough events are
+// collected, or nil
+func (b *Batcher) Due(now time.Time) *notifier.LoginEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pending == nil {
+		return nil
+	}
+	if now.Sub(b.started) < b.interval && (b.maxEvents <= 0 || b.pending.count < b.maxEvents) {
+		return nil
+	}
+	return b.take()
+}
+
+// Drain returns the digest of the pending events, or nil if there are none
+func (b *Batcher) Drain() *notifier.LoginEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pending == nil {
+		return nil
+	}
+	return b.take()
+}
+
+func (b *Batcher) take() *notifier.LoginEvent {
+	digest := b.pending.event()
+	b.pending = nil
+	return &digest
+}
+
+// group counts the events of one user from one address
+type group struct {
+	user, ip    string
+	kinds       []notifier.EventKind
+	counts      map[notifier.EventKind]int
+	total       int
+	first, last time.Time
+}
+
+// summary accumulates the counts of a batch
+type summary struct {
+	groups      []*group
+	byKey       map[string]*group
+	untracked   int // events past maxTrackedGroups
+	hosts       []string
+	severity    notifier.Severity
+	count       int
+	first, last time.Time
+}
+
+func newSummary() *summary {
+	return &summary{byKey: make(map[string]*group), severity: notifier.SeverityInfo}
+}
+
+// add counts e in its group
+func (s *summary) add(e notifier.LoginEvent) {
+	if s.count == 0 {
+		s.first, s.last = e.Timestamp, e.Timestamp
+	}
+	s.count++
+	s.first, s.last = earlier(s.first, e.Timestamp), later(s.last, e.Timestamp)
+	if !slices.Contains(s.hosts, e.Hostname) {
+		s.hosts = append(s.hosts, e.Hostname)
+	}
+	if sev := e.EventSeverity(); sev.Level() > s.severity.Level() {
+		s.severity = sev
+	}
+
+	key := e.Username + "\x00" + e.IP
+	g, ok := s.byKey[key]
+	if !ok {
+		if len(s.groups) == maxTrackedGroups {
+			s.untracked++
+			return
+		}
+		g = &group{user: e.Username, ip: e.IP, counts: make(map[notifier.EventKind]int), first: e.Timestamp, last: e.Timestamp}
+		s.byKey[key] = g
+		s.groups = append(s.groups, g)
+	}
+	kind := e.EventKind()
+	if g.counts[kind] == 0 {
+		g.kinds = append(g.kinds, kind)
+	}
+	g.counts[kind]++
+	g.total++
+	g.first, g.last = earlier(g.first, e.Timestamp), later(g.last, e.Timestamp)
+}
+
+// event returns the digest event of the summary
+func (s *summary) event() notifier.LoginEvent {
+	// Busiest groups first
+	groups := slices.Clone(s.groups)
+	slices.SortStableFunc(groups, func(a, b *group) int { return b.total - a.total })
+	layout := timeLayout(s.first, s.last)
+	var lines []string
+	rest := s.untracked
+	for i, g := range groups {
+		if i >= maxGroups {
+			rest += g.total
+			continue
+		}
+		lines = append(lines, g.line(layout))
+	}
+	if rest > 0 {
+		lines = append(lines, fmt.Sprintf("… and %d more events", rest))
+	}
+
+	hosts := slices.Sorted(slices.Values(s.hosts))
+	digest := notifier.LoginEvent{
+		ID:        notifier.NewEventID(),
+		Kind:      notifier.KindDigest,
+		Severity:  s.severity,
+		Hostname:  strings.Join(hosts, ", "),
+		Timestamp: s.first,
+		Detail:    strings.Join(lines, "\n"),
+	}
+	digest.SetField(notifier.FieldEvents, strconv.Itoa(s.count))
+	digest.SetField(notifier.FieldPeriod, period(s.first, s.last, layout))
+	return digest
+}
+
+// summarize returns a digest event for events, which must not be empty
+// Its detail has a line per user and source IP, with the count of each
+// kind and the times of the first and last events
+func summarize(events []notifier.LoginEvent) notifier.LoginEvent {
+	s := newSummary()
+	for _, e := range events {
+		s.add(e)
+	}
+	return s.event()
+}
+
+// line describes the group, e.g.
+// "alice from 192.0.2.1: 3 login, 1 failed_auth (10:00:03 – 10:12:45)"
+func (g *group) line(layout string) string {
+	who := g.user
+	if who == "" {
+		who = "(no user)"
+	}
+	if g.ip != "" {
+		who += " from " + g.ip
+	}
+	counts := make([]string, len(g.kinds))
+	for i, kind := range g.kinds {
+		counts[i] = fmt.Sprintf("%d %s", g.counts[kind], kind)
+	}
+	return fmt.Sprintf("%s: %s (%s)", who, strings.Join(counts, ", "), period(g.first, g.last, layout))
+}
+
+// timeLayout shows dates only if the events span several days
+func timeLayout(first, last time.Time) string {
+	if first.Format(time.DateOnly) != last.Format(time.DateOnly) {
+		return time.DateTime
+	}
+	return time.TimeOnly
+}
+
+func period(first, last time.Time, layout string) string {
+	if first.Equal(last) {
+		return first.Format(layout)
+	}
+	return first.Format(layout) + " – " + last.Format(layout)
+}
+
+func earlier(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
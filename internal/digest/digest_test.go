@@ -0,0 +1,135 @@
+package digest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+var start = time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+func login(user, ip string, at time.Duration) notifier.LoginEvent {
+	return notifier.LoginEvent{Kind: notifier.KindLogin, Username: user, Hostname: "bastion", IP: ip, Timestamp: start.Add(at)}
+}
+
+func TestBatcher(t *testing.T) {
+	b, err := New(config.DigestConfig{Interval: 15 * time.Minute, MaxEvents: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !b.Add(login("alice", "192.0.2.1", 0), start) {
+		t.Fatal("Expected a login to be batched")
+	}
+	brute := notifier.LoginEvent{Kind: notifier.KindBruteForce, Hostname: "bastion", IP: "198.51.100.7"}
+	if b.Add(brute, start) {
+		t.Error("Expected a high severity event to bypass the digest")
+	}
+	if d := b.Due(start.Add(time.Minute)); d != nil {
+		t.Errorf("Expected no digest before the interval, got %q", d.Summary())
+	}
+	d := b.Due(start.Add(15 * time.Minute))
+	if d == nil {
+		t.Fatal("Expected a digest after the interval")
+	}
+	if d.Kind != notifier.KindDigest || d.Fields[notifier.FieldEvents] != "1" {
+		t.Errorf("Unexpected digest %+v", d)
+	}
+	if d := b.Due(start.Add(30 * time.Minute)); d != nil {
+		t.Error("Expected no digest without events")
+	}
+
+	// A full batch is due right away
+	for i := range 3 {
+		b.Add(login("bob", "", time.Duration(i)*time.Second), start.Add(time.Hour))
+	}
+	if d := b.Due(start.Add(time.Hour)); d == nil || d.Fields[notifier.FieldEvents] != "3" {
+		t.Errorf("Expected a digest of 3 events, got %v", d)
+	}
+	if d := b.Drain(); d != nil {
+		t.Errorf("Expected nothing to drain, got %q", d.Summary())
+	}
+}
+
+func TestBatcherErrors(t *testing.T) {
+	if b, err := New(config.DigestConfig{}); b != nil || err != nil {
+		t.Errorf("Expected no batcher, got %v, %v", b, err)
+	}
+	if _, err := New(config.DigestConfig{MaxEvents: 10, BypassSeverity: "urgent"}); err == nil {
+		t.Error("Expected an error for an unknown severity")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	failed := login("alice", "192.0.2.1", 5*time.Minute)
+	failed.Kind = notifier.KindFailedAuth
+	other := login("bob", "", 7*time.Minute)
+	other.Hostname = "web1"
+
+	d := summarize([]notifier.LoginEvent{
+		login("alice", "192.0.2.1", 0),
+		failed,
+		other,
+		login("alice", "192.0.2.1", 12*time.Minute+45*time.Second),
+	})
+
+	if got, want := d.Summary(), "4 events on bastion, web1"; got != want {
+		t.Errorf("Expected summary %q, got %q", want, got)
+	}
+	if d.EventSeverity() != notifier.SeverityLow {
+		t.Errorf("Expected the highest severity of the events, got %s", d.EventSeverity())
+	}
+	want := "alice from 192.0.2.1: 2 login, 1 failed_auth (10:00:00 – 10:12:45)\n" +
+		"bob: 1 login (10:07:00)"
+	if d.Detail != want {
+		t.Errorf("Expected detail:\n%s\ngot:\n%s", want, d.Detail)
+	}
+	body := d.Format()
+	for _, line := range []string{"📋 Digest", "Events: 4", "Period: 10:00:00 – 10:12:45", "\n\nalice from"} {
+		if !strings.Contains(body, line) {
+			t.Errorf("Expected %q in message, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestSummarizeManyGroups(t *testing.T) {
+	var events []notifier.LoginEvent
+	for i := range maxGroups + 5 {
+		events = append(events, login(string(rune('a'+i)), "", 24*time.Hour*time.Duration(i%2)))
+	}
+	d := summarize(events)
+	lines := strings.Split(d.Detail, "\n")
+	if len(lines) != maxGroups+1 || lines[maxGroups] != "… and 5 more events" {
+		t.Errorf("Expected %d groups and a remainder, got %d lines ending with %q", maxGroups, len(lines), lines[len(lines)-1])
+	}
+	// Events over several days show dates
+	if !strings.Contains(d.Fields[notifier.FieldPeriod], "2024-01-16") {
+		t.Errorf("Expected dates in the period, got %q", d.Fields[notifier.FieldPeriod])
+	}
+}
+
+func TestBatcherBoundsGroups(t *testing.T) {
+	b, err := New(config.DigestConfig{Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := maxTrackedGroups + 50
+	for i := range n {
+		b.Add(login("scanner", fmt.Sprintf("198.51.%d.%d", i/256, i%256), time.Second), start)
+	}
+	if len(b.pending.groups) != maxTrackedGroups {
+		t.Errorf("Expected %d tracked groups, got %d", maxTrackedGroups, len(b.pending.groups))
+	}
+	d := b.Drain()
+	if d == nil || d.Fields[notifier.FieldEvents] != strconv.Itoa(n) {
+		t.Fatalf("Expected a digest of %d events, got %v", n, d)
+	}
+	if want := fmt.Sprintf("… and %d more events", n-maxGroups); !strings.HasSuffix(d.Detail, want) {
+		t.Errorf("Expected the detail to end with %q, got %q", want, d.Detail)
+	}
+}
@@ -0,0 +1,682 @@
+// Package expr is a small expression language for conditions over event
+// fields, such as `kind == "login" && user == "root" && !ip.in("10.0.0.0/8")`
+//
+// Expressions are sandboxed: they can only read the variables declared in
+// a Schema and call a fixed set of methods, have no loops, and are type
+// checked when compiled, so evaluation cannot fail. Regexes use RE2 and
+// run in linear time.
+//
+// Syntax, by increasing precedence:
+//
+//	a || b                  either holds
+//	a && b                  both hold
+//	!a                      a does not hold
+//	== != < <= > >=  in     comparisons; x in ["a", "b"]
+//	x.name  x.method(args)  map fields and method calls
+//	"text" 'text' 42 true false [list] (grouping)
+//
+// Methods:
+//
+//	string  contains(s) startsWith(s) endsWith(s) matches(regex) lower() upper()
+//	ip      in(cidr, ...) isPrivate() isLoopback()
+//	map     has(key)
+package expr
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// MaxLength bounds the length of an expression
+const MaxLength = 4096
+
+// maxDepth bounds the nesting of an expression
+const maxDepth = 64
+
+type typeKind int
+
+const (
+	kindBool typeKind = iota
+	kindInt
+	kindString
+	kindIP
+	kindMap
+	kindList
+)
+
+// Type is the type of a variable
+type Type struct {
+	kind    typeKind
+	values  []string // allowed values of an enum string
+	ordered bool     // enum values compare by their position in values
+	elem    typeKind // element type of a list
+}
+
+// Variable types
+var (
+	// Bool is true or false
+	Bool = Type{kind: kindBool}
+	// Int is an integer
+	Int = Type{kind: kindInt}
+	// String is text
+	String = Type{kind: kindString}
+	// IP is a netip.Addr; the zero Addr stands for no address
+	IP = Type{kind: kindIP}
+	// Map is a map[string]string; missing keys read as ""
+	Map = Type{kind: kindMap}
+)
+
+// Enum is a string limited to values; comparing it with another string
+// is checked at compile time
+func Enum(values ...string) Type {
+	return Type{kind: kindString, values: values}
+}
+
+// OrderedEnum is an Enum whose values also compare with < <= > >=, in the
+// order given
+func OrderedEnum(values ...string) Type {
+	return Type{kind: kindString, values: values, ordered: true}
+}
+
+func (t Type) String() string {
+	switch t.kind {
+	case kindBool:
+		return "bool"
+	case kindInt:
+		return "number"
+	case kindIP:
+		return "ip"
+	case kindMap:
+		return "map"
+	case kindList:
+		return "list"
+	default:
+		return "string"
+	}
+}
+
+// Schema declares the variables an expression may use
+type Schema map[string]Type
+
+// Env holds the values of the variables: bool, int, string, netip.Addr
+// or map[string]string, according to their Type
+type Env map[string]any
+
+// Program is a compiled expression
+type Program struct {
+	src  string
+	eval func(Env) any
+}
+
+// Compile parses and type checks src, which must be a condition
+// Errors are *Error values giving the position of the problem
+func Compile(src string, schema Schema) (*Program, error) {
+	if len(src) > MaxLength {
+		return nil, &Error{Line: 1, Column: 1, Msg: fmt.Sprintf("expression is longer than %d characters", MaxLength)}
+	}
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{src: src, tokens: tokens, schema: schema}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorAt(t.pos, "unexpected %s after the condition", t.describe())
+	}
+	if n.typ.kind != kindBool {
+		return nil, p.errorAt(n.pos, "expression must be a condition (true or false), not a %s", n.typ)
+	}
+	return &Program{src: src, eval: n.eval}, nil
+}
+
+// Eval evaluates the program; variables missing from env read as their
+// zero value
+func (p *Program) Eval(env Env) bool {
+	return p.eval(env).(bool)
+}
+
+// String returns the source of the program
+func (p *Program) String() string {
+	return p.src
+}
+
+// node is a typed, compiled sub-expression
+type node struct {
+	typ  Type
+	pos  int
+	eval func(Env) any
+	lit  *token // the literal, for string and number literals
+	list []node // the elements, for list literals
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	i      int
+	schema Schema
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, p.errorAt(t.pos, "expected %q, found %s", kind.String(), t.describe())
+	}
+	return t, nil
+}
+
+func (p *parser) errorAt(pos int, format string, args ...any) *Error {
+	return errorAt(p.src, pos, format, args...)
+}
+
+// enter guards against deeply nested expressions
+func (p *parser) enter(pos int) error {
+	p.depth++
+	if p.depth > maxDepth {
+		return p.errorAt(pos, "expression is nested too deeply")
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return node{}, err
+	}
+	for p.peek().kind == tokOr {
+		op := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return node{}, err
+		}
+		if err := p.requireBool(op, left, right); err != nil {
+			return node{}, err
+		}
+		l, r := left.eval, right.eval
+		left = node{typ: Bool, pos: left.pos, eval: func(env Env) any {
+			return l(env).(bool) || r(env).(bool)
+		}}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return node{}, err
+	}
+	for p.peek().kind == tokAnd {
+		op := p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return node{}, err
+		}
+		if err := p.requireBool(op, left, right); err != nil {
+			return node{}, err
+		}
+		l, r := left.eval, right.eval
+		left = node{typ: Bool, pos: left.pos, eval: func(env Env) any {
+			return l(env).(bool) && r(env).(bool)
+		}}
+	}
+	return left, nil
+}
+
+func (p *parser) requireBool(op token, operands ...node) error {
+	for _, n := range operands {
+		if n.typ.kind != kindBool {
+			return p.errorAt(n.pos, "%q needs conditions on both sides, not a %s", op.kind.String(), n.typ)
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.peek().kind != tokNot {
+		return p.parseComparison()
+	}
+	op := p.next()
+	if err := p.enter(op.pos); err != nil {
+		return node{}, err
+	}
+	defer p.leave()
+	operand, err := p.parseNot()
+	if err != nil {
+		return node{}, err
+	}
+	if operand.typ.kind != kindBool {
+		return node{}, p.errorAt(operand.pos, "\"!\" needs a condition, not a %s", operand.typ)
+	}
+	eval := operand.eval
+	return node{typ: Bool, pos: op.pos, eval: func(env Env) any {
+		return !eval(env).(bool)
+	}}, nil
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return node{}, err
+	}
+	op := p.peek()
+	switch {
+	case op.kind == tokEq, op.kind == tokNe, op.kind == tokLt, op.kind == tokLe, op.kind == tokGt, op.kind == tokGe:
+	case op.kind == tokIdent && op.text == "in":
+	default:
+		if left.typ.kind == kindList {
+			return node{}, p.errorAt(left.pos, "a list can only follow \"in\"")
+		}
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePostfix()
+	if err != nil {
+		return node{}, err
+	}
+	if op.kind == tokIdent {
+		return p.compileIn(left, right)
+	}
+	return p.compileCompare(op, left, right)
+}
+
+// checkEnum checks that a literal compared with an enum is one of its values
+func (p *parser) checkEnum(enum, other node) error {
+	if enum.typ.values == nil || other.lit == nil || other.typ.kind != kindString {
+		return nil
+	}
+	if !slices.Contains(enum.typ.values, other.lit.text) {
+		return p.errorAt(other.pos, "unknown value %q (expected one of %s)", other.lit.text, strings.Join(enum.typ.values, ", "))
+	}
+	return nil
+}
+
+func (p *parser) compileIn(left, right node) (node, error) {
+	if right.typ.kind != kindList {
+		return node{}, p.errorAt(right.pos, "\"in\" needs a list such as [\"a\", \"b\"], not a %s", right.typ)
+	}
+	if left.typ.kind != right.typ.elem {
+		return node{}, p.errorAt(left.pos, "cannot look up a %s in a list of %s values", left.typ, Type{kind: right.typ.elem})
+	}
+	values := make([]any, len(right.list))
+	for i, el := range right.list {
+		if err := p.checkEnum(left, el); err != nil {
+			return node{}, err
+		}
+		values[i] = el.eval(nil)
+	}
+	eval := left.eval
+	return node{typ: Bool, pos: left.pos, eval: func(env Env) any {
+		return slices.Contains(values, eval(env))
+	}}, nil
+}
+
+func (p *parser) compileCompare(op token, left, right node) (node, error) {
+	if left.typ.kind == kindList || right.typ.kind == kindList {
+		return node{}, p.errorAt(op.pos, "a list can only follow \"in\"")
+	}
+
+	// An address compares with an address literal
+	if left.typ.kind == kindIP && right.lit != nil && right.typ.kind == kindString {
+		addr, err := netip.ParseAddr(right.lit.text)
+		if err != nil {
+			return node{}, p.errorAt(right.pos, "invalid IP address %q", right.lit.text)
+		}
+		addr = addr.Unmap()
+		right = node{typ: IP, pos: right.pos, eval: func(Env) any { return addr }}
+	}
+	if right.typ.kind == kindIP && left.typ.kind != kindIP {
+		return node{}, p.errorAt(op.pos, "cannot compare a %s with an ip (put the ip first)", left.typ)
+	}
+
+	if left.typ.kind != right.typ.kind {
+		return node{}, p.errorAt(op.pos, "cannot compare a %s with a %s", left.typ, right.typ)
+	}
+	if err := p.checkEnum(left, right); err != nil {
+		return node{}, err
+	}
+	if err := p.checkEnum(right, left); err != nil {
+		return node{}, err
+	}
+	l, r := left.eval, right.eval
+
+	if op.kind == tokEq || op.kind == tokNe {
+		if left.typ.kind == kindMap {
+			return node{}, p.errorAt(op.pos, "cannot compare maps")
+		}
+		equal := func(env Env) bool { return l(env) == r(env) }
+		if left.typ.kind == kindIP {
+			equal = func(env Env) bool {
+				a, b := l(env).(netip.Addr), r(env).(netip.Addr)
+				return a.IsValid() && a.Unmap() == b.Unmap()
+			}
+		}
+		if op.kind == tokNe {
+			return node{typ: Bool, pos: left.pos, eval: func(env Env) any { return !equal(env) }}, nil
+		}
+		return node{typ: Bool, pos: left.pos, eval: func(env Env) any { return equal(env) }}, nil
+	}
+
+	// Ordering: numbers, or the values of an ordered enum
+	var rank func(Env, func(Env) any) int
+	switch {
+	case left.typ.kind == kindInt:
+		rank = func(env Env, eval func(Env) any) int { return eval(env).(int) }
+	case left.typ.ordered || right.typ.ordered:
+		values := left.typ.values
+		if !left.typ.ordered {
+			values = right.typ.values
+		}
+		rank = func(env Env, eval func(Env) any) int { return slices.Index(values, eval(env).(string)) }
+	default:
+		return node{}, p.errorAt(op.pos, "%q compares numbers or ordered values, not %s values", op.kind.String(), left.typ)
+	}
+	compare := map[tokenKind]func(a, b int) bool{
+		tokLt: func(a, b int) bool { return a < b },
+		tokLe: func(a, b int) bool { return a <= b },
+		tokGt: func(a, b int) bool { return a > b },
+		tokGe: func(a, b int) bool { return a >= b },
+	}[op.kind]
+	return node{typ: Bool, pos: left.pos, eval: func(env Env) any {
+		a, b := rank(env, l), rank(env, r)
+		// Unknown enum values are not ordered
+		return a >= 0 && b >= 0 && compare(a, b)
+	}}, nil
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return node{}, err
+	}
+	for p.peek().kind == tokDot {
+		p.next()
+		name, err := p.expect(tokIdent)
+		if err != nil {
+			return node{}, err
+		}
+		if p.peek().kind != tokLParen {
+			if n, err = p.compileField(n, name); err != nil {
+				return node{}, err
+			}
+			continue
+		}
+		p.next()
+		var args []node
+		for p.peek().kind != tokRParen {
+			if len(args) > 0 {
+				if _, err := p.expect(tokComma); err != nil {
+					return node{}, err
+				}
+			}
+			arg, err := p.parseOr()
+			if err != nil {
+				return node{}, err
+			}
+			args = append(args, arg)
+		}
+		p.next()
+		if n, err = p.compileMethod(n, name, args); err != nil {
+			return node{}, err
+		}
+	}
+	return n, nil
+}
+
+func (p *parser) compileField(n node, name token) (node, error) {
+	if n.typ.kind != kindMap {
+		return node{}, p.errorAt(name.pos, "a %s has no fields; to call a method, add ()", n.typ)
+	}
+	eval, key := n.eval, name.text
+	return node{typ: String, pos: n.pos, eval: func(env Env) any {
+		m, _ := eval(env).(map[string]string)
+		return m[key]
+	}}, nil
+}
+
+// methods lists the methods of each type, for error messages
+var methods = map[typeKind][]string{
+	kindString: {"contains", "startsWith", "endsWith", "matches", "lower", "upper"},
+	kindIP:     {"in", "isPrivate", "isLoopback"},
+	kindMap:    {"has"},
+}
+
+func (p *parser) compileMethod(recv node, name token, args []node) (node, error) {
+	known := methods[recv.typ.kind]
+	if !slices.Contains(known, name.text) {
+		if len(known) == 0 {
+			return node{}, p.errorAt(name.pos, "a %s has no methods", recv.typ)
+		}
+		return node{}, p.errorAt(name.pos, "unknown method %q for a %s (expected one of %s)", name.text, recv.typ, strings.Join(known, ", "))
+	}
+	wantArgs := 1
+	switch name.text {
+	case "lower", "upper", "isPrivate", "isLoopback":
+		wantArgs = 0
+	case "in":
+		wantArgs = -1
+	}
+	if wantArgs >= 0 && len(args) != wantArgs {
+		return node{}, p.errorAt(name.pos, "%s() takes %d argument(s), got %d", name.text, wantArgs, len(args))
+	}
+	if wantArgs < 0 && len(args) == 0 {
+		return node{}, p.errorAt(name.pos, "%s() needs at least one argument", name.text)
+	}
+	for _, arg := range args {
+		if arg.typ.kind != kindString {
+			return node{}, p.errorAt(arg.pos, "%s() takes strings, not a %s", name.text, arg.typ)
+		}
+	}
+
+	recvEval := recv.eval
+	str := func(env Env) string { return recvEval(env).(string) }
+	argStr := func(env Env) string { return "" }
+	if len(args) == 1 {
+		eval := args[0].eval
+		argStr = func(env Env) string { return eval(env).(string) }
+	}
+	boolNode := func(f func(Env) bool) (node, error) {
+		return node{typ: Bool, pos: recv.pos, eval: func(env Env) any { return f(env) }}, nil
+	}
+
+	switch name.text {
+	case "contains":
+		return boolNode(func(env Env) bool { return strings.Contains(str(env), argStr(env)) })
+	case "startsWith":
+		return boolNode(func(env Env) bool { return strings.HasPrefix(str(env), argStr(env)) })
+	case "endsWith":
+		return boolNode(func(env Env) bool { return strings.HasSuffix(str(env), argStr(env)) })
+	case "matches":
+		if args[0].lit == nil {
+			return node{}, p.errorAt(args[0].pos, "matches() takes a regex literal")
+		}
+		re, err := regexp.Compile(args[0].lit.text)
+		if err != nil {
+			return node{}, p.errorAt(args[0].pos, "invalid regex: %v", err)
+		}
+		return boolNode(func(env Env) bool { return re.MatchString(str(env)) })
+	case "lower":
+		return node{typ: String, pos: recv.pos, eval: func(env Env) any { return strings.ToLower(str(env)) }}, nil
+	case "upper":
+		return node{typ: String, pos: recv.pos, eval: func(env Env) any { return strings.ToUpper(str(env)) }}, nil
+	case "has":
+		key := argStr
+		return boolNode(func(env Env) bool {
+			m, _ := recvEval(env).(map[string]string)
+			_, ok := m[key(env)]
+			return ok
+		})
+	}
+
+	addr := func(env Env) netip.Addr {
+		a, _ := recvEval(env).(netip.Addr)
+		return a.Unmap()
+	}
+	switch name.text {
+	case "in":
+		var prefixes []netip.Prefix
+		for _, arg := range args {
+			if arg.lit == nil {
+				return node{}, p.errorAt(arg.pos, "in() takes address or CIDR literals")
+			}
+			prefix, err := parsePrefix(arg.lit.text)
+			if err != nil {
+				return node{}, p.errorAt(arg.pos, "invalid address or CIDR %q", arg.lit.text)
+			}
+			prefixes = append(prefixes, prefix)
+		}
+		return boolNode(func(env Env) bool {
+			a := addr(env)
+			for _, prefix := range prefixes {
+				if prefix.Contains(a) {
+					return true
+				}
+			}
+			return false
+		})
+	case "isPrivate":
+		return boolNode(func(env Env) bool { return addr(env).IsPrivate() })
+	default: // isLoopback
+		return boolNode(func(env Env) bool { return addr(env).IsLoopback() })
+	}
+}
+
+// parsePrefix parses a CIDR or a single address
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			value := t.text == "true"
+			return node{typ: Bool, pos: t.pos, eval: func(Env) any { return value }}, nil
+		case "in":
+			return node{}, p.errorAt(t.pos, "expected a value before \"in\"")
+		}
+		typ, ok := p.schema[t.text]
+		if !ok {
+			return node{}, p.errorAt(t.pos, "unknown name %q (expected one of %s)", t.text, strings.Join(p.names(), ", "))
+		}
+		name, zero := t.text, zeroValue(typ)
+		return node{typ: typ, pos: t.pos, eval: func(env Env) any {
+			if v, ok := env[name]; ok {
+				return v
+			}
+			return zero
+		}}, nil
+	case tokString:
+		value := t.text
+		return node{typ: String, pos: t.pos, lit: &t, eval: func(Env) any { return value }}, nil
+	case tokInt:
+		value := t.num
+		return node{typ: Int, pos: t.pos, lit: &t, eval: func(Env) any { return value }}, nil
+	case tokLParen:
+		if err := p.enter(t.pos); err != nil {
+			return node{}, err
+		}
+		defer p.leave()
+		n, err := p.parseOr()
+		if err != nil {
+			return node{}, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return node{}, err
+		}
+		n.lit = nil
+		return n, nil
+	case tokLBrack:
+		return p.parseList(t)
+	default:
+		return node{}, p.errorAt(t.pos, "expected a value, found %s", t.describe())
+	}
+}
+
+// parseList parses a list of string or number literals
+func (p *parser) parseList(open token) (node, error) {
+	list := node{typ: Type{kind: kindList, elem: kindString}, pos: open.pos}
+	for p.peek().kind != tokRBrack {
+		if len(list.list) > 0 {
+			if _, err := p.expect(tokComma); err != nil {
+				return node{}, err
+			}
+		}
+		t := p.next()
+		var el node
+		switch t.kind {
+		case tokString:
+			value := t.text
+			el = node{typ: String, pos: t.pos, lit: &t, eval: func(Env) any { return value }}
+		case tokInt:
+			value := t.num
+			el = node{typ: Int, pos: t.pos, lit: &t, eval: func(Env) any { return value }}
+		default:
+			return node{}, p.errorAt(t.pos, "lists hold strings or numbers, found %s", t.describe())
+		}
+		if len(list.list) == 0 {
+			list.typ.elem = el.typ.kind
+		} else if el.typ.kind != list.typ.elem {
+			return node{}, p.errorAt(t.pos, "lists cannot mix strings and numbers")
+		}
+		list.list = append(list.list, el)
+	}
+	p.next()
+	return list, nil
+}
+
+func (p *parser) names() []string {
+	names := make([]string, 0, len(p.schema))
+	for name := range p.schema {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func zeroValue(t Type) any {
+	switch t.kind {
+	case kindBool:
+		return false
+	case kindInt:
+		return 0
+	case kindIP:
+		return netip.Addr{}
+	case kindMap:
+		return map[string]string(nil)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,135 @@
+package expr
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+var testSchema = Schema{
+	"kind":     Enum("login", "logout", "failed_auth"),
+	"severity": OrderedEnum("info", "low", "high"),
+	"user":     String,
+	"ip":       IP,
+	"fields":   Map,
+	"hour":     Int,
+	"private":  Bool,
+}
+
+func TestEval(t *testing.T) {
+	env := Env{
+		"kind":     "login",
+		"severity": "low",
+		"user":     "root",
+		"ip":       netip.MustParseAddr("192.0.2.10"),
+		"fields":   map[string]string{"method": "password"},
+		"hour":     23,
+	}
+
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{`kind == "login" && user == "root" && !ip.in("10.0.0.0/8")`, true},
+		{`kind == "login" && user == "root" && !ip.in("192.0.2.0/24")`, false},
+		{`kind != "login" || user == 'root'`, true},
+		{`!(user == "admin") && (false || true)`, true},
+		{`!!true && !false`, true},
+		{`user in ["admin", "root"]`, true},
+		{`kind in ["logout", "failed_auth"]`, false},
+		{`hour >= 22 || hour < 6`, true},
+		{`hour in [9, 10]`, false},
+		{`severity >= "low" && severity < "high"`, true},
+		{`severity > "low"`, false},
+		{`user.startsWith("ro") && user.endsWith("ot") && user.contains("oo")`, true},
+		{`user.upper() == "ROOT" && "ROOT".lower() == user`, true},
+		{`user.matches("^(admin|root)$") && !user.matches('\d')`, true},
+		{`ip == "192.0.2.10" && ip != "192.0.2.11"`, true},
+		{`ip.in("10.0.0.0/8", "192.0.2.10")`, true},
+		{`ip.isPrivate() || ip.isLoopback()`, false},
+		{`fields.method == "password" && fields.has("method")`, true},
+		{`fields.missing == "" && !fields.has("missing")`, true},
+		{"user == \"root\"\n  && hour > 20", true},
+		{`private`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			p, err := Compile(tt.src, testSchema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.Eval(env); got != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalMissingVariables(t *testing.T) {
+	// Missing variables read as their zero value; no address is in no range
+	tests := []string{
+		`ip.in("0.0.0.0/0")`,
+		`ip == "0.0.0.0"`,
+		`fields.has("method")`,
+		`severity >= "info"`,
+		`user != ""`,
+	}
+	for _, src := range tests {
+		p, err := Compile(src, testSchema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Eval(Env{}) {
+			t.Errorf("Expected %s to be false", src)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`usr == "root"`, `1:1: unknown name "usr"`},
+		{`kind == "logon"`, `1:9: unknown value "logon" (expected one of login, logout, failed_auth)`},
+		{`kind in ["login", "logon"]`, `1:19: unknown value "logon"`},
+		{`user = "root"`, `1:6: unexpected '=' (did you mean "=="?)`},
+		{`user == "root`, `1:9: unterminated string`},
+		{`user == "root" &&`, `1:18: expected a value, found end of expression`},
+		{`user == "root")`, `1:15: unexpected ")" after the condition`},
+		{`user`, `1:1: expression must be a condition (true or false), not a string`},
+		{`hour == "9"`, `1:6: cannot compare a number with a string`},
+		{`user < "m"`, `1:6: "<" compares numbers or ordered values, not string values`},
+		{`ip.in("10.0.0.0/33")`, `1:7: invalid address or CIDR "10.0.0.0/33"`},
+		{`ip == "host"`, `1:7: invalid IP address "host"`},
+		{`user.matches("(")`, `1:14: invalid regex`},
+		{`user.matches(user)`, `1:14: matches() takes a regex literal`},
+		{`user.size()`, `1:6: unknown method "size" for a string`},
+		{`user.lower`, `1:6: a string has no fields`},
+		{`hour.contains("1")`, `1:6: a number has no methods`},
+		{`user.contains()`, `1:6: contains() takes 1 argument(s), got 0`},
+		{`user == ["root"]`, `1:6: a list can only follow "in"`},
+		{`user in "root"`, `1:9: "in" needs a list`},
+		{`user in [1, "2"]`, `1:13: lists cannot mix strings and numbers`},
+		{"user == \"root\" &&\n  kind == \"x\"", `2:11: unknown value "x"`},
+		{`hour && true`, `1:1: "&&" needs conditions on both sides, not a number`},
+		{strings.Repeat("(", 100) + "true" + strings.Repeat(")", 100), `nested too deeply`},
+		{strings.Repeat(" ", MaxLength+1), `longer than`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := Compile(tt.src, testSchema)
+			if err == nil {
+				t.Fatalf("Expected an error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error containing %q, got %q", tt.want, err)
+			}
+			var exprErr *Error
+			if !errors.As(err, &exprErr) {
+				t.Errorf("Expected an *Error, got %T", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokInt
+	tokLParen
+	tokRParen
+	tokLBrack
+	tokRBrack
+	tokComma
+	tokDot
+	tokNot
+	tokAnd
+	tokOr
+	tokEq
+	tokNe
+	tokLt
+	tokLe
+	tokGt
+	tokGe
+)
+
+var tokenNames = map[tokenKind]string{
+	tokEOF:    "end of expression",
+	tokIdent:  "name",
+	tokString: "string",
+	tokInt:    "number",
+	tokLParen: "(",
+	tokRParen: ")",
+	tokLBrack: "[",
+	tokRBrack: "]",
+	tokComma:  ",",
+	tokDot:    ".",
+	tokNot:    "!",
+	tokAnd:    "&&",
+	tokOr:     "||",
+	tokEq:     "==",
+	tokNe:     "!=",
+	tokLt:     "<",
+	tokLe:     "<=",
+	tokGt:     ">",
+	tokGe:     ">=",
+}
+
+func (k tokenKind) String() string {
+	return tokenNames[k]
+}
+
+// token is a lexical token; pos is its byte offset in the source
+type token struct {
+	kind tokenKind
+	pos  int
+	text string // identifier name or decoded string
+	num  int
+}
+
+// describe returns the token as shown in error messages
+func (t token) describe() string {
+	switch t.kind {
+	case tokIdent:
+		return strconv.Quote(t.text)
+	case tokString:
+		return "string " + strconv.Quote(t.text)
+	case tokInt:
+		return "number " + strconv.Itoa(t.num)
+	case tokEOF:
+		return t.kind.String()
+	default:
+		return strconv.Quote(t.kind.String())
+	}
+}
+
+// operators maps operator spellings to tokens, longest first
+var operators = []struct {
+	text string
+	kind tokenKind
+}{
+	{"&&", tokAnd}, {"||", tokOr}, {"==", tokEq}, {"!=", tokNe}, {"<=", tokLe}, {">=", tokGe},
+	{"(", tokLParen}, {")", tokRParen}, {"[", tokLBrack}, {"]", tokRBrack}, {",", tokComma},
+	{".", tokDot}, {"!", tokNot}, {"<", tokLt}, {">", tokGt},
+}
+
+// lex splits src into tokens, ending with tokEOF
+func lex(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '_' || unicode.IsLetter(r):
+			start := i
+			for i < len(src) {
+				r, size := utf8.DecodeRuneInString(src[i:])
+				if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+					break
+				}
+				i += size
+			}
+			tokens = append(tokens, token{kind: tokIdent, pos: start, text: src[start:i]})
+		case r >= '0' && r <= '9':
+			start := i
+			for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+				i++
+			}
+			n, err := strconv.Atoi(src[start:i])
+			if err != nil {
+				return nil, errorAt(src, start, "number %s is out of range", src[start:i])
+			}
+			tokens = append(tokens, token{kind: tokInt, pos: start, num: n})
+		case r == '"' || r == '\'':
+			s, end, err := lexString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokString, pos: i, text: s})
+			i = end
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op.text) {
+					tokens = append(tokens, token{kind: op.kind, pos: i})
+					i += len(op.text)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				if r == '=' || r == '&' || r == '|' {
+					return nil, errorAt(src, i, "unexpected %q (did you mean %q?)", r, strings.Repeat(string(r), 2))
+				}
+				return nil, errorAt(src, i, "unexpected character %q", r)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src)}), nil
+}
+
+// lexString decodes the quoted string starting at src[start]
+// Backslash escapes the quote, a backslash, n and t; other characters
+// are kept as is, so that regexes need no double escaping
+func lexString(src string, start int) (string, int, error) {
+	quote := src[start]
+	var b strings.Builder
+	for i := start + 1; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && i+1 < len(src):
+			switch next := src[i+1]; next {
+			case quote, '\\':
+				b.WriteByte(next)
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(c)
+				b.WriteByte(next)
+			}
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, errorAt(src, start, "unterminated string")
+}
+
+// Error is a compile error at a position in the expression
+type Error struct {
+	Line, Column int // 1-based
+	Msg          string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Msg)
+}
+
+// errorAt returns an error at byte offset pos of src
+func errorAt(src string, pos int, format string, args ...any) *Error {
+	line, col := 1, 1
+	for _, r := range src[:min(pos, len(src))] {
+		if r == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return &Error{Line: line, Column: col, Msg: fmt.Sprintf(format, args...)}
+}
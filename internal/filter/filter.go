@@ -0,0 +1,52 @@
+package filter
+
+import (
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// Filter applies the global filters to whole events
+//
+// An event is dropped if:
+//  1. it is ignored by ignore_users, ignore_terminals or
+//     ignore_combinations, or it is a login ignored by ignore_methods or
+//     ignore_known_keys, or
+//  2. it matches an ignore entry, or
+//  3. only entries are set and it matches none of them
+//
+// Ignoring therefore wins over only
+type Filter struct {
+	cfg    config.FilterConfig
+	ignore Any
+	only   Any
+}
+
+// New compiles the filter configuration
+func New(cfg config.FilterConfig) (*Filter, error) {
+	ignore, err := NewAny("filters.ignore", cfg.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	only, err := NewAny("filters.only", cfg.Only)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{cfg: cfg, ignore: ignore, only: only}, nil
+}
+
+// Ignore reports whether event should be dropped
+func (f *Filter) Ignore(event notifier.LoginEvent) bool {
+	if f.cfg.ShouldIgnore(event.Username, event.Terminal) {
+		return true
+	}
+	// Failed attempts and brute-force alerts carry a method too, but
+	// ignore_methods and ignore_known_keys only cover logins
+	if event.EventKind() == notifier.KindLogin &&
+		f.cfg.ShouldIgnoreAuth(event.Fields[notifier.FieldMethod], event.Fields[notifier.FieldKeyOwner]) {
+		return true
+	}
+	if f.ignore.Match(event) {
+		return true
+	}
+	return len(f.only) > 0 && !f.only.Match(event)
+}
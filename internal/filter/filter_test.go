@@ -0,0 +1,82 @@
+package filter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+func TestFilter(t *testing.T) {
+	f, err := New(config.FilterConfig{
+		IgnoreTerminals: []string{"cron"},
+		IgnoreMethods:   []string{"gssapi-with-mic", "password"},
+		Ignore: []config.MatchConfig{
+			// The nightly deploy
+			{Users: []string{"deploy"}, Hours: "02:00-03:00"},
+			{IPs: []string{"10.9.0.0/16"}},
+		},
+		Only: []config.MatchConfig{
+			{IPs: []string{"10.0.0.0/8"}},
+			{Kinds: []string{"integrity"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	at := func(hour int) time.Time {
+		return time.Date(2024, 1, 15, hour, 30, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name  string
+		event notifier.LoginEvent
+		want  bool
+	}{
+		{"allowed", notifier.LoginEvent{Username: "alice", IP: "10.1.2.3", Timestamp: at(10)}, false},
+		{"not in only", notifier.LoginEvent{Username: "alice", IP: "192.0.2.1", Timestamp: at(10)}, true},
+		{"no address", notifier.LoginEvent{Username: "alice", Terminal: "tty1", Timestamp: at(10)}, true},
+		{"only by kind", notifier.LoginEvent{Kind: notifier.KindIntegrity, Timestamp: at(10)}, false},
+		{"deploy window", notifier.LoginEvent{Username: "deploy", IP: "10.1.2.3", Timestamp: at(2)}, true},
+		{"deploy outside window", notifier.LoginEvent{Username: "deploy", IP: "10.1.2.3", Timestamp: at(4)}, false},
+		{"ignore wins over only", notifier.LoginEvent{Username: "alice", IP: "10.9.1.1", Timestamp: at(10)}, true},
+		{"ignored terminal", notifier.LoginEvent{Username: "alice", IP: "10.1.2.3", Terminal: "cron"}, true},
+		{"ignored method", notifier.LoginEvent{
+			Username: "alice",
+			IP:       "10.1.2.3",
+			Fields:   map[string]string{notifier.FieldMethod: "gssapi-with-mic"},
+		}, true},
+		{"failed attempt with ignored method", notifier.LoginEvent{
+			Kind:     notifier.KindFailedAuth,
+			Username: "alice",
+			IP:       "10.1.2.3",
+			Fields:   map[string]string{notifier.FieldMethod: "password"},
+		}, false},
+		{"brute force with ignored method", notifier.LoginEvent{
+			Kind:   notifier.KindBruteForce,
+			IP:     "10.1.2.3",
+			Fields: map[string]string{notifier.FieldMethod: "password"},
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := f.Ignore(tt.event); got != tt.want {
+				t.Errorf("Ignore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterWithoutRules(t *testing.T) {
+	f, err := New(config.FilterConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Ignore(notifier.LoginEvent{Username: "alice"}) {
+		t.Error("Expected no event to be ignored")
+	}
+	if _, err := New(config.FilterConfig{Only: []config.MatchConfig{{Kinds: []string{"logon"}}}}); err == nil {
+		t.Error("Expected an error for an unknown kind")
+	}
+}
@@ -0,0 +1,253 @@
+// Package filter decides which events are notified, and where
+package filter
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/expr"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// Matcher holds if an event satisfies every condition of one match entry
+type Matcher struct {
+	users       patterns
+	terminals   patterns
+	hosts       patterns
+	prefixes    []netip.Prefix
+	kinds       map[notifier.EventKind]bool
+	minSeverity notifier.Severity
+	hours       *hours
+	days        map[time.Weekday]bool
+	countries   map[string]bool
+	asns        map[int]bool
+	when        *expr.Program
+}
+
+// patterns holds if one of its patterns matches; nil holds for anything
+type patterns []*regexp.Regexp
+
+func compilePatterns(field string, list []string) (patterns, error) {
+	var ps patterns
+	for _, p := range list {
+		re, err := config.CompilePattern(p)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", field, err)
+		}
+		ps = append(ps, re)
+	}
+	return ps, nil
+}
+
+func (ps patterns) match(s string) bool {
+	if ps == nil {
+		return true
+	}
+	for _, re := range ps {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// hours is a time-of-day window, wrapping past midnight if start > end
+type hours struct {
+	start, end time.Duration
+}
+
+func (h *hours) contains(t time.Time) bool {
+	t = t.Local()
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if h.start < h.end {
+		return offset >= h.start && offset < h.end
+	}
+	return offset >= h.start || offset < h.end
+}
+
+// NewMatcher compiles a match entry
+// Returns an error for invalid patterns, unknown kinds or severities, or
+// an invalid when expression
+func NewMatcher(cfg config.MatchConfig) (*Matcher, error) {
+	m := &Matcher{}
+	var err error
+	if m.users, err = compilePatterns("users", cfg.Users); err != nil {
+		return nil, err
+	}
+	if m.terminals, err = compilePatterns("terminals", cfg.Terminals); err != nil {
+		return nil, err
+	}
+	if m.hosts, err = compilePatterns("hosts", cfg.Hosts); err != nil {
+		return nil, err
+	}
+	for _, ip := range cfg.IPs {
+		p, err := config.ParsePrefix(ip)
+		if err != nil {
+			return nil, fmt.Errorf("ips: invalid address or CIDR %q", ip)
+		}
+		m.prefixes = append(m.prefixes, p)
+	}
+	if len(cfg.Kinds) > 0 {
+		m.kinds = make(map[notifier.EventKind]bool)
+		for _, k := range cfg.Kinds {
+			kind := notifier.EventKind(k)
+			if !kind.Valid() {
+				return nil, fmt.Errorf("kinds: unknown kind %q", k)
+			}
+			m.kinds[kind] = true
+		}
+	}
+	if cfg.MinSeverity != "" {
+		m.minSeverity = notifier.Severity(cfg.MinSeverity)
+		if !m.minSeverity.Valid() {
+			return nil, fmt.Errorf("min_severity: unknown severity %q", cfg.MinSeverity)
+		}
+	}
+	if len(cfg.Countries) > 0 {
+		m.countries = make(map[string]bool)
+		for _, c := range cfg.Countries {
+			m.countries[strings.ToUpper(c)] = true
+		}
+	}
+	if len(cfg.ASNs) > 0 {
+		m.asns = make(map[int]bool)
+		for _, asn := range cfg.ASNs {
+			m.asns[asn] = true
+		}
+	}
+	if cfg.Hours != "" {
+		start, end, err := config.ParseHours(cfg.Hours)
+		if err != nil {
+			return nil, fmt.Errorf("hours: %w", err)
+		}
+		m.hours = &hours{start: start, end: end}
+	}
+	if m.days, err = config.ParseDays(cfg.Days); err != nil {
+		return nil, fmt.Errorf("days: %w", err)
+	}
+	if cfg.When != "" {
+		if m.when, err = expr.Compile(cfg.When, config.EventSchema); err != nil {
+			return nil, fmt.Errorf("when: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// Match reports whether event satisfies every condition
+func (m *Matcher) Match(event notifier.LoginEvent) bool {
+	if !m.users.match(event.Username) || !m.terminals.match(event.Terminal) || !m.hosts.match(event.Hostname) {
+		return false
+	}
+	if len(m.prefixes) > 0 && !m.matchAddr(event) {
+		return false
+	}
+	if m.kinds != nil && !m.kinds[event.EventKind()] {
+		return false
+	}
+	if m.minSeverity != "" && !event.EventSeverity().AtLeast(m.minSeverity) {
+		return false
+	}
+	if m.countries != nil && !m.countries[event.Fields[notifier.FieldCountry]] {
+		return false
+	}
+	if m.asns != nil && !m.asns[eventASN(event)] {
+		return false
+	}
+	if m.hours != nil && !m.hours.contains(event.Timestamp) {
+		return false
+	}
+	if m.days != nil && !m.days[event.Timestamp.Local().Weekday()] {
+		return false
+	}
+	if m.when != nil && !m.when.Eval(eventEnv(event)) {
+		return false
+	}
+	return true
+}
+
+// eventAddr returns the source address of event, or an invalid Addr
+func eventAddr(event notifier.LoginEvent) netip.Addr {
+	if event.Addr.IsValid() {
+		return event.Addr.Unmap()
+	}
+	// Events decoded from agents carry only the text form
+	addr, err := netip.ParseAddr(event.IP)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return addr.Unmap()
+}
+
+// eventEnv returns the variables of config.EventSchema for event
+func eventEnv(event notifier.LoginEvent) expr.Env {
+	local := event.Timestamp.Local()
+	return expr.Env{
+		"kind":     string(event.EventKind()),
+		"severity": string(event.EventSeverity()),
+		"user":     event.Username,
+		"host":     event.Hostname,
+		"ip":       eventAddr(event),
+		"terminal": event.Terminal,
+		"source":   event.Source,
+		"os":       event.OS,
+		"detail":   event.Detail,
+		"id":       event.ID,
+		"fields":   event.Fields,
+		"hour":     local.Hour(),
+		"weekday":  strings.ToLower(local.Weekday().String()[:3]),
+		"country":  event.Fields[notifier.FieldCountry],
+		"city":     event.Fields[notifier.FieldCity],
+		"asn":      eventASN(event),
+		"as_org":   event.Fields[notifier.FieldASOrg],
+	}
+}
+
+// eventASN returns the autonomous system number of event, or 0
+func eventASN(event notifier.LoginEvent) int {
+	asn, _ := strconv.Atoi(event.Fields[notifier.FieldASN])
+	return asn
+}
+
+func (m *Matcher) matchAddr(event notifier.LoginEvent) bool {
+	addr := eventAddr(event)
+	if !addr.IsValid() {
+		return false
+	}
+	for _, p := range m.prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Any holds if one of its matchers does
+type Any []*Matcher
+
+// NewAny compiles the list of match entries named name
+func NewAny(name string, cfgs []config.MatchConfig) (Any, error) {
+	var a Any
+	for i, cfg := range cfgs {
+		m, err := NewMatcher(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("%s[%d]: %w", name, i, err)
+		}
+		a = append(a, m)
+	}
+	return a, nil
+}
+
+// Match reports whether any matcher holds; an empty list never does
+func (a Any) Match(event notifier.LoginEvent) bool {
+	for _, m := range a {
+		if m.Match(event) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package filter
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+func TestMatcher(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		// A Monday
+		return time.Date(2024, 1, 15, hour, minute, 0, 0, time.Local)
+	}
+	rootLogin := notifier.LoginEvent{
+		Kind:      notifier.KindLogin,
+		Username:  "root",
+		Hostname:  "web1",
+		IP:        "10.1.2.3",
+		Addr:      netip.MustParseAddr("10.1.2.3"),
+		Terminal:  "ssh",
+		Timestamp: at(23, 30),
+	}
+	geoLogin := notifier.LoginEvent{
+		Username: "alice",
+		IP:       "203.0.113.5",
+		Fields: map[string]string{
+			notifier.FieldCountry: "CN",
+			notifier.FieldASN:     "4134",
+			notifier.FieldASOrg:   "CHINANET-BACKBONE",
+		},
+	}
+	integrity := notifier.LoginEvent{
+		Kind:      notifier.KindIntegrity,
+		Hostname:  "db1",
+		Timestamp: at(12, 0),
+	}
+
+	tests := []struct {
+		name  string
+		cfg   config.MatchConfig
+		event notifier.LoginEvent
+		want  bool
+	}{
+		{"empty matches all", config.MatchConfig{}, rootLogin, true},
+		{"user", config.MatchConfig{Users: []string{"alice", "root"}}, rootLogin, true},
+		{"other user", config.MatchConfig{Users: []string{"alice"}}, rootLogin, false},
+		{"terminal", config.MatchConfig{Terminals: []string{"ssh"}}, rootLogin, true},
+		{"host", config.MatchConfig{Hosts: []string{"web2"}}, rootLogin, false},
+		{"cidr", config.MatchConfig{IPs: []string{"10.0.0.0/8"}}, rootLogin, true},
+		{"other cidr", config.MatchConfig{IPs: []string{"192.168.0.0/16", "10.1.2.4"}}, rootLogin, false},
+		{"ip from text", config.MatchConfig{IPs: []string{"10.1.2.3"}}, notifier.LoginEvent{IP: "10.1.2.3"}, true},
+		{"no ip", config.MatchConfig{IPs: []string{"0.0.0.0/0"}}, integrity, false},
+		{"kind", config.MatchConfig{Kinds: []string{"integrity", "brute_force"}}, integrity, true},
+		{"other kind", config.MatchConfig{Kinds: []string{"integrity"}}, rootLogin, false},
+		{"severity", config.MatchConfig{MinSeverity: "high"}, integrity, true},
+		{"low severity", config.MatchConfig{MinSeverity: "low"}, rootLogin, false},
+		{"hours", config.MatchConfig{Hours: "09:00-18:00"}, integrity, true},
+		{"outside hours", config.MatchConfig{Hours: "09:00-18:00"}, rootLogin, false},
+		{"hours past midnight", config.MatchConfig{Hours: "22:00-06:00"}, rootLogin, true},
+		{"user glob", config.MatchConfig{Users: []string{"ro*"}}, rootLogin, true},
+		{"user regex", config.MatchConfig{Users: []string{"re:^(admin|root)$"}}, rootLogin, true},
+		{"terminal glob crosses slash", config.MatchConfig{Terminals: []string{"pts*"}}, notifier.LoginEvent{Terminal: "pts/3"}, true},
+		{"host class", config.MatchConfig{Hosts: []string{"web[!1]"}}, rootLogin, false},
+		{"days", config.MatchConfig{Days: []string{"mon-fri"}}, rootLogin, true},
+		{"other days", config.MatchConfig{Days: []string{"sat", "sun"}}, rootLogin, false},
+		{"all conditions", config.MatchConfig{Users: []string{"root"}, Kinds: []string{"login"}, IPs: []string{"10.0.0.0/8"}}, rootLogin, true},
+		{"when", config.MatchConfig{When: `kind == "login" && user == "root" && ip.in("10.0.0.0/8")`}, rootLogin, true},
+		{"when fails", config.MatchConfig{When: `!ip.in("10.0.0.0/8")`}, rootLogin, false},
+		{"when time", config.MatchConfig{When: `weekday == "mon" && hour >= 22`}, rootLogin, true},
+		{"when severity", config.MatchConfig{When: `severity >= "high"`}, integrity, true},
+		{"country", config.MatchConfig{Countries: []string{"cn", "US"}}, geoLogin, true},
+		{"other country", config.MatchConfig{Countries: []string{"US"}}, geoLogin, false},
+		{"no country", config.MatchConfig{Countries: []string{"CN"}}, rootLogin, false},
+		{"asn", config.MatchConfig{ASNs: []int{4134, 4837}}, geoLogin, true},
+		{"when outside countries", config.MatchConfig{When: `country != "" && !(country in ["CN", "US"])`}, geoLogin, false},
+		{"when asn", config.MatchConfig{When: `asn == 4134 && as_org.startsWith("CHINANET")`}, geoLogin, true},
+		{"when and other conditions", config.MatchConfig{Users: []string{"alice"}, When: `user == "root"`}, rootLogin, false},
+		{"one condition fails", config.MatchConfig{Users: []string{"root"}, Kinds: []string{"logout"}}, rootLogin, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewMatcher(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Match(tt.event); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMatcherErrors(t *testing.T) {
+	tests := []config.MatchConfig{
+		{Kinds: []string{"logon"}},
+		{MinSeverity: "urgent"},
+		{IPs: []string{"10.0.0.0/33"}},
+		{Hours: "9-17"},
+		{Users: []string{"re:("}},
+		{Days: []string{"mon-fry"}},
+		{When: `user == `},
+		{When: `asn == "4134"`},
+	}
+	for _, cfg := range tests {
+		if _, err := NewMatcher(cfg); err == nil {
+			t.Errorf("Expected an error for %+v", cfg)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	// Root logins and integrity alerts
+	route, err := NewRoute(config.NotifierConfig{Match: []config.MatchConfig{
+		{Users: []string{"root"}, Kinds: []string{"login"}},
+		{Kinds: []string{"integrity"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		event notifier.LoginEvent
+		want  bool
+	}{
+		{notifier.LoginEvent{Username: "root"}, true},
+		{notifier.LoginEvent{Username: "alice"}, false},
+		{notifier.LoginEvent{Kind: notifier.KindIntegrity}, true},
+		{notifier.LoginEvent{Kind: notifier.KindFailedAuth, Username: "root"}, false},
+	}
+	for _, tt := range tests {
+		if got := route.Accepts(tt.event); got != tt.want {
+			t.Errorf("Accepts(%s) = %v, want %v", tt.event.Summary(), got, tt.want)
+		}
+	}
+
+	// The privilege_escalation.notifiers shorthand
+	privEsc := config.PrivilegeEscalationConfig{Notifiers: []string{"Security"}}
+	sudo := notifier.LoginEvent{Kind: notifier.KindPrivilegeEscalation, Username: "root"}
+	for _, tt := range []struct {
+		nc   config.NotifierConfig
+		want bool
+	}{
+		{config.NotifierConfig{Type: "slack", Name: "Security"}, true},
+		{config.NotifierConfig{Type: "slack"}, false},
+		{config.NotifierConfig{Type: "slack", Match: []config.MatchConfig{{Users: []string{"root"}}, {When: `user == "root"`}}}, false},
+	} {
+		tt.nc.Match = privEsc.RouteMatch(tt.nc)
+		route, err := NewRoute(tt.nc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := route.Accepts(sudo); got != tt.want {
+			t.Errorf("%s: Accepts(%s) = %v, want %v", tt.nc.DisplayName(), sudo.Summary(), got, tt.want)
+		}
+		if !route.Accepts(notifier.LoginEvent{Username: "root"}) {
+			t.Errorf("%s: Expected root logins to be accepted", tt.nc.DisplayName())
+		}
+	}
+
+	all, err := NewRoute(config.NotifierConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !all.Accepts(notifier.LoginEvent{Username: "alice"}) {
+		t.Error("Expected a notifier without match entries to receive every event")
+	}
+}
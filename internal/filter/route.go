@@ -0,0 +1,26 @@
+package filter
+
+import (
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// Route decides which events a notifier receives
+type Route struct {
+	match Any
+}
+
+// NewRoute compiles the match entries of a notifier
+func NewRoute(cfg config.NotifierConfig) (*Route, error) {
+	match, err := NewAny("match", cfg.Match)
+	if err != nil {
+		return nil, err
+	}
+	return &Route{match: match}, nil
+}
+
+// Accepts reports whether event should be sent to the notifier
+// A notifier without match entries receives every event
+func (r *Route) Accepts(event notifier.LoginEvent) bool {
+	return len(r.match) == 0 || r.match.Match(event)
+}
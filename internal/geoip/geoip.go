@@ -0,0 +1,96 @@
+// Package geoip adds the country, city and autonomous system of the
+// source address to events, from local MaxMind DB files
+package geoip
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// Enricher looks up source addresses in one or more databases
+type Enricher struct {
+	readers []*Reader
+}
+
+// New opens the configured databases
+// Returns nil if none is configured
+func New(cfg config.GeoIPConfig) (*Enricher, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	e := &Enricher{}
+	for _, path := range []string{cfg.Database, cfg.ASNDatabase} {
+		if path == "" {
+			continue
+		}
+		r, err := Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("geoip: %w", err)
+		}
+		e.readers = append(e.readers, r)
+	}
+	return e, nil
+}
+
+// Enrich sets the GeoIP fields of event from its source address
+// Private, loopback and other non-public addresses are left alone, as are
+// fields already set (e.g. by an agent)
+func (e *Enricher) Enrich(event *notifier.LoginEvent) {
+	addr := event.Addr
+	if !addr.IsValid() || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return
+	}
+	for _, r := range e.readers {
+		record, err := r.Lookup(addr)
+		if err != nil {
+			log.Printf("GeoIP lookup of %s in %s failed: %v", addr, r.DatabaseType(), err)
+			continue
+		}
+		if record == nil {
+			continue
+		}
+		country := path(record, "country", "iso_code")
+		if country == nil {
+			// Anonymous networks only have the registered country
+			country = path(record, "registered_country", "iso_code")
+		}
+		for _, f := range []struct {
+			key   string
+			value any
+		}{
+			{notifier.FieldCountry, country},
+			{notifier.FieldCity, path(record, "city", "names", "en")},
+			{notifier.FieldASN, path(record, "autonomous_system_number")},
+			{notifier.FieldASOrg, path(record, "autonomous_system_organization")},
+		} {
+			if event.Fields[f.key] != "" {
+				continue
+			}
+			switch v := f.value.(type) {
+			case string:
+				if v != "" {
+					event.SetField(f.key, v)
+				}
+			case uint64:
+				event.SetField(f.key, strconv.FormatUint(v, 10))
+			}
+		}
+	}
+}
+
+// path returns the value under keys in nested maps, or nil
+func path(record map[string]any, keys ...string) any {
+	var v any = record
+	for _, k := range keys {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+	return v
+}
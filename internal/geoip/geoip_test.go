@@ -0,0 +1,347 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// pointer encodes a pointer into the data section
+type pointer uint
+
+// encoder writes values in the MaxMind DB data format
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) control(typ, size int) {
+	var extra []byte
+	if typ > 7 {
+		extra = append(extra, byte(typ-7))
+		typ = typeExtended
+	}
+	switch {
+	case size < 29:
+	case size < 285:
+		extra = append(extra, byte(size-29))
+		size = 29
+	default:
+		n := size - 285
+		extra = append(extra, byte(n>>8), byte(n))
+		size = 30
+	}
+	e.buf = append(e.buf, byte(typ<<5|size))
+	e.buf = append(e.buf, extra...)
+}
+
+func (e *encoder) uint(typ int, n uint64) {
+	b := binary.BigEndian.AppendUint64(nil, n)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	e.control(typ, len(b))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) encode(v any) {
+	switch v := v.(type) {
+	case string:
+		e.control(typeString, len(v))
+		e.buf = append(e.buf, v...)
+	case uint16:
+		e.uint(typeUint16, uint64(v))
+	case uint32:
+		e.uint(typeUint32, uint64(v))
+	case uint64:
+		e.uint(typeUint64, v)
+	case bool:
+		e.control(typeBool, map[bool]int{false: 0, true: 1}[v])
+	case []any:
+		e.control(typeArray, len(v))
+		for _, el := range v {
+			e.encode(el)
+		}
+	case map[string]any:
+		e.control(typeMap, len(v))
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			e.encode(k)
+			e.encode(v[k])
+		}
+	case pointer:
+		if v < 2048 {
+			e.buf = append(e.buf, byte(typePointer<<5|int(v>>8)), byte(v))
+		} else {
+			v -= 2048
+			e.buf = append(e.buf, byte(typePointer<<5|1<<3|int(v>>16)), byte(v>>8), byte(v))
+		}
+	default:
+		panic(fmt.Sprintf("cannot encode %T", v))
+	}
+}
+
+// entry is a network and its record in a test database
+type entry struct {
+	prefix string
+	record map[string]any
+}
+
+// buildDB returns a database holding entries; shared values are written
+// first, so that records can point to them
+func buildDB(ipVersion, recordSize int, shared []any, entries []entry) []byte {
+	data := &encoder{}
+	for _, v := range shared {
+		data.encode(v)
+	}
+
+	// nodes hold two records each: 0 is empty, n > 0 is node n-1, and
+	// n < 0 is data at offset -n-1
+	nodes := [][2]int{{0, 0}}
+	for _, e := range entries {
+		offset := len(data.buf)
+		data.encode(e.record)
+
+		prefix := netip.MustParsePrefix(e.prefix)
+		ip, bits := prefix.Addr().AsSlice(), prefix.Bits()
+		if ipVersion == 6 && prefix.Addr().Is4() {
+			ip, bits = append(make([]byte, 12), ip...), bits+96
+		}
+		node := 0
+		for i := range bits {
+			bit := int(ip[i/8]>>(7-i%8)) & 1
+			if i == bits-1 {
+				nodes[node][bit] = -offset - 1
+				break
+			}
+			if nodes[node][bit] <= 0 {
+				nodes = append(nodes, [2]int{})
+				nodes[node][bit] = len(nodes)
+			}
+			node = nodes[node][bit] - 1
+		}
+	}
+
+	count := len(nodes)
+	value := func(r int) uint32 {
+		switch {
+		case r == 0:
+			return uint32(count)
+		case r > 0:
+			return uint32(r - 1)
+		default:
+			return uint32(count + 16 - r - 1)
+		}
+	}
+	var tree []byte
+	for _, n := range nodes {
+		l, r := value(n[0]), value(n[1])
+		switch recordSize {
+		case 24:
+			tree = append(tree, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			tree = append(tree, byte(l>>16), byte(l>>8), byte(l), byte(l>>24<<4|r>>24&0x0f), byte(r>>16), byte(r>>8), byte(r))
+		default:
+			tree = binary.BigEndian.AppendUint32(tree, l)
+			tree = binary.BigEndian.AppendUint32(tree, r)
+		}
+	}
+
+	meta := &encoder{}
+	meta.encode(map[string]any{
+		"node_count":                  uint32(count),
+		"record_size":                 uint16(recordSize),
+		"ip_version":                  uint16(ipVersion),
+		"database_type":               "Test-City",
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(1700000000),
+		"languages":                   []any{"en"},
+	})
+
+	buf := append(tree, make([]byte, 16)...)
+	buf = append(buf, data.buf...)
+	buf = append(buf, metadataMarker...)
+	return append(buf, meta.buf...)
+}
+
+var (
+	beijing = map[string]any{
+		"city":    map[string]any{"names": map[string]any{"en": "Beijing"}},
+		"country": map[string]any{"iso_code": pointer(0)}, // the shared "CN"
+	}
+	chinanet = map[string]any{
+		"autonomous_system_number":       uint32(4134),
+		"autonomous_system_organization": "CHINANET-BACKBONE No.31,Jin-rong Street",
+	}
+	anonymous = map[string]any{
+		"registered_country": map[string]any{"iso_code": "US"},
+		"traits":             map[string]any{"is_anonymous_proxy": true},
+	}
+)
+
+func TestLookup(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		db, err := newReader(buildDB(6, recordSize, []any{"CN"}, []entry{
+			{"203.0.113.0/24", beijing},
+			{"2001:db8::/32", anonymous},
+		}))
+		if err != nil {
+			t.Fatalf("record size %d: %v", recordSize, err)
+		}
+		if db.DatabaseType() != "Test-City" {
+			t.Errorf("Expected database type Test-City, got %q", db.DatabaseType())
+		}
+
+		tests := []struct {
+			addr string
+			keys []string
+			want any
+		}{
+			{"203.0.113.5", []string{"country", "iso_code"}, "CN"},
+			{"::ffff:203.0.113.200", []string{"city", "names", "en"}, "Beijing"},
+			{"2001:db8:1::1", []string{"traits", "is_anonymous_proxy"}, true},
+			{"198.51.100.1", nil, nil},
+			{"2001:db9::1", nil, nil},
+		}
+		for _, tt := range tests {
+			record, err := db.Lookup(netip.MustParseAddr(tt.addr))
+			if err != nil {
+				t.Fatalf("record size %d: %s: %v", recordSize, tt.addr, err)
+			}
+			if tt.keys == nil {
+				if record != nil {
+					t.Errorf("record size %d: Expected no record for %s, got %v", recordSize, tt.addr, record)
+				}
+				continue
+			}
+			if got := path(record, tt.keys...); got != tt.want {
+				t.Errorf("record size %d: Expected %v for %s, got %v", recordSize, tt.want, tt.addr, got)
+			}
+		}
+	}
+}
+
+func TestLookupIPv4Database(t *testing.T) {
+	db, err := newReader(buildDB(4, 24, nil, []entry{{"203.0.113.0/25", chinanet}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := db.Lookup(netip.MustParseAddr("203.0.113.127"))
+	if err != nil || path(record, "autonomous_system_number") != uint64(4134) {
+		t.Errorf("Expected ASN 4134, got %v (%v)", record, err)
+	}
+	for _, addr := range []string{"203.0.113.128", "2001:db8::1"} {
+		if record, err := db.Lookup(netip.MustParseAddr(addr)); record != nil || err != nil {
+			t.Errorf("Expected no record for %s, got %v (%v)", addr, record, err)
+		}
+	}
+}
+
+func TestCorruptDatabase(t *testing.T) {
+	if _, err := newReader([]byte("not a database")); err == nil {
+		t.Error("Expected an error without metadata")
+	}
+
+	// A node count larger than the file, or overflowing the tree size
+	for _, count := range []uint64{1 << 20, math.MaxUint64/7 + 1} {
+		meta := &encoder{}
+		meta.encode(map[string]any{
+			"node_count":                  count,
+			"record_size":                 uint16(28),
+			"ip_version":                  uint16(6),
+			"binary_format_major_version": uint16(2),
+		})
+		buf := append(make([]byte, 64), metadataMarker...)
+		if _, err := newReader(append(buf, meta.buf...)); err == nil {
+			t.Errorf("Expected an error for node count %d", count)
+		}
+	}
+
+	// A record cut short
+	db, err := newReader(buildDB(4, 32, nil, []entry{{"203.0.113.0/24", chinanet}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.data = db.data[:len(db.data)/2]
+	if _, err := db.Lookup(netip.MustParseAddr("203.0.113.1")); err == nil {
+		t.Error("Expected an error for truncated data")
+	}
+}
+
+func TestEnrich(t *testing.T) {
+	dir := t.TempDir()
+	city := filepath.Join(dir, "city.mmdb")
+	asn := filepath.Join(dir, "asn.mmdb")
+	if err := os.WriteFile(city, buildDB(6, 28, []any{"CN"}, []entry{{"203.0.113.0/24", beijing}, {"2001:db8::/32", anonymous}}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(asn, buildDB(6, 24, nil, []entry{{"203.0.113.0/24", chinanet}}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	geo, err := New(config.GeoIPConfig{Database: city, ASNDatabase: asn})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := notifier.LoginEvent{Username: "root", Hostname: "web1", IP: "203.0.113.5"}
+	event.ParseSource()
+	geo.Enrich(&event)
+	want := map[string]string{
+		notifier.FieldCountry: "CN",
+		notifier.FieldCity:    "Beijing",
+		notifier.FieldASN:     "4134",
+		notifier.FieldASOrg:   "CHINANET-BACKBONE No.31,Jin-rong Street",
+	}
+	for k, v := range want {
+		if event.Fields[k] != v {
+			t.Errorf("Expected %s = %q, got %q", k, v, event.Fields[k])
+		}
+	}
+	if got, want := event.Location(), "Beijing, CN · AS4134 CHINANET-BACKBONE No.31,Jin-rong Street"; got != want {
+		t.Errorf("Expected location %q, got %q", want, got)
+	}
+
+	// Registered country only; a country set by an agent is kept
+	proxy := notifier.LoginEvent{IP: "2001:db8::7"}
+	proxy.ParseSource()
+	geo.Enrich(&proxy)
+	if proxy.Fields[notifier.FieldCountry] != "US" {
+		t.Errorf("Expected the registered country, got %v", proxy.Fields)
+	}
+	forwarded := notifier.LoginEvent{IP: "203.0.113.5", Fields: map[string]string{notifier.FieldCountry: "JP"}}
+	forwarded.ParseSource()
+	geo.Enrich(&forwarded)
+	if forwarded.Fields[notifier.FieldCountry] != "JP" || forwarded.Fields[notifier.FieldCity] != "Beijing" {
+		t.Errorf("Expected existing fields to be kept, got %v", forwarded.Fields)
+	}
+
+	for _, ip := range []string{"10.0.0.1", "127.0.0.1", "fe80::1", "web1.example.com", ""} {
+		local := notifier.LoginEvent{IP: ip}
+		local.ParseSource()
+		geo.Enrich(&local)
+		if len(local.Fields) != 0 {
+			t.Errorf("Expected no fields for %q, got %v", ip, local.Fields)
+		}
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if geo, err := New(config.GeoIPConfig{}); geo != nil || err != nil {
+		t.Errorf("Expected no enricher, got %v, %v", geo, err)
+	}
+	if _, err := New(config.GeoIPConfig{Database: filepath.Join(t.TempDir(), "missing.mmdb")}); err == nil {
+		t.Error("Expected an error for a missing database")
+	}
+}
@@ -0,0 +1,344 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+)
+
+// metadataMarker starts the metadata section at the end of a database
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// maxDepth bounds the nesting of decoded values
+const maxDepth = 32
+
+// errCorrupt is returned for data outside the database
+var errCorrupt = errors.New("corrupt database")
+
+// Reader looks up addresses in a MaxMind DB (.mmdb) file, as published
+// for GeoLite2, GeoIP2, DB-IP and others
+// See https://maxmind.github.io/MaxMind-DB/
+type Reader struct {
+	tree       []byte
+	data       []byte // the data section
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint // node reached after the 96 zero bits of ::/96
+	dbType     string
+}
+
+// Open reads a database file into memory
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newReader(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+func newReader(buf []byte) (*Reader, error) {
+	start := bytes.LastIndex(buf, metadataMarker)
+	if start < 0 {
+		return nil, errors.New("not a MaxMind DB file")
+	}
+	d := decoder{buf: buf[start+len(metadataMarker):]}
+	v, _, err := d.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %w", err)
+	}
+	meta, ok := v.(map[string]any)
+	if !ok {
+		return nil, errors.New("metadata: not a map")
+	}
+	r := &Reader{
+		nodeCount:  uintValue(meta["node_count"]),
+		recordSize: uintValue(meta["record_size"]),
+		ipVersion:  uintValue(meta["ip_version"]),
+	}
+	r.dbType, _ = meta["database_type"].(string)
+	if major := uintValue(meta["binary_format_major_version"]); major != 2 {
+		return nil, fmt.Errorf("unsupported format version %d", major)
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", r.ipVersion)
+	}
+
+	// The tree is followed by 16 zero bytes, then the data section
+	// The node count is checked before multiplying, which could overflow
+	if r.nodeCount > uint(start)/(r.recordSize/4) {
+		return nil, errCorrupt
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+16 > uint(start) {
+		return nil, errCorrupt
+	}
+	r.tree = buf[:treeSize]
+	r.data = buf[treeSize+16 : start]
+
+	if r.ipVersion == 6 {
+		for i := 0; i < 96 && r.ipv4Start < r.nodeCount; i++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// DatabaseType returns the type in the metadata (e.g. GeoLite2-City)
+func (r *Reader) DatabaseType() string {
+	return r.dbType
+}
+
+// Lookup returns the record of addr, or nil if the database has none
+func (r *Reader) Lookup(addr netip.Addr) (map[string]any, error) {
+	addr = addr.Unmap()
+	node, bits := uint(0), 128
+	ip := addr.As16()
+	if addr.Is4() {
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+		bits = 32
+		copy(ip[:4], ip[12:])
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		bit := uint(ip[i/8]>>(7-i%8)) & 1
+		node = r.record(node, bit)
+	}
+	if node <= r.nodeCount {
+		// Equal to the node count: no data for the address
+		return nil, nil
+	}
+
+	offset := node - r.nodeCount - 16
+	d := decoder{buf: r.data}
+	v, _, err := d.decode(offset, 0)
+	if err != nil {
+		return nil, err
+	}
+	record, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("record is a %T, not a map", v)
+	}
+	return record, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of a node
+func (r *Reader) record(node, bit uint) uint {
+	size := r.recordSize / 4 // bytes per node
+	b := r.tree[node*size : (node+1)*size]
+	switch r.recordSize {
+	case 24:
+		b = b[bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(b[bit*4:]))
+	}
+}
+
+// Data types of the data section
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// decoder decodes values of a data section
+type decoder struct {
+	buf []byte
+}
+
+// decode returns the value at offset and the offset following it
+// Maps decode to map[string]any, arrays to []any, unsigned integers to
+// uint64 (uint128 to []byte), int32 to int64 and floats to float64
+func (d *decoder) decode(offset uint, depth int) (any, uint, error) {
+	if depth > maxDepth {
+		return nil, 0, errors.New("data nested too deeply")
+	}
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if typ == typePointer {
+		// size holds the pointer; the value continues after the pointer
+		target, _, err := d.decode(size, depth+1)
+		return target, offset, err
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]any, min(size, 64))
+		for range size {
+			var key, value any
+			if key, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("map key is a %T, not a string", key)
+			}
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			m[name] = value
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]any, 0, min(size, 64))
+		for range size {
+			var value any
+			if value, offset, err = d.decode(offset, depth+1); err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	case typeContainer, typeEndMarker:
+		return nil, 0, fmt.Errorf("unexpected data type %d", typ)
+	}
+
+	b, err := d.bytes(offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset += size
+	switch typ {
+	case typeString:
+		return string(b), offset, nil
+	case typeBytes, typeUint128:
+		return bytes.Clone(b), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, errCorrupt
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, errCorrupt
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		if size > 8 {
+			return nil, 0, errCorrupt
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset, nil
+	case typeInt32:
+		if size > 4 {
+			return nil, 0, errCorrupt
+		}
+		var n uint32
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), offset, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown data type %d", typ)
+	}
+}
+
+// control decodes the control byte at offset, returning the type, the
+// size (or target of a pointer) and the offset of the payload
+func (d *decoder) control(offset uint) (typ int, size, next uint, err error) {
+	b, err := d.bytes(offset, 1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	ctrl := b[0]
+	offset++
+	typ = int(ctrl >> 5)
+
+	if typ == typePointer {
+		n := uint(ctrl>>3) & 3
+		b, err := d.bytes(offset, n+1)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		var p uint
+		if n < 3 {
+			p = uint(ctrl & 7)
+		}
+		for _, c := range b {
+			p = p<<8 | uint(c)
+		}
+		p += [...]uint{0, 2048, 526336, 0}[n]
+		return typ, p, offset + n + 1, nil
+	}
+
+	if typ == typeExtended {
+		b, err := d.bytes(offset, 1)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		typ = 7 + int(b[0])
+		offset++
+	}
+
+	size = uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28 // bytes holding the size
+		b, err := d.bytes(offset, n)
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		size = 0
+		for _, c := range b {
+			size = size<<8 | uint(c)
+		}
+		size += [...]uint{29, 285, 65821}[n-1]
+		offset += n
+	}
+	return typ, size, offset, nil
+}
+
+// bytes returns n bytes at offset, or an error if they are out of range
+func (d *decoder) bytes(offset, n uint) ([]byte, error) {
+	if offset > uint(len(d.buf)) || n > uint(len(d.buf))-offset {
+		return nil, errCorrupt
+	}
+	return d.buf[offset : offset+n], nil
+}
+
+// uintValue returns v if it is an unsigned integer, or 0
+func uintValue(v any) uint {
+	n, _ := v.(uint64)
+	return uint(n)
+}
@@ -2,6 +2,7 @@ package notifier
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -17,10 +18,11 @@ import (
 
 // DingTalk implements DingTalk robot notifications
 type DingTalk struct {
-	name    string
-	webhook string
-	secret  string
-	client  *http.Client
+	name     string
+	webhook  string
+	secret   string
+	client   *http.Client
+	messages *messageTemplates
 }
 
 // NewDingTalk creates a new DingTalk notifier
@@ -30,18 +32,19 @@ func NewDingTalk(cfg config.NotifierConfig) (*DingTalk, error) {
 		return nil, fmt.Errorf("dingtalk: webhook is required")
 	}
 
-	name := cfg.Name
-	if name == "" {
-		name = "DingTalk"
+	messages, err := newMessageTemplates(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("dingtalk: %w", err)
 	}
 
 	return &DingTalk{
-		name:    name,
+		name:    cfg.DisplayName(),
 		webhook: webhook,
 		secret:  cfg.Config["secret"],
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		messages: messages,
 	}, nil
 }
 
@@ -51,7 +54,7 @@ func (d *DingTalk) Name() string {
 }
 
 // Send sends a DingTalk notification
-func (d *DingTalk) Send(event LoginEvent) error {
+func (d *DingTalk) Send(ctx context.Context, event LoginEvent) error {
 	webhookURL := d.webhook
 
 	// Add signature if secret is configured
@@ -64,7 +67,7 @@ func (d *DingTalk) Send(event LoginEvent) error {
 	payload := map[string]interface{}{
 		"msgtype": "text",
 		"text": map[string]string{
-			"content": event.Format(),
+			"content": d.messages.Body(event),
 		},
 	}
 
@@ -73,7 +76,7 @@ func (d *DingTalk) Send(event LoginEvent) error {
 		return fmt.Errorf("dingtalk: failed to marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", webhookURL, bytes.NewReader(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewReader(jsonData))
 	if err != nil {
 		return fmt.Errorf("dingtalk: failed to create request: %w", err)
 	}
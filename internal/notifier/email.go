@@ -1,7 +1,11 @@
 package notifier
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"mime"
+	"net"
 	"net/smtp"
 	"strconv"
 	"strings"
@@ -18,6 +22,7 @@ type Email struct {
 	password string
 	from     string
 	to       []string
+	messages *messageTemplates
 }
 
 // NewEmail creates a new Email notifier
@@ -53,19 +58,20 @@ func NewEmail(cfg config.NotifierConfig) (*Email, error) {
 		to[i] = strings.TrimSpace(to[i])
 	}
 
-	name := cfg.Name
-	if name == "" {
-		name = "Email"
+	messages, err := newMessageTemplates(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("email: %w", err)
 	}
 
 	return &Email{
-		name:     name,
+		name:     cfg.DisplayName(),
 		host:     host,
 		port:     port,
 		username: username,
 		password: password,
 		from:     from,
 		to:       to,
+		messages: messages,
 	}, nil
 }
 
@@ -75,26 +81,12 @@ func (e *Email) Name() string {
 }
 
 // Send sends an email notification
-func (e *Email) Send(event LoginEvent) error {
-	subject := fmt.Sprintf("Login Alert: %s logged in to %s", event.Username, event.Hostname)
-
-	body := fmt.Sprintf(`Login detected on your system:
-
-User: %s
-Hostname: %s
-Time: %s
-OS: %s`,
-		event.Username,
-		event.Hostname,
-		event.Timestamp.Format("2006-01-02 15:04:05 MST"),
-		event.OS,
-	)
+func (e *Email) Send(ctx context.Context, event LoginEvent) error {
+	subject := e.messages.Subject(event, fmt.Sprintf("%s: %s", event.EventKind().Title(), event.Summary()))
 
-	if event.IP != "" {
-		body += fmt.Sprintf("\nIP Address: %s", event.IP)
-	}
-	if event.Terminal != "" {
-		body += fmt.Sprintf("\nTerminal: %s", event.Terminal)
+	body := fmt.Sprintf("%s detected on your system:\n\n%s", event.EventKind().Title(), event.Body())
+	if e.messages.Custom() {
+		body = e.messages.Body(event)
 	}
 
 	msg := fmt.Sprintf("From: %s\r\n"+
@@ -106,7 +98,7 @@ OS: %s`,
 		"%s",
 		e.from,
 		strings.Join(e.to, ", "),
-		subject,
+		headerText(subject),
 		body,
 	)
 
@@ -117,9 +109,77 @@ OS: %s`,
 		auth = smtp.PlainAuth("", e.username, e.password, e.host)
 	}
 
-	if err := smtp.SendMail(addr, auth, e.from, e.to, []byte(msg)); err != nil {
+	if err := sendMail(ctx, addr, e.host, auth, e.from, e.to, []byte(msg)); err != nil {
 		return fmt.Errorf("email: failed to send: %w", err)
 	}
 
 	return nil
 }
+
+// headerText makes s safe to use as a header value: runs of whitespace,
+// including line breaks that would start another header, become a single
+// space, and non-ASCII text is encoded as RFC 2047 words
+func headerText(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return mime.QEncoding.Encode("utf-8", s)
+}
+
+// sendMail is smtp.SendMail, aborted when ctx is done
+func sendMail(ctx context.Context, addr, host string, auth smtp.Auth, from string, to []string, msg []byte) (err error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Closing the connection unblocks whatever the exchange is waiting for
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer func() {
+		stop()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("server doesn't support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
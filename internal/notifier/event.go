@@ -0,0 +1,399 @@
+package notifier
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EventKind identifies what an event describes
+type EventKind string
+
+const (
+	// KindLogin is a successful login (the zero value is treated as a login)
+	KindLogin EventKind = "login"
+	// KindLogout is the end of a login session
+	KindLogout EventKind = "logout"
+	// KindFailedAuth is a failed authentication attempt
+	KindFailedAuth EventKind = "failed_auth"
+	// KindBruteForce is raised when many failed attempts occur in a short window
+	KindBruteForce EventKind = "brute_force"
+	// KindPrivilegeEscalation is a user switching identity (sudo, su)
+	KindPrivilegeEscalation EventKind = "privilege_escalation"
+	// KindIntegrity is a log tampering alert
+	KindIntegrity EventKind = "integrity"
+	// KindAgentSilent is raised by a server when an agent stops reporting
+	KindAgentSilent EventKind = "agent_silent"
+	// KindDigest summarizes events batched by a notifier
+	KindDigest EventKind = "digest"
+)
+
+// kindInfo holds the presentation details of each event kind
+var kindInfo = map[EventKind]struct {
+	icon     string
+	title    string
+	severity Severity
+}{
+	KindLogin:               {"🔔", "Login Alert", SeverityInfo},
+	KindLogout:              {"👋", "Logout", SeverityInfo},
+	KindFailedAuth:          {"⚠️", "Failed Login", SeverityLow},
+	KindBruteForce:          {"🚨", "Brute Force Alert", SeverityHigh},
+	KindPrivilegeEscalation: {"🔑", "Privilege Escalation", SeverityMedium},
+	KindIntegrity:           {"🛡️", "Log Integrity Alert", SeverityCritical},
+	KindAgentSilent:         {"📴", "Agent Silent", SeverityHigh},
+	KindDigest:              {"📋", "Digest", SeverityInfo},
+}
+
+// Title returns the plain-text title of the kind (e.g. "Login Alert")
+func (k EventKind) Title() string {
+	if info, ok := kindInfo[k]; ok {
+		return info.title
+	}
+	return string(k)
+}
+
+// DefaultSeverity returns the severity of events of kind k that set none
+func (k EventKind) DefaultSeverity() Severity {
+	if s := kindInfo[k].severity; s != "" {
+		return s
+	}
+	return SeverityInfo
+}
+
+// Valid reports whether k is a known event kind
+func (k EventKind) Valid() bool {
+	_, ok := kindInfo[k]
+	return ok
+}
+
+// Severity ranks how urgent an event is
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+)
+
+// severityLevels orders severities from least to most urgent
+var severityLevels = map[Severity]int{
+	SeverityInfo:     0,
+	SeverityLow:      1,
+	SeverityMedium:   2,
+	SeverityHigh:     3,
+	SeverityCritical: 4,
+}
+
+// Level returns the numeric rank of the severity (info = 0), or -1 if unknown
+func (s Severity) Level() int {
+	if level, ok := severityLevels[s]; ok {
+		return level
+	}
+	return -1
+}
+
+// AtLeast reports whether s is as urgent as min or more
+func (s Severity) AtLeast(min Severity) bool {
+	return s.Level() >= min.Level()
+}
+
+// Valid reports whether s is a known severity
+func (s Severity) Valid() bool {
+	return s.Level() >= 0
+}
+
+// Well-known keys for LoginEvent.Fields
+const (
+	FieldFile      = "file"       // affected file (integrity)
+	FieldChange    = "change"     // what changed (integrity)
+	FieldDuration  = "duration"   // session length (logout)
+	FieldPID       = "pid"        // process that owned the session
+	FieldSessionID = "session_id" // logind session ID
+	FieldPort      = "port"       // client source port
+	FieldMethod    = "method"     // authentication method (e.g. password, publickey)
+
+	FieldKeyType       = "key_type"       // SSH key algorithm (e.g. ED25519, RSA-CERT)
+	FieldFingerprint   = "fingerprint"    // SSH key fingerprint (SHA256:...)
+	FieldKeyOwner      = "key_owner"      // owner of a known key (config known_keys)
+	FieldCertID        = "cert_id"        // SSH certificate key ID
+	FieldCertSerial    = "cert_serial"    // SSH certificate serial
+	FieldCAFingerprint = "ca_fingerprint" // fingerprint of the CA that signed the certificate
+
+	FieldTargetUser = "target_user" // user switched to (privilege escalation)
+	FieldCommand    = "command"     // command run as the target user (sudo)
+
+	FieldAUID   = "auid"   // audit login uid: who originally logged in (auditd)
+	FieldResult = "result" // outcome reported by the source (auditd res=)
+
+	FieldSender = "sender" // address that forwarded the message (syslog)
+	FieldAgent  = "agent"  // agent that forwarded the event (server mode)
+	FieldSilent = "silent" // how long the agent has not reported (agent_silent)
+
+	FieldSuppressed = "suppressed" // similar events left out since the last notification (dedup, rate limit)
+
+	FieldCountry = "country" // ISO country code of the source address (GeoIP)
+	FieldCity    = "city"    // city of the source address (GeoIP)
+	FieldASN     = "asn"     // autonomous system number of the source address (GeoIP)
+	FieldASOrg   = "as_org"  // organization owning the autonomous system (GeoIP)
+
+	FieldEvents = "events" // number of events summarized (digest)
+	FieldPeriod = "period" // times of the first and last events (digest)
+)
+
+// fieldLabels overrides the generated label of well-known fields
+var fieldLabels = map[string]string{
+	FieldMethod:        "Auth Method",
+	FieldFingerprint:   "Key Fingerprint",
+	FieldCertID:        "Certificate ID",
+	FieldCertSerial:    "Certificate Serial",
+	FieldCAFingerprint: "CA Fingerprint",
+	FieldSessionID:     "Session ID",
+	FieldPID:           "PID",
+	FieldAUID:          "Audit UID",
+	FieldASN:           "ASN",
+	FieldASOrg:         "AS Organization",
+}
+
+// locationFields are shown together by Location
+var locationFields = []string{FieldCity, FieldCountry, FieldASN, FieldASOrg}
+
+// LoginEvent represents an event to be notified
+// Despite its name it carries every kind of event (see Kind)
+// The JSON form is exchanged between agents and a server
+type LoginEvent struct {
+	ID        string            `json:"id"`                 // unique event identifier
+	Kind      EventKind         `json:"kind,omitempty"`     // what happened (defaults to login)
+	Severity  Severity          `json:"severity,omitempty"` // how urgent it is (defaults per kind)
+	Username  string            `json:"username,omitempty"` // user who logged in
+	Hostname  string            `json:"hostname"`           // hostname of the machine
+	IP        string            `json:"ip,omitempty"`       // source address or hostname (if available)
+	Addr      netip.Addr        `json:"-"`                  // IP parsed, invalid if IP is a hostname or empty
+	Terminal  string            `json:"terminal,omitempty"` // terminal/session type (tty, pts, console, etc.)
+	Timestamp time.Time         `json:"timestamp"`          // when the login occurred
+	OS        string            `json:"os,omitempty"`       // operating system
+	Source    string            `json:"source,omitempty"`   // name of the log source that reported it (if several)
+	Detail    string            `json:"detail,omitempty"`   // extra human-readable context (e.g. failure reason)
+	Fields    map[string]string `json:"fields,omitempty"`   // kind-specific structured data
+}
+
+// NewEventID returns a random unique event identifier
+func NewEventID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// FillDefaults sets the ID, kind and severity if they are missing
+func (e *LoginEvent) FillDefaults() {
+	if e.ID == "" {
+		e.ID = NewEventID()
+	}
+	e.Kind = e.EventKind()
+	e.Severity = e.EventSeverity()
+	e.ParseSource()
+}
+
+// ParseSource normalizes IP and sets Addr from it
+// IPv4-mapped IPv6 addresses are unmapped (::ffff:192.0.2.1 → 192.0.2.1);
+// a hostname is kept as is and leaves Addr invalid
+func (e *LoginEvent) ParseSource() {
+	if e.Addr.IsValid() || e.IP == "" {
+		return
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(e.IP, "["), "]"))
+	if err != nil {
+		return
+	}
+	e.Addr = addr.Unmap()
+	e.IP = e.Addr.String()
+}
+
+// EventKind returns the event kind, defaulting to login
+func (e LoginEvent) EventKind() EventKind {
+	if e.Kind == "" {
+		return KindLogin
+	}
+	return e.Kind
+}
+
+// EventSeverity returns the event severity, defaulting per kind
+func (e LoginEvent) EventSeverity() Severity {
+	if e.Severity != "" {
+		return e.Severity
+	}
+	return e.EventKind().DefaultSeverity()
+}
+
+// SetField sets a structured field, allocating the map if needed
+func (e *LoginEvent) SetField(key, value string) {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	e.Fields[key] = value
+}
+
+// Title returns a short headline for the event, prefixed with an icon
+// A follow-up on suppressed events says how many were left out
+func (e LoginEvent) Title() string {
+	if n := e.Fields[FieldSuppressed]; n == "1" {
+		return "🔕 1 similar event suppressed"
+	} else if n != "" {
+		return "🔕 " + n + " similar events suppressed"
+	}
+	kind := e.EventKind()
+	if info, ok := kindInfo[kind]; ok {
+		return info.icon + " " + info.title
+	}
+	return "🔔 " + kind.Title()
+}
+
+// Summary returns a one-line description of the event
+func (e LoginEvent) Summary() string {
+	switch e.EventKind() {
+	case KindLogin:
+		return fmt.Sprintf("%s logged in to %s", e.Username, e.Hostname)
+	case KindLogout:
+		return fmt.Sprintf("%s logged out of %s", e.Username, e.Hostname)
+	case KindPrivilegeEscalation:
+		summary := fmt.Sprintf("%s became %s on %s", e.Username, e.Fields[FieldTargetUser], e.Hostname)
+		if command := e.Fields[FieldCommand]; command != "" {
+			summary += ": " + command
+		}
+		return summary
+	case KindAgentSilent:
+		return fmt.Sprintf("agent %s has not reported for %s", e.Hostname, e.Fields[FieldSilent])
+	case KindDigest:
+		return fmt.Sprintf("%s events on %s", e.Fields[FieldEvents], e.Hostname)
+	case KindFailedAuth:
+		if e.IP != "" {
+			return fmt.Sprintf("failed login for %s on %s from %s", e.Username, e.Hostname, e.IP)
+		}
+		return fmt.Sprintf("failed login for %s on %s", e.Username, e.Hostname)
+	default:
+		if e.Detail != "" {
+			return fmt.Sprintf("%s on %s", e.Detail, e.Hostname)
+		}
+		return fmt.Sprintf("%s on %s", e.EventKind().Title(), e.Hostname)
+	}
+}
+
+// Location describes where the source address is, from the GeoIP fields
+// (e.g. "Beijing, CN · AS4134 CHINANET"), or "" if unknown
+func (e LoginEvent) Location() string {
+	var place []string
+	for _, k := range []string{FieldCity, FieldCountry} {
+		if v := e.Fields[k]; v != "" {
+			place = append(place, v)
+		}
+	}
+	parts := []string{strings.Join(place, ", ")}
+	if asn := e.Fields[FieldASN]; asn != "" {
+		parts = append(parts, strings.TrimSpace("AS"+asn+" "+e.Fields[FieldASOrg]))
+	} else if org := e.Fields[FieldASOrg]; org != "" {
+		parts = append(parts, org)
+	}
+	if parts[0] == "" {
+		parts = parts[1:]
+	}
+	return strings.Join(parts, " · ")
+}
+
+// DisplayFieldKeys returns the keys of Fields shown on lines of their
+// own, in a stable order: all but those summed up by Location
+func (e LoginEvent) DisplayFieldKeys() []string {
+	keys := e.SortedFieldKeys()
+	return slices.DeleteFunc(keys, func(k string) bool { return slices.Contains(locationFields, k) })
+}
+
+// SortedFieldKeys returns the keys of Fields in a stable order
+func (e LoginEvent) SortedFieldKeys() []string {
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FieldLabel turns a field key into a display label (e.g. "auth_method" → "Auth Method")
+func FieldLabel(key string) string {
+	if label, ok := fieldLabels[key]; ok {
+		return label
+	}
+	words := strings.Split(key, "_")
+	for i, w := range words {
+		if w != "" {
+			words[i] = strings.ToUpper(w[:1]) + w[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// Format returns a formatted message for the event
+func (e LoginEvent) Format() string {
+	return e.Title() + "\n\n" + e.Body()
+}
+
+// Body returns the event details as "Label: value" lines
+func (e LoginEvent) Body() string {
+	// Get timezone info
+	zone, offset := e.Timestamp.Zone()
+	offsetHours := offset / 3600
+	var offsetStr string
+	if offsetHours >= 0 {
+		offsetStr = fmt.Sprintf("UTC+%d", offsetHours)
+	} else {
+		offsetStr = fmt.Sprintf("UTC%d", offsetHours)
+	}
+
+	var lines []string
+	if e.Username != "" {
+		lines = append(lines, "User: "+e.Username)
+	}
+	lines = append(lines,
+		"Host: "+e.Hostname,
+		"Time: "+e.Timestamp.Format("2006-01-02 15:04:05"),
+		fmt.Sprintf("Zone: %s (%s)", zone, offsetStr),
+	)
+
+	// The OS is unknown for events received from other hosts
+	if e.OS != "" {
+		lines = append(lines, "OS: "+e.OS)
+	}
+	if e.Source != "" {
+		lines = append(lines, "Source: "+e.Source)
+	}
+	if e.IP != "" {
+		lines = append(lines, "IP: "+e.IP)
+	}
+	if location := e.Location(); location != "" {
+		lines = append(lines, "Location: "+location)
+	}
+	if e.Terminal != "" {
+		lines = append(lines, "Terminal: "+e.Terminal)
+	}
+	if s := e.EventSeverity(); s != SeverityInfo {
+		lines = append(lines, "Severity: "+string(s))
+	}
+	if e.Detail != "" && e.EventKind() != KindDigest {
+		lines = append(lines, "Detail: "+e.Detail)
+	}
+	for _, k := range e.DisplayFieldKeys() {
+		lines = append(lines, FieldLabel(k)+": "+e.Fields[k])
+	}
+	// The detail of a digest lists its events, one group per line
+	if e.Detail != "" && e.EventKind() == KindDigest {
+		lines = append(lines, "", e.Detail)
+	}
+
+	return strings.Join(lines, "\n")
+}
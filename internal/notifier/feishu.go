@@ -2,6 +2,7 @@ package notifier
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -16,10 +17,11 @@ import (
 
 // Feishu implements Feishu (Lark) robot notifications
 type Feishu struct {
-	name    string
-	webhook string
-	secret  string
-	client  *http.Client
+	name     string
+	webhook  string
+	secret   string
+	client   *http.Client
+	messages *messageTemplates
 }
 
 // NewFeishu creates a new Feishu notifier
@@ -29,18 +31,19 @@ func NewFeishu(cfg config.NotifierConfig) (*Feishu, error) {
 		return nil, fmt.Errorf("feishu: webhook is required")
 	}
 
-	name := cfg.Name
-	if name == "" {
-		name = "Feishu"
+	messages, err := newMessageTemplates(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("feishu: %w", err)
 	}
 
 	return &Feishu{
-		name:    name,
+		name:    cfg.DisplayName(),
 		webhook: webhook,
 		secret:  cfg.Config["secret"],
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		messages: messages,
 	}, nil
 }
 
@@ -57,14 +60,14 @@ func (f *Feishu) sign(timestamp int64) string {
 }
 
 // Send sends a Feishu notification
-func (f *Feishu) Send(event LoginEvent) error {
+func (f *Feishu) Send(ctx context.Context, event LoginEvent) error {
 	timestamp := time.Now().Unix()
 
 	// Build message payload
 	payload := map[string]interface{}{
 		"msg_type": "text",
 		"content": map[string]string{
-			"text": event.Format(),
+			"text": f.messages.Body(event),
 		},
 	}
 
@@ -79,7 +82,7 @@ func (f *Feishu) Send(event LoginEvent) error {
 		return fmt.Errorf("feishu: failed to marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", f.webhook, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", f.webhook, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("feishu: failed to create request: %w", err)
 	}
@@ -94,7 +97,7 @@ func (f *Feishu) Send(event LoginEvent) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("feishu: unexpected status %d: %s", resp.StatusCode, string(body))
+		return statusError("feishu", resp.StatusCode, body)
 	}
 
 	// Check response
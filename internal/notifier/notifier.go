@@ -1,64 +1,57 @@
 package notifier
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"time"
+	"net/http"
 
 	"github.com/xsddz/whozere/internal/config"
 )
 
-// LoginEvent represents a login event to be notified
-type LoginEvent struct {
-	Username  string    // user who logged in
-	Hostname  string    // hostname of the machine
-	IP        string    // source IP address (if available)
-	Terminal  string    // terminal/session type (tty, pts, console, etc.)
-	Timestamp time.Time // when the login occurred
-	OS        string    // operating system
+// Notifier is the interface for sending notifications
+type Notifier interface {
+	// Name returns the notifier name
+	Name() string
+	// Send sends a notification for a login event, giving up when ctx
+	// is done
+	Send(ctx context.Context, event LoginEvent) error
 }
 
-// Format returns a formatted message for the login event
-func (e LoginEvent) Format() string {
-	// Get timezone info
-	zone, offset := e.Timestamp.Zone()
-	offsetHours := offset / 3600
-	var offsetStr string
-	if offsetHours >= 0 {
-		offsetStr = fmt.Sprintf("UTC+%d", offsetHours)
-	} else {
-		offsetStr = fmt.Sprintf("UTC%d", offsetHours)
-	}
+// PermanentError is a failure that retrying will not fix, such as a
+// message the endpoint rejects
+type PermanentError struct {
+	Err error
+}
 
-	msg := fmt.Sprintf("🔔 Login Alert\n\n"+
-		"User: %s\n"+
-		"Host: %s\n"+
-		"Time: %s\n"+
-		"Zone: %s (%s)\n"+
-		"OS: %s",
-		e.Username,
-		e.Hostname,
-		e.Timestamp.Format("2006-01-02 15:04:05"),
-		zone,
-		offsetStr,
-		e.OS,
-	)
+func (e *PermanentError) Error() string { return e.Err.Error() }
 
-	if e.IP != "" {
-		msg += fmt.Sprintf("\nIP: %s", e.IP)
-	}
-	if e.Terminal != "" {
-		msg += fmt.Sprintf("\nTerminal: %s", e.Terminal)
-	}
+func (e *PermanentError) Unwrap() error { return e.Err }
 
-	return msg
+// Permanent marks err as not worth retrying
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
 }
 
-// Notifier is the interface for sending notifications
-type Notifier interface {
-	// Name returns the notifier name
-	Name() string
-	// Send sends a notification for a login event
-	Send(event LoginEvent) error
+// IsPermanent reports whether err was marked permanent
+func IsPermanent(err error) bool {
+	var p *PermanentError
+	return errors.As(err, &p)
+}
+
+// permanentStatus reports whether an HTTP status rejects the request
+// itself: client errors, except timeouts and rate limits
+func permanentStatus(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+// statusError returns the error of a request answered with status
+func statusError(name string, status int, body []byte) error {
+	err := fmt.Errorf("%s: unexpected status %d: %s", name, status, string(body))
+	if permanentStatus(status) {
+		return Permanent(err)
+	}
+	return err
 }
 
 // New creates a new notifier based on configuration
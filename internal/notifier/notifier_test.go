@@ -1,7 +1,10 @@
 package notifier
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -38,6 +41,127 @@ func TestLoginEventFormat(t *testing.T) {
 	}
 }
 
+func TestLocation(t *testing.T) {
+	tests := []struct {
+		fields map[string]string
+		want   string
+	}{
+		{nil, ""},
+		{map[string]string{FieldCountry: "CN", FieldCity: "Beijing", FieldASN: "4134", FieldASOrg: "CHINANET"}, "Beijing, CN · AS4134 CHINANET"},
+		{map[string]string{FieldCountry: "US"}, "US"},
+		{map[string]string{FieldASN: "13335"}, "AS13335"},
+	}
+	for _, tt := range tests {
+		event := LoginEvent{Hostname: "web1", IP: "203.0.113.5", Fields: tt.fields}
+		if got := event.Location(); got != tt.want {
+			t.Errorf("Expected location %q, got %q", tt.want, got)
+		}
+		if tt.want != "" {
+			msg := event.Format()
+			if !contains(msg, "IP: 203.0.113.5\nLocation: "+tt.want) || contains(msg, "ASN:") || contains(msg, "Country:") {
+				t.Errorf("Expected the location below the IP only, got:\n%s", msg)
+			}
+		}
+	}
+}
+
+func TestIntegrityEventFormat(t *testing.T) {
+	event := LoginEvent{
+		Kind:      KindIntegrity,
+		Hostname:  "testhost",
+		Timestamp: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
+		OS:        "linux",
+		Detail:    "Log file DELETED: /var/log/auth.log",
+		Fields:    map[string]string{FieldFile: "/var/log/auth.log", FieldChange: "deleted"},
+	}
+
+	msg := event.Format()
+
+	if !contains(msg, "Log Integrity Alert") {
+		t.Error("Format() should use the integrity title")
+	}
+	if !contains(msg, "Severity: critical") {
+		t.Error("Format() should contain the default severity for integrity events")
+	}
+	if !contains(msg, "File: /var/log/auth.log") {
+		t.Error("Format() should render structured fields")
+	}
+	if contains(msg, "IP:") || contains(msg, "User:") {
+		t.Error("Format() should not render empty IP or user")
+	}
+}
+
+func TestFillDefaults(t *testing.T) {
+	var event LoginEvent
+	event.FillDefaults()
+
+	if event.ID == "" {
+		t.Error("Expected an ID to be generated")
+	}
+	if event.Kind != KindLogin {
+		t.Errorf("Expected kind '%s', got '%s'", KindLogin, event.Kind)
+	}
+	if event.Severity != SeverityInfo {
+		t.Errorf("Expected severity '%s', got '%s'", SeverityInfo, event.Severity)
+	}
+
+	other := LoginEvent{Kind: KindBruteForce}
+	other.FillDefaults()
+	if other.ID == event.ID {
+		t.Error("Expected unique IDs")
+	}
+	if other.Severity != SeverityHigh {
+		t.Errorf("Expected severity '%s', got '%s'", SeverityHigh, other.Severity)
+	}
+	if !other.Severity.AtLeast(SeverityMedium) || other.Severity.AtLeast(SeverityCritical) {
+		t.Error("Unexpected severity ordering")
+	}
+}
+
+func TestConfigListsKindsAndSeverities(t *testing.T) {
+	if len(config.EventKinds) != len(kindInfo) {
+		t.Errorf("Expected %d kinds in config.EventKinds, got %d", len(kindInfo), len(config.EventKinds))
+	}
+	for _, k := range config.EventKinds {
+		if !EventKind(k).Valid() {
+			t.Errorf("Expected config.EventKinds entry %q to be a known kind", k)
+		}
+	}
+	if len(config.Severities) != len(severityLevels) {
+		t.Errorf("Expected %d severities in config.Severities, got %d", len(severityLevels), len(config.Severities))
+	}
+	for i, s := range config.Severities {
+		if got := Severity(s).Level(); got != i {
+			t.Errorf("Expected severity %q at level %d, got %d", s, i, got)
+		}
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		ip     string
+		wantIP string
+		valid  bool
+	}{
+		{"192.0.2.1", "192.0.2.1", true},
+		{"::ffff:192.0.2.1", "192.0.2.1", true},
+		{"[2001:db8::1]", "2001:db8::1", true},
+		{"bastion.example.com", "bastion.example.com", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		event := LoginEvent{IP: tt.ip}
+		event.ParseSource()
+		if event.IP != tt.wantIP {
+			t.Errorf("%q: expected IP '%s', got '%s'", tt.ip, tt.wantIP, event.IP)
+		}
+		if event.Addr.IsValid() != tt.valid {
+			t.Errorf("%q: expected valid address %v, got %v", tt.ip, tt.valid, event.Addr)
+		}
+	}
+}
+
 func TestWebhookNotifier(t *testing.T) {
 	// Create a test server
 	var receivedPayload map[string]interface{}
@@ -79,13 +203,138 @@ func TestWebhookNotifier(t *testing.T) {
 		OS:        "darwin",
 	}
 
-	if err := webhook.Send(event); err != nil {
+	if err := webhook.Send(context.Background(), event); err != nil {
 		t.Errorf("Send() failed: %v", err)
 	}
 
 	if receivedPayload["username"] != "testuser" {
 		t.Errorf("Expected username 'testuser', got '%v'", receivedPayload["username"])
 	}
+	if receivedPayload["event"] != "login" {
+		t.Errorf("Expected event 'login', got '%v'", receivedPayload["event"])
+	}
+	if receivedPayload["severity"] != "info" {
+		t.Errorf("Expected severity 'info', got '%v'", receivedPayload["severity"])
+	}
+
+	event = LoginEvent{
+		ID:        "abc123",
+		Kind:      KindIntegrity,
+		Hostname:  "testhost",
+		Timestamp: time.Now(),
+		Fields:    map[string]string{FieldFile: "/var/log/secure"},
+	}
+	if err := webhook.Send(context.Background(), event); err != nil {
+		t.Errorf("Send() failed: %v", err)
+	}
+	if receivedPayload["id"] != "abc123" {
+		t.Errorf("Expected id 'abc123', got '%v'", receivedPayload["id"])
+	}
+	if receivedPayload["ip"] != "" {
+		t.Errorf("Expected empty ip, got '%v'", receivedPayload["ip"])
+	}
+	fields, _ := receivedPayload["fields"].(map[string]interface{})
+	if fields[FieldFile] != "/var/log/secure" {
+		t.Errorf("Expected fields.file '/var/log/secure', got '%v'", fields[FieldFile])
+	}
+}
+
+func TestSendCancelled(t *testing.T) {
+	// Endpoints that accept the connection but never answer
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	webhook, err := NewWebhook(config.NotifierConfig{Config: map[string]string{"url": server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	email, err := NewEmail(config.NotifierConfig{Config: map[string]string{
+		"smtp_host": host,
+		"smtp_port": port,
+		"to":        "admin@example.com",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := LoginEvent{Username: "testuser", Hostname: "testhost", Timestamp: time.Now()}
+	for _, n := range []Notifier{webhook, email} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		err := n.Send(ctx, event)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("%s: expected deadline exceeded, got %v", n.Name(), err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: expected Send to return at the deadline, took %v", n.Name(), elapsed)
+		}
+	}
+}
+
+func TestPermanentErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusForbidden, true},
+		{http.StatusRequestEntityTooLarge, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusRequestTimeout, false},
+		{http.StatusBadGateway, false},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+		}))
+		webhook, err := NewWebhook(config.NotifierConfig{Config: map[string]string{"url": server.URL}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = webhook.Send(context.Background(), LoginEvent{Username: "testuser"})
+		server.Close()
+		if err == nil || IsPermanent(err) != tt.permanent {
+			t.Errorf("Status %d: expected permanent %v, got %v", tt.status, tt.permanent, err)
+		}
+	}
+}
+
+func TestHeaderText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Login: alice from 192.0.2.1", "Login: alice from 192.0.2.1"},
+		{"Login: alice\r\nBcc: victim@example.com", "Login: alice Bcc: victim@example.com"},
+		{"Login:\n\n alice", "Login: alice"},
+		{"Login: José", "=?utf-8?q?Login:_Jos=C3=A9?="},
+	}
+	for _, tt := range tests {
+		if got := headerText(tt.in); got != tt.want {
+			t.Errorf("headerText(%q): expected %q, got %q", tt.in, tt.want, got)
+		}
+	}
 }
 
 func TestNewNotifier(t *testing.T) {
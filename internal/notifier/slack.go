@@ -2,6 +2,7 @@ package notifier
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,9 +14,10 @@ import (
 
 // Slack implements Slack webhook notifications
 type Slack struct {
-	name    string
-	webhook string
-	client  *http.Client
+	name     string
+	webhook  string
+	client   *http.Client
+	messages *messageTemplates
 }
 
 // NewSlack creates a new Slack notifier
@@ -25,17 +27,18 @@ func NewSlack(cfg config.NotifierConfig) (*Slack, error) {
 		return nil, fmt.Errorf("slack: webhook is required")
 	}
 
-	name := cfg.Name
-	if name == "" {
-		name = "Slack"
+	messages, err := newMessageTemplates(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("slack: %w", err)
 	}
 
 	return &Slack{
-		name:    name,
+		name:    cfg.DisplayName(),
 		webhook: webhook,
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		messages: messages,
 	}, nil
 }
 
@@ -45,27 +48,13 @@ func (s *Slack) Name() string {
 }
 
 // Send sends a Slack notification
-func (s *Slack) Send(event LoginEvent) error {
+func (s *Slack) Send(ctx context.Context, event LoginEvent) error {
 	payload := map[string]interface{}{
-		"text": event.Format(),
-		"blocks": []map[string]interface{}{
-			{
-				"type": "header",
-				"text": map[string]string{
-					"type": "plain_text",
-					"text": "🔔 Login Alert",
-				},
-			},
-			{
-				"type": "section",
-				"fields": []map[string]string{
-					{"type": "mrkdwn", "text": fmt.Sprintf("*User:*\n%s", event.Username)},
-					{"type": "mrkdwn", "text": fmt.Sprintf("*Host:*\n%s", event.Hostname)},
-					{"type": "mrkdwn", "text": fmt.Sprintf("*Time:*\n%s", event.Timestamp.Format("2006-01-02 15:04:05"))},
-					{"type": "mrkdwn", "text": fmt.Sprintf("*OS:*\n%s", event.OS)},
-				},
-			},
-		},
+		"text": s.messages.Body(event),
+	}
+	// A custom message is sent as is, without the field blocks
+	if !s.messages.Custom() {
+		payload["blocks"] = slackBlocks(event)
 	}
 
 	jsonData, err := json.Marshal(payload)
@@ -73,7 +62,7 @@ func (s *Slack) Send(event LoginEvent) error {
 		return fmt.Errorf("slack: failed to marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", s.webhook, bytes.NewReader(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", s.webhook, bytes.NewReader(jsonData))
 	if err != nil {
 		return fmt.Errorf("slack: failed to create request: %w", err)
 	}
@@ -87,8 +76,71 @@ func (s *Slack) Send(event LoginEvent) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("slack: unexpected status %d: %s", resp.StatusCode, string(body))
+		return statusError("slack", resp.StatusCode, body)
 	}
 
 	return nil
 }
+
+// slackBlocks lays out event as a header and field sections
+func slackBlocks(event LoginEvent) []map[string]interface{} {
+	var fields []map[string]string
+	addField := func(label, value string) {
+		fields = append(fields, map[string]string{"type": "mrkdwn", "text": fmt.Sprintf("*%s:*\n%s", label, value)})
+	}
+	if event.Username != "" {
+		addField("User", event.Username)
+	}
+	addField("Host", event.Hostname)
+	addField("Time", event.Timestamp.Format("2006-01-02 15:04:05"))
+	if event.OS != "" {
+		addField("OS", event.OS)
+	}
+	if event.Source != "" {
+		addField("Source", event.Source)
+	}
+	if event.IP != "" {
+		addField("IP", event.IP)
+	}
+	if location := event.Location(); location != "" {
+		addField("Location", location)
+	}
+	if event.Terminal != "" {
+		addField("Terminal", event.Terminal)
+	}
+	if severity := event.EventSeverity(); severity != SeverityInfo {
+		addField("Severity", string(severity))
+	}
+	for _, k := range event.DisplayFieldKeys() {
+		addField(FieldLabel(k), event.Fields[k])
+	}
+
+	blocks := []map[string]interface{}{
+		{
+			"type": "header",
+			"text": map[string]string{
+				"type": "plain_text",
+				"text": event.Title(),
+			},
+		},
+	}
+	if event.Detail != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": event.Detail},
+		})
+	}
+	// Slack allows at most 10 fields per section
+	for len(fields) > 0 {
+		n := len(fields)
+		if n > 10 {
+			n = 10
+		}
+		blocks = append(blocks, map[string]interface{}{
+			"type":   "section",
+			"fields": fields[:n],
+		})
+		fields = fields[n:]
+	}
+	return blocks
+}
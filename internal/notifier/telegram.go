@@ -2,8 +2,10 @@ package notifier
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"html"
 	"io"
 	"net/http"
 	"time"
@@ -13,10 +15,11 @@ import (
 
 // Telegram implements Telegram bot notifications
 type Telegram struct {
-	name   string
-	token  string
-	chatID string
-	client *http.Client
+	name     string
+	token    string
+	chatID   string
+	client   *http.Client
+	messages *messageTemplates
 }
 
 // NewTelegram creates a new Telegram notifier
@@ -31,18 +34,19 @@ func NewTelegram(cfg config.NotifierConfig) (*Telegram, error) {
 		return nil, fmt.Errorf("telegram: chat_id is required")
 	}
 
-	name := cfg.Name
-	if name == "" {
-		name = "Telegram"
+	messages, err := newMessageTemplates(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("telegram: %w", err)
 	}
 
 	return &Telegram{
-		name:   name,
+		name:   cfg.DisplayName(),
 		token:  token,
 		chatID: chatID,
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		messages: messages,
 	}, nil
 }
 
@@ -52,12 +56,12 @@ func (t *Telegram) Name() string {
 }
 
 // Send sends a Telegram notification
-func (t *Telegram) Send(event LoginEvent) error {
+func (t *Telegram) Send(ctx context.Context, event LoginEvent) error {
 	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", t.token)
 
 	payload := map[string]interface{}{
 		"chat_id":    t.chatID,
-		"text":       event.Format(),
+		"text":       html.EscapeString(t.messages.Body(event)),
 		"parse_mode": "HTML",
 	}
 
@@ -66,7 +70,7 @@ func (t *Telegram) Send(event LoginEvent) error {
 		return fmt.Errorf("telegram: failed to marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonData))
 	if err != nil {
 		return fmt.Errorf("telegram: failed to create request: %w", err)
 	}
@@ -89,7 +93,11 @@ func (t *Telegram) Send(event LoginEvent) error {
 	}
 
 	if !result.OK {
-		return fmt.Errorf("telegram: %s", result.Description)
+		err := fmt.Errorf("telegram: %s", result.Description)
+		if permanentStatus(resp.StatusCode) {
+			return Permanent(err)
+		}
+		return err
 	}
 
 	return nil
@@ -0,0 +1,214 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"time"
+	_ "time/tzdata" // for tz on hosts without a zone database
+	"unicode/utf8"
+
+	"github.com/xsddz/whozere/internal/config"
+)
+
+// builtinTemplates can be selected by name in the template setting
+var builtinTemplates = map[string]string{
+	// default is the message of Format
+	"default": `{{.Title}}
+
+{{.Body}}`,
+
+	// compact fits the event on one line
+	"compact": `{{.Title}}: {{.Summary}}`,
+
+	// zh-CN is a Chinese message, for DingTalk, Feishu or WeCom groups
+	"zh-CN": `{{if eq .EventKind "login"}}🔔 登录提醒
+{{- else if eq .EventKind "logout"}}👋 退出登录
+{{- else if eq .EventKind "failed_auth"}}⚠️ 登录失败
+{{- else if eq .EventKind "brute_force"}}🚨 暴力破解告警
+{{- else if eq .EventKind "privilege_escalation"}}🔑 提权操作
+{{- else if eq .EventKind "integrity"}}🛡️ 日志完整性告警
+{{- else if eq .EventKind "agent_silent"}}📴 Agent 失联
+{{- else if eq .EventKind "digest"}}📋 事件汇总
+{{- else}}🔔 {{.EventKind.Title}}{{end}}
+
+{{with .Username}}用户: {{.}}
+{{end}}主机: {{.Hostname}}
+时间: {{.Timestamp.Format "2006-01-02 15:04:05 MST"}}
+{{with .IP}}IP: {{.}}
+{{end}}{{with .Location}}位置: {{.}}
+{{end}}{{with .Terminal}}终端: {{.}}
+{{end}}{{with .Source}}日志源: {{.}}
+{{end}}{{if ne .EventSeverity "info"}}级别: {{.EventSeverity}}
+{{end}}{{if ne .EventKind "digest"}}{{with .Detail}}详情: {{.}}
+{{end}}{{end}}{{range .DisplayFieldKeys}}{{label .}}: {{index $.Fields .}}
+{{end}}{{if eq .EventKind "digest"}}
+{{.Detail}}{{end}}`,
+}
+
+// templateFuncs are the helpers available to templates, besides the
+// text/template built-ins (printf, html, js, urlquery, ...)
+var templateFuncs = template.FuncMap{
+	// tz converts a time to a time zone: {{tz "Asia/Shanghai" .Timestamp}}
+	"tz": func(name string, t time.Time) (time.Time, error) {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return t, err
+		}
+		return t.In(loc), nil
+	},
+	// truncate shortens s to n characters, ending with "…" if cut
+	"truncate": func(n int, s string) string {
+		if utf8.RuneCountInString(s) <= n {
+			return s
+		}
+		if n <= 0 {
+			return ""
+		}
+		runes := []rune(s)
+		return string(runes[:n-1]) + "…"
+	},
+	// json encodes v as JSON: {{json .Username}} gives a quoted string
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	// default returns value, or def if value is empty: {{default "-" .IP}}
+	"default": func(def, value string) string {
+		if value == "" {
+			return def
+		}
+		return value
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	// label turns a field key into its display label
+	"label": FieldLabel,
+}
+
+// messageTemplates renders notifications from the template and
+// subject_template settings of a notifier
+// A nil *messageTemplates, or a missing template, falls back to the
+// built-in layout
+type messageTemplates struct {
+	body    *template.Template
+	subject *template.Template
+}
+
+// newMessageTemplates parses the templates of a notifier
+// Returns nil if it has none
+func newMessageTemplates(cfg config.NotifierConfig) (*messageTemplates, error) {
+	if cfg.Template == "" && cfg.SubjectTemplate == "" {
+		return nil, nil
+	}
+	if cfg.SubjectTemplate != "" && cfg.Type != "email" {
+		return nil, fmt.Errorf("subject_template is only supported by email")
+	}
+
+	var m messageTemplates
+	var err error
+	if m.body, err = parseTemplate("template", cfg.Template); err != nil {
+		return nil, err
+	}
+	if m.subject, err = parseTemplate("subject_template", cfg.SubjectTemplate); err != nil {
+		return nil, err
+	}
+
+	// Catch references to unknown fields and failing helpers now rather
+	// than when an alert is due
+	sample := sampleEvent()
+	for _, t := range []*template.Template{m.body, m.subject} {
+		if t == nil {
+			continue
+		}
+		if err := t.Execute(&bytes.Buffer{}, sample); err != nil {
+			return nil, fmt.Errorf("%s: %w", t.Name(), err)
+		}
+	}
+	return &m, nil
+}
+
+// parseTemplate parses text, or the built-in template it names
+// Returns nil for an empty text
+func parseTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	if builtin, ok := builtinTemplates[text]; ok {
+		text = builtin
+	} else if !strings.Contains(text, "{{") && !strings.Contains(text, "\n") {
+		return nil, fmt.Errorf("%s: unknown built-in template %q", name, text)
+	}
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// sampleEvent has every field set, to try templates on
+func sampleEvent() LoginEvent {
+	return LoginEvent{
+		ID:        "0123456789abcdef",
+		Kind:      KindPrivilegeEscalation,
+		Severity:  SeverityMedium,
+		Username:  "alice",
+		Hostname:  "web1",
+		IP:        "192.0.2.1",
+		Terminal:  "pts/0",
+		Timestamp: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
+		OS:        "linux",
+		Source:    "authlog",
+		Detail:    "sudo",
+		Fields: map[string]string{
+			FieldTargetUser: "root",
+			FieldCommand:    "/bin/bash",
+		},
+	}
+}
+
+// Body renders the message of event, or returns Format's
+func (m *messageTemplates) Body(event LoginEvent) string {
+	if m == nil || m.body == nil {
+		return event.Format()
+	}
+	return m.render(m.body, event, event.Format())
+}
+
+// Subject renders the subject of event, or returns def
+func (m *messageTemplates) Subject(event LoginEvent, def string) string {
+	if m == nil || m.subject == nil {
+		return def
+	}
+	// A subject is a single header line
+	return strings.Join(strings.Fields(m.render(m.subject, event, def)), " ")
+}
+
+// Custom reports whether the message is user-defined
+func (m *messageTemplates) Custom() bool {
+	return m != nil && m.body != nil
+}
+
+// render executes t, falling back to def so that an alert is never lost
+// to a template error
+func (m *messageTemplates) render(t *template.Template, event LoginEvent, def string) string {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, event); err != nil {
+		log.Printf("Failed to render %s, using the default message: %v", t.Name(), err)
+		return def
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// ValidateTemplates checks the templates of every notifier
+func ValidateTemplates(notifiers []config.NotifierConfig) error {
+	for i, nc := range notifiers {
+		if _, err := newMessageTemplates(nc); err != nil {
+			return fmt.Errorf("notifier[%d] (%s): %w", i, nc.Name, err)
+		}
+	}
+	return nil
+}
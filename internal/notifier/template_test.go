@@ -0,0 +1,108 @@
+package notifier
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+)
+
+func TestMessageTemplates(t *testing.T) {
+	event := LoginEvent{
+		Username:  "alice",
+		Hostname:  "web1",
+		IP:        "192.0.2.1",
+		Terminal:  "ssh",
+		Timestamp: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
+		Fields:    map[string]string{FieldMethod: "publickey"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"none", "", event.Format()},
+		{"built-in default", "default", event.Format()},
+		{"built-in compact", "compact", "🔔 Login Alert: alice logged in to web1"},
+		{"fields", "{{.Username}}@{{.Hostname}} via {{.Fields.method}}{{.Fields.missing}}", "alice@web1 via publickey"},
+		{"time zone", `{{(tz "Asia/Shanghai" .Timestamp).Format "15:04 MST"}}`, "18:30 CST"},
+		{"truncate", `{{truncate 4 .Hostname}}|{{truncate 3 "abc"}}|{{truncate 2 "登录提醒"}}`, "web1|abc|登…"},
+		{"json", `{"user": {{json .Username}}, "detail": {{json "say \"hi\""}}}`, `{"user": "alice", "detail": "say \"hi\""}`},
+		{"default", `{{default "-" .Source}} {{upper .Hostname}}`, "- WEB1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMessageTemplates(config.NotifierConfig{Type: "webhook", Template: tt.template})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Body(event); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestChineseTemplate(t *testing.T) {
+	m, err := newMessageTemplates(config.NotifierConfig{Type: "dingtalk", Template: "zh-CN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := m.Body(LoginEvent{
+		Kind:      KindFailedAuth,
+		Username:  "root",
+		Hostname:  "web1",
+		IP:        "192.0.2.1",
+		Timestamp: time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC),
+	})
+	for _, want := range []string{"⚠️ 登录失败\n\n", "用户: root\n", "IP: 192.0.2.1\n", "级别: low"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in message, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestSubjectTemplate(t *testing.T) {
+	m, err := newMessageTemplates(config.NotifierConfig{
+		Type:            "email",
+		SubjectTemplate: "[{{upper (print .EventSeverity)}}]\n{{.Summary}}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := LoginEvent{Username: "alice", Hostname: "web1"}
+	if got, want := m.Subject(event, "default"), "[INFO] alice logged in to web1"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	// Without a message template the default layout is kept
+	if m.Custom() || m.Body(event) != event.Format() {
+		t.Error("Expected the default message")
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.NotifierConfig
+		wantErr bool
+	}{
+		{"valid", config.NotifierConfig{Type: "wecom", Template: "{{.Summary}}"}, false},
+		{"syntax error", config.NotifierConfig{Type: "wecom", Template: "{{.Summary"}, true},
+		{"unknown field", config.NotifierConfig{Type: "wecom", Template: "{{.Nope}}"}, true},
+		{"unknown function", config.NotifierConfig{Type: "wecom", Template: "{{nope .Username}}"}, true},
+		{"unknown time zone", config.NotifierConfig{Type: "wecom", Template: `{{tz "Mars/Olympus" .Timestamp}}`}, true},
+		{"unknown built-in", config.NotifierConfig{Type: "wecom", Template: "zh-TW"}, true},
+		{"subject for email", config.NotifierConfig{Type: "email", SubjectTemplate: "{{.Summary}}"}, false},
+		{"subject for chat", config.NotifierConfig{Type: "slack", SubjectTemplate: "{{.Summary}}"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTemplates([]config.NotifierConfig{tt.cfg})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateTemplates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package notifier
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -19,6 +20,7 @@ type Webhook struct {
 	method      string
 	contentType string
 	client      *http.Client
+	messages    *messageTemplates
 }
 
 // NewWebhook creates a new Webhook notifier
@@ -41,19 +43,20 @@ func NewWebhook(cfg config.NotifierConfig) (*Webhook, error) {
 		contentType = "application/json"
 	}
 
-	name := cfg.Name
-	if name == "" {
-		name = "Webhook"
+	messages, err := newMessageTemplates(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("webhook: %w", err)
 	}
 
 	return &Webhook{
-		name:        name,
+		name:        cfg.DisplayName(),
 		url:         url,
 		method:      method,
 		contentType: contentType,
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		messages: messages,
 	}, nil
 }
 
@@ -63,16 +66,27 @@ func (w *Webhook) Name() string {
 }
 
 // Send sends a webhook notification
-func (w *Webhook) Send(event LoginEvent) error {
+func (w *Webhook) Send(ctx context.Context, event LoginEvent) error {
 	payload := map[string]interface{}{
-		"event":     "login",
+		"id":        event.ID,
+		"event":     string(event.EventKind()),
+		"severity":  string(event.EventSeverity()),
 		"username":  event.Username,
 		"hostname":  event.Hostname,
 		"ip":        event.IP,
 		"terminal":  event.Terminal,
 		"timestamp": event.Timestamp.Format(time.RFC3339),
 		"os":        event.OS,
-		"message":   event.Format(),
+		"message":   w.messages.Body(event),
+	}
+	if event.Source != "" {
+		payload["source"] = event.Source
+	}
+	if event.Detail != "" {
+		payload["detail"] = event.Detail
+	}
+	if len(event.Fields) > 0 {
+		payload["fields"] = event.Fields
 	}
 
 	jsonData, err := json.Marshal(payload)
@@ -82,9 +96,9 @@ func (w *Webhook) Send(event LoginEvent) error {
 
 	var req *http.Request
 	if w.method == "GET" {
-		req, err = http.NewRequest("GET", w.url, nil)
+		req, err = http.NewRequestWithContext(ctx, "GET", w.url, nil)
 	} else {
-		req, err = http.NewRequest("POST", w.url, bytes.NewReader(jsonData))
+		req, err = http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(jsonData))
 		req.Header.Set("Content-Type", w.contentType)
 	}
 	if err != nil {
@@ -101,7 +115,7 @@ func (w *Webhook) Send(event LoginEvent) error {
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("webhook: unexpected status %d: %s", resp.StatusCode, string(body))
+		return statusError("webhook", resp.StatusCode, body)
 	}
 
 	return nil
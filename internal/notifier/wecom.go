@@ -2,6 +2,7 @@ package notifier
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -13,9 +14,10 @@ import (
 
 // WeCom implements WeCom (企业微信) robot notifications
 type WeCom struct {
-	name    string
-	webhook string
-	client  *http.Client
+	name     string
+	webhook  string
+	client   *http.Client
+	messages *messageTemplates
 }
 
 // NewWeCom creates a new WeCom notifier
@@ -25,17 +27,18 @@ func NewWeCom(cfg config.NotifierConfig) (*WeCom, error) {
 		return nil, fmt.Errorf("wecom: webhook is required")
 	}
 
-	name := cfg.Name
-	if name == "" {
-		name = "WeCom"
+	messages, err := newMessageTemplates(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("wecom: %w", err)
 	}
 
 	return &WeCom{
-		name:    name,
+		name:    cfg.DisplayName(),
 		webhook: webhook,
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		messages: messages,
 	}, nil
 }
 
@@ -45,11 +48,11 @@ func (w *WeCom) Name() string {
 }
 
 // Send sends a WeCom notification
-func (w *WeCom) Send(event LoginEvent) error {
+func (w *WeCom) Send(ctx context.Context, event LoginEvent) error {
 	payload := map[string]interface{}{
 		"msgtype": "text",
 		"text": map[string]string{
-			"content": event.Format(),
+			"content": w.messages.Body(event),
 		},
 	}
 
@@ -58,7 +61,7 @@ func (w *WeCom) Send(event LoginEvent) error {
 		return fmt.Errorf("wecom: failed to marshal payload: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", w.webhook, bytes.NewReader(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", w.webhook, bytes.NewReader(jsonData))
 	if err != nil {
 		return fmt.Errorf("wecom: failed to create request: %w", err)
 	}
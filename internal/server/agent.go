@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+	"github.com/xsddz/whozere/internal/watcher"
+)
+
+// Agent queue and retry settings
+const (
+	agentQueueSize     = 1000 // events held while the server is unreachable
+	agentBatchSize     = 100  // events per request
+	agentRetryDelay    = time.Second
+	agentRetryMaxDelay = time.Minute
+)
+
+// Agent forwards events to a whozere server and reports in periodically
+// Events are queued in memory and resent until the server accepts them;
+// when the queue is full the oldest events are dropped
+type Agent struct {
+	name      string
+	url       string
+	token     string
+	version   string
+	heartbeat time.Duration
+	client    *http.Client
+	queue     chan notifier.LoginEvent
+
+	// Sources, if set, reports the state of local sources in heartbeats
+	Sources func() []watcher.SourceHealth
+
+	retryDelay time.Duration
+	done       chan struct{}         // closed when Run returns
+	unsent     []notifier.LoginEvent // batch interrupted by shutdown
+}
+
+// NewAgent creates an agent from configuration
+func NewAgent(cfg config.AgentConfig, version string) (*Agent, error) {
+	name := cfg.Name
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("agent: name is not set and hostname is unknown: %w", err)
+		}
+		name = hostname
+	}
+	heartbeat := cfg.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = config.DefaultHeartbeat
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("agent: failed to read CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("agent: no certificates in %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("agent: failed to load certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &Agent{
+		name:      name,
+		url:       strings.TrimSuffix(cfg.Server, "/"),
+		token:     cfg.Token,
+		version:   version,
+		heartbeat: heartbeat,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: transport,
+		},
+		queue:      make(chan notifier.LoginEvent, agentQueueSize),
+		retryDelay: agentRetryDelay,
+		done:       make(chan struct{}),
+	}, nil
+}
+
+// Name returns the agent name reported to the server
+func (a *Agent) Name() string {
+	return a.name
+}
+
+// Forward queues an event for the server without blocking
+func (a *Agent) Forward(event notifier.LoginEvent) {
+	for {
+		select {
+		case a.queue <- event:
+			return
+		default:
+		}
+		// Full: make room by dropping the oldest event
+		select {
+		case old := <-a.queue:
+			log.Printf("Agent queue full, dropping event %s: %s", old.ID, old.Summary())
+		default:
+		}
+	}
+}
+
+// Run sends queued events and heartbeats until ctx is cancelled
+func (a *Agent) Run(ctx context.Context) {
+	defer close(a.done)
+	ticker := time.NewTicker(a.heartbeat)
+	defer ticker.Stop()
+
+	a.sendHeartbeat(ctx)
+	for ctx.Err() == nil {
+		select {
+		case event := <-a.queue:
+			batch := []notifier.LoginEvent{event}
+			for len(batch) < agentBatchSize && len(a.queue) > 0 {
+				batch = append(batch, <-a.queue)
+			}
+			a.sendWithRetry(ctx, batch)
+		case <-ticker.C:
+			a.sendHeartbeat(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sendWithRetry sends batch until the server accepts it or ctx is cancelled
+func (a *Agent) sendWithRetry(ctx context.Context, batch []notifier.LoginEvent) {
+	delay := a.retryDelay
+	for {
+		err := a.Send(ctx, batch)
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			a.unsent = append(a.unsent, batch...)
+			return
+		}
+		log.Printf("Failed to forward %d events to server, retrying in %v: %v", len(batch), delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			a.unsent = append(a.unsent, batch...)
+			return
+		}
+		delay = min(delay*2, agentRetryMaxDelay)
+	}
+}
+
+// Flush sends the events still queued once Run has returned, giving up
+// when ctx is done or the server fails
+func (a *Agent) Flush(ctx context.Context) {
+	select {
+	case <-a.done:
+	case <-ctx.Done():
+		return
+	}
+
+	events := a.unsent
+	a.unsent = nil
+	for len(a.queue) > 0 {
+		events = append(events, <-a.queue)
+	}
+	for len(events) > 0 {
+		batch := events[:min(len(events), agentBatchSize)]
+		if err := a.Send(ctx, batch); err != nil {
+			log.Printf("Failed to forward %d events to server before shutdown: %v", len(events), err)
+			return
+		}
+		events = events[len(batch):]
+	}
+}
+
+// Send posts events to the server
+func (a *Agent) Send(ctx context.Context, events []notifier.LoginEvent) error {
+	return a.post(ctx, PathEvents, EventBatch{Agent: a.name, Events: events})
+}
+
+func (a *Agent) sendHeartbeat(ctx context.Context) {
+	hb := Heartbeat{Agent: a.name, Version: a.version, OS: runtime.GOOS}
+	if a.Sources != nil {
+		for _, h := range a.Sources() {
+			status := SourceStatus{Name: h.Name, State: string(h.State), Events: h.Events}
+			if h.Err != nil {
+				status.Error = h.Err.Error()
+			}
+			hb.Sources = append(hb.Sources, status)
+		}
+	}
+	if err := a.post(ctx, PathHeartbeat, hb); err != nil && ctx.Err() == nil {
+		log.Printf("Failed to send heartbeat to server: %v", err)
+	}
+}
+
+func (a *Agent) post(ctx context.Context, path string, body any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("agent: failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url+path, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("agent: failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("agent: request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("agent: server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+func TestAgentForwardsWithRetry(t *testing.T) {
+	events := make(chan notifier.LoginEvent, 10)
+	s := New(config.ServerConfig{Listen: ":0", Token: "secret"}, events)
+
+	// The first event request fails, as if the server were restarting
+	var failed atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == PathEvents && failed.CompareAndSwap(false, true) {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.Handler().ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	agent, err := NewAgent(config.AgentConfig{Server: ts.URL + "/", Name: "web1", Token: "secret"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.retryDelay = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agent.Forward(notifier.LoginEvent{ID: "e1", Username: "alice", Hostname: "web1", Timestamp: time.Now()})
+	go agent.Run(ctx)
+
+	select {
+	case event := <-events:
+		if event.ID != "e1" || event.Fields[notifier.FieldAgent] != "web1" {
+			t.Errorf("Unexpected event: %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the forwarded event")
+	}
+	if !failed.Load() {
+		t.Error("Expected the first attempt to fail")
+	}
+
+	agents := s.Agents()
+	if len(agents) != 1 || agents[0].Name != "web1" || agents[0].Version != "test" {
+		t.Errorf("Expected web1 known from its heartbeat, got %+v", agents)
+	}
+}
+
+func TestAgentQueueDropsOldest(t *testing.T) {
+	agent, err := NewAgent(config.AgentConfig{Server: "http://127.0.0.1:1", Name: "web1", Token: "secret"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range agentQueueSize + 5 {
+		agent.Forward(notifier.LoginEvent{ID: string(rune('a' + i%26)), Timestamp: time.Unix(int64(i), 0)})
+	}
+	if len(agent.queue) != agentQueueSize {
+		t.Fatalf("Expected a full queue of %d, got %d", agentQueueSize, len(agent.queue))
+	}
+	if oldest := <-agent.queue; oldest.Timestamp.Unix() != 5 {
+		t.Errorf("Expected the 5 oldest events dropped, got oldest %d", oldest.Timestamp.Unix())
+	}
+}
+
+func TestAgentFlushOnShutdown(t *testing.T) {
+	events := make(chan notifier.LoginEvent, 10)
+	s := New(config.ServerConfig{Listen: ":0", Token: "secret"}, events)
+
+	// Event requests fail until the agent is shutting down
+	var up, failed atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == PathEvents && !up.Load() {
+			failed.Store(true)
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		s.Handler().ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	agent, err := NewAgent(config.AgentConfig{Server: ts.URL, Name: "web1", Token: "secret"}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.retryDelay = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	agent.Forward(notifier.LoginEvent{ID: "e1", Username: "alice", Timestamp: time.Now()})
+	go agent.Run(ctx)
+	for !failed.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	agent.Forward(notifier.LoginEvent{ID: "e2", Username: "bob", Timestamp: time.Now()})
+	cancel()
+
+	up.Store(true)
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancelFlush()
+	agent.Flush(flushCtx)
+
+	for _, want := range []string{"e1", "e2"} {
+		select {
+		case event := <-events:
+			if event.ID != want {
+				t.Errorf("Expected %s, got %s", want, event.ID)
+			}
+		default:
+			t.Fatalf("Expected %s forwarded on flush", want)
+		}
+	}
+}
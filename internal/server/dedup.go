@@ -0,0 +1,96 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// dedupCache remembers recent events to drop duplicates: the same event
+// resent by an agent (same ID), or reported twice, e.g. by an agent and by
+// a syslog relay (same content)
+type dedupCache struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time // key -> when first seen
+	lastPrune time.Time
+}
+
+func newDedupCache(window time.Duration) *dedupCache {
+	return &dedupCache{
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// add records event and reports whether it is new
+func (c *dedupCache) add(event notifier.LoginEvent, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastPrune) > c.window/10 {
+		for k, t := range c.seen {
+			if now.Sub(t) > c.window {
+				delete(c.seen, k)
+			}
+		}
+		c.lastPrune = now
+	}
+
+	keys := dedupKeys(event)
+	for _, k := range keys {
+		if t, ok := c.seen[k]; ok && now.Sub(t) <= c.window {
+			return false
+		}
+	}
+	for _, k := range keys {
+		c.seen[k] = now
+	}
+	return true
+}
+
+// remove forgets event, so that it is accepted when sent again
+func (c *dedupCache) remove(event notifier.LoginEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range dedupKeys(event) {
+		delete(c.seen, k)
+	}
+}
+
+// dedupKeys returns the ID key, if any, and the content key of event
+// Fields that depend on the path an event took (agent, relay, source)
+// are left out of the content
+func dedupKeys(event notifier.LoginEvent) []string {
+	var keys []string
+	if event.ID != "" {
+		keys = append(keys, "id:"+event.ID)
+	}
+
+	var b strings.Builder
+	b.WriteString("content:")
+	for _, v := range []string{
+		string(event.EventKind()), event.Hostname, event.Username, event.IP, event.Terminal,
+		strconv.FormatInt(event.Timestamp.UnixNano(), 10), event.Detail,
+	} {
+		b.WriteString(v)
+		b.WriteByte(0)
+	}
+	names := make([]string, 0, len(event.Fields))
+	for k := range event.Fields {
+		if k != notifier.FieldAgent && k != notifier.FieldSender {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		b.WriteString(k + "=" + event.Fields[k])
+		b.WriteByte(0)
+	}
+	return append(keys, b.String())
+}
@@ -0,0 +1,60 @@
+// Package server implements server mode, where one whozere instance
+// receives events from remote agents and notifies for the whole fleet,
+// and the agent that forwards events to it
+package server
+
+import (
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// API paths, all authenticated by token or client certificate
+const (
+	PathEvents    = "/v1/events"    // POST EventBatch
+	PathHeartbeat = "/v1/heartbeat" // POST Heartbeat
+	PathAgents    = "/v1/agents"    // GET []AgentStatus
+)
+
+// maxRequestBody bounds the size of a request from an agent
+const maxRequestBody = 4 << 20
+
+// EventBatch is the body of an events request
+type EventBatch struct {
+	Agent  string                `json:"agent"`
+	Events []notifier.LoginEvent `json:"events"`
+}
+
+// BatchResult is the response to an events request
+type BatchResult struct {
+	Accepted   int `json:"accepted"`
+	Duplicates int `json:"duplicates"`
+}
+
+// Heartbeat is the body of a heartbeat request
+type Heartbeat struct {
+	Agent   string         `json:"agent"`
+	Version string         `json:"version,omitempty"`
+	OS      string         `json:"os,omitempty"`
+	Sources []SourceStatus `json:"sources,omitempty"`
+}
+
+// SourceStatus is the state of one log source of an agent
+type SourceStatus struct {
+	Name   string `json:"name"`
+	State  string `json:"state"`
+	Error  string `json:"error,omitempty"`
+	Events uint64 `json:"events"`
+}
+
+// AgentStatus describes an agent known to the server
+type AgentStatus struct {
+	Name     string         `json:"name"`
+	LastSeen time.Time      `json:"last_seen"` // zero if never seen
+	Silent   bool           `json:"silent"`
+	Address  string         `json:"address,omitempty"`
+	Version  string         `json:"version,omitempty"`
+	OS       string         `json:"os,omitempty"`
+	Events   uint64         `json:"events"`
+	Sources  []SourceStatus `json:"sources,omitempty"`
+}
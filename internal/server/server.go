@@ -0,0 +1,358 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// maxAgentName bounds agent names taken from requests
+const maxAgentName = 253
+
+// maxAgents bounds the agents tracked: with a shared token, any holder
+// can report under any name
+const maxAgents = 1024
+
+// Server receives events from agents and passes them on to the events
+// channel, dropping duplicates, and raises an alert for agents that go
+// silent
+type Server struct {
+	cfg         config.ServerConfig
+	events      chan<- notifier.LoginEvent
+	silentAfter time.Duration
+	dedup       *dedupCache
+
+	mu     sync.Mutex
+	agents map[string]*AgentStatus
+}
+
+// New creates a server delivering received events to events
+func New(cfg config.ServerConfig, events chan<- notifier.LoginEvent) *Server {
+	silentAfter := cfg.SilentAfter
+	if silentAfter <= 0 {
+		silentAfter = config.DefaultSilentAfter
+	}
+	window := cfg.DedupWindow
+	if window <= 0 {
+		window = config.DefaultDedupWindow
+	}
+
+	s := &Server{
+		cfg:         cfg,
+		events:      events,
+		silentAfter: silentAfter,
+		dedup:       newDedupCache(window),
+		agents:      make(map[string]*AgentStatus),
+	}
+	// Expected agents are tracked from startup
+	now := time.Now()
+	for _, name := range cfg.Agents {
+		s.agents[name] = &AgentStatus{Name: name, LastSeen: now}
+	}
+	return s
+}
+
+// Start listens on the configured address and serves the API until ctx
+// is cancelled; listen errors are returned right away
+func (s *Server) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("server: %w", err)
+	}
+	if s.cfg.CertFile != "" {
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			ln.Close()
+			return fmt.Errorf("server: %w", err)
+		}
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+
+	srv := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Server error: %v", err)
+		}
+	}()
+	context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	})
+
+	go s.watchSilence(ctx)
+	return nil
+}
+
+// tlsConfig loads the server certificate and the agent CAs for mTLS
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(s.cfg.CertFile, s.cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if s.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(s.cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", s.cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		// With a token as well, agents may use either
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		if s.cfg.Token != "" {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	return tlsConfig, nil
+}
+
+// Handler returns the HTTP handler of the agent API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+PathEvents, s.handleEvents)
+	mux.HandleFunc("POST "+PathHeartbeat, s.handleHeartbeat)
+	mux.HandleFunc("GET "+PathAgents, s.handleAgents)
+	return mux
+}
+
+// authenticate checks the client certificate or the bearer token
+// Returns the agent name from the certificate, if any
+func (s *Server) authenticate(r *http.Request) (certName string, ok bool) {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName, true
+	}
+	if s.cfg.Token == "" {
+		return "", false
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return "", found && subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) == 1
+}
+
+// agentName returns the name of the agent, or "" if it is missing or invalid
+// A certificate proves the name; with a shared token it is taken as claimed
+func agentName(certName, claimed string) string {
+	name := certName
+	if name == "" {
+		name = claimed
+	}
+	if len(name) > maxAgentName {
+		return ""
+	}
+	return name
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	certName, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var batch EventBatch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&batch); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	name := agentName(certName, batch.Agent)
+	if name == "" {
+		http.Error(w, "missing or invalid agent name", http.StatusBadRequest)
+		return
+	}
+	if !s.seen(name, r.RemoteAddr, func(st *AgentStatus) {
+		st.Events += uint64(len(batch.Events))
+	}) {
+		http.Error(w, "too many agents", http.StatusServiceUnavailable)
+		return
+	}
+
+	var result BatchResult
+	for _, event := range batch.Events {
+		if event.Hostname == "" {
+			event.Hostname = name
+		}
+		event.SetField(notifier.FieldAgent, name)
+		if !s.dedup.add(event, time.Now()) {
+			result.Duplicates++
+			continue
+		}
+		select {
+		case s.events <- event:
+			result.Accepted++
+		case <-r.Context().Done():
+			// The agent resends the batch: events already accepted are
+			// dropped as duplicates, this one is not
+			s.dedup.remove(event)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	certName, ok := s.authenticate(r)
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var hb Heartbeat
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&hb); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	name := agentName(certName, hb.Agent)
+	if name == "" {
+		http.Error(w, "missing or invalid agent name", http.StatusBadRequest)
+		return
+	}
+	if !s.seen(name, r.RemoteAddr, func(st *AgentStatus) {
+		st.Version = hb.Version
+		st.OS = hb.OS
+		st.Sources = hb.Sources
+	}) {
+		http.Error(w, "too many agents", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleAgents(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.authenticate(r); !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Agents())
+}
+
+// seen records that an agent reported in and applies update to its status
+// Returns false if the agent is new and too many agents are tracked
+func (s *Server) seen(name, addr string, update func(*AgentStatus)) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.agents[name]
+	if !ok {
+		if len(s.agents) >= maxAgents && !s.forgetSilent() {
+			log.Printf("Too many agents, rejecting %s (%s)", name, addr)
+			return false
+		}
+		a = &AgentStatus{Name: name}
+		s.agents[name] = a
+		log.Printf("Agent connected: %s (%s)", name, addr)
+	}
+	if a.Silent {
+		log.Printf("Agent %s is reporting again", name)
+	}
+	a.LastSeen = time.Now()
+	a.Silent = false
+	a.Address = addr
+	update(a)
+	return true
+}
+
+// forgetSilent drops the agent silent for longest, unless it is expected
+// Returns false if no agent could be dropped
+// Must be called with s.mu held
+func (s *Server) forgetSilent() bool {
+	var oldest *AgentStatus
+	for _, a := range s.agents {
+		if !a.Silent || slices.Contains(s.cfg.Agents, a.Name) {
+			continue
+		}
+		if oldest == nil || a.LastSeen.Before(oldest.LastSeen) {
+			oldest = a
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(s.agents, oldest.Name)
+	return true
+}
+
+// Agents returns the status of every known agent, sorted by name
+func (s *Server) Agents() []AgentStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]AgentStatus, 0, len(s.agents))
+	for _, a := range s.agents {
+		list = append(list, *a)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// watchSilence periodically raises alerts for silent agents
+func (s *Server) watchSilence(ctx context.Context) {
+	ticker := time.NewTicker(max(s.silentAfter/4, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, event := range s.checkSilence(now) {
+				select {
+				case s.events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// checkSilence returns an alert for each agent that went silent since
+// the last check
+func (s *Server) checkSilence(now time.Time) []notifier.LoginEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var alerts []notifier.LoginEvent
+	for name, a := range s.agents {
+		silence := now.Sub(a.LastSeen)
+		if a.Silent || silence < s.silentAfter {
+			continue
+		}
+		a.Silent = true
+		alerts = append(alerts, notifier.LoginEvent{
+			Kind:      notifier.KindAgentSilent,
+			Hostname:  name,
+			Timestamp: now,
+			Detail:    "No events or heartbeats since " + a.LastSeen.Format("2006-01-02 15:04:05"),
+			Fields: map[string]string{
+				notifier.FieldAgent:  name,
+				notifier.FieldSilent: silence.Round(time.Second).String(),
+			},
+		})
+	}
+	return alerts
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+func postJSON(t *testing.T, handler http.Handler, path, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServerEvents(t *testing.T) {
+	events := make(chan notifier.LoginEvent, 10)
+	s := New(config.ServerConfig{Listen: ":0", Token: "secret"}, events)
+	handler := s.Handler()
+
+	batch := EventBatch{
+		Agent: "web1",
+		Events: []notifier.LoginEvent{
+			{ID: "a1", Username: "alice", Timestamp: time.Unix(1760702400, 0)},
+			{ID: "a2", Kind: notifier.KindFailedAuth, Username: "root", Hostname: "web1.example.com", Timestamp: time.Unix(1760702401, 0)},
+		},
+	}
+
+	if rec := postJSON(t, handler, PathEvents, "wrong", batch); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong token, got %d", rec.Code)
+	}
+	if rec := postJSON(t, handler, PathEvents, "", batch); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a token, got %d", rec.Code)
+	}
+
+	rec := postJSON(t, handler, PathEvents, "secret", batch)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var result BatchResult
+	json.NewDecoder(rec.Body).Decode(&result)
+	if result.Accepted != 2 || result.Duplicates != 0 {
+		t.Errorf("Expected 2 accepted, got %+v", result)
+	}
+
+	first := <-events
+	if first.Hostname != "web1" || first.Fields[notifier.FieldAgent] != "web1" {
+		t.Errorf("Expected hostname and agent 'web1', got '%s' and '%s'", first.Hostname, first.Fields[notifier.FieldAgent])
+	}
+	if second := <-events; second.Hostname != "web1.example.com" {
+		t.Errorf("Expected hostname kept, got '%s'", second.Hostname)
+	}
+
+	// Resending the batch (e.g. after a timeout) delivers nothing twice
+	rec = postJSON(t, handler, PathEvents, "secret", batch)
+	json.NewDecoder(rec.Body).Decode(&result)
+	if result.Accepted != 0 || result.Duplicates != 2 {
+		t.Errorf("Expected 2 duplicates, got %+v", result)
+	}
+
+	// The same event relayed by another agent under another ID is a duplicate too
+	relayed := batch.Events[1]
+	relayed.ID = "b1"
+	relayed.SetField(notifier.FieldSender, "10.0.0.9")
+	rec = postJSON(t, handler, PathEvents, "secret", EventBatch{Agent: "relay", Events: []notifier.LoginEvent{relayed}})
+	json.NewDecoder(rec.Body).Decode(&result)
+	if result.Duplicates != 1 {
+		t.Errorf("Expected the relayed event to be a duplicate, got %+v", result)
+	}
+
+	if rec := postJSON(t, handler, PathEvents, "secret", EventBatch{Events: batch.Events}); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without an agent name, got %d", rec.Code)
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no more events, got %d", len(events))
+	}
+}
+
+func TestServerSilentAgents(t *testing.T) {
+	events := make(chan notifier.LoginEvent, 10)
+	s := New(config.ServerConfig{Listen: ":0", Token: "secret", SilentAfter: time.Minute, Agents: []string{"db1"}}, events)
+	handler := s.Handler()
+
+	rec := postJSON(t, handler, PathHeartbeat, "secret", Heartbeat{
+		Agent:   "web1",
+		Version: "1.2.3",
+		Sources: []SourceStatus{{Name: "auth", State: "running", Events: 3}},
+	})
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rec.Code)
+	}
+
+	if alerts := s.checkSilence(time.Now()); len(alerts) != 0 {
+		t.Errorf("Expected no silent agents yet, got %d", len(alerts))
+	}
+
+	// db1 never reported; web1 reported, but too long ago by then
+	alerts := s.checkSilence(time.Now().Add(2 * time.Minute))
+	if len(alerts) != 2 {
+		t.Fatalf("Expected 2 silent agents, got %d", len(alerts))
+	}
+	for _, alert := range alerts {
+		if alert.Kind != notifier.KindAgentSilent || alert.Fields[notifier.FieldSilent] == "" {
+			t.Errorf("Unexpected alert: %+v", alert)
+		}
+	}
+	if alerts := s.checkSilence(time.Now().Add(3 * time.Minute)); len(alerts) != 0 {
+		t.Errorf("Expected each silence to be reported once, got %d", len(alerts))
+	}
+
+	// Reporting again clears the silence
+	postJSON(t, handler, PathHeartbeat, "secret", Heartbeat{Agent: "db1"})
+	agents := s.Agents()
+	if len(agents) != 2 || agents[0].Name != "db1" || agents[0].Silent || !agents[1].Silent {
+		t.Errorf("Unexpected agents: %+v", agents)
+	}
+	if agents[1].Version != "1.2.3" || len(agents[1].Sources) != 1 {
+		t.Errorf("Expected heartbeat details for web1, got %+v", agents[1])
+	}
+
+	req := httptest.NewRequest(http.MethodGet, PathAgents, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	var listed []AgentStatus
+	if err := json.NewDecoder(resp.Body).Decode(&listed); err != nil || len(listed) != 2 {
+		t.Errorf("Expected 2 agents listed, got %v (%v)", listed, err)
+	}
+}
+
+func TestServerAgentLimit(t *testing.T) {
+	events := make(chan notifier.LoginEvent, 10)
+	s := New(config.ServerConfig{Listen: ":0", Token: "secret", SilentAfter: time.Minute, Agents: []string{"db1"}}, events)
+	handler := s.Handler()
+
+	for i := len(s.agents); i < maxAgents; i++ {
+		if rec := postJSON(t, handler, PathHeartbeat, "secret", Heartbeat{Agent: fmt.Sprintf("web%d", i)}); rec.Code != http.StatusNoContent {
+			t.Fatalf("Expected 204, got %d", rec.Code)
+		}
+	}
+	if rec := postJSON(t, handler, PathHeartbeat, "secret", Heartbeat{Agent: "extra"}); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 once the agent limit is reached, got %d", rec.Code)
+	}
+
+	// Silent agents make room, except expected ones
+	s.checkSilence(time.Now().Add(2 * time.Minute))
+	if rec := postJSON(t, handler, PathHeartbeat, "secret", Heartbeat{Agent: "extra"}); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 after agents went silent, got %d", rec.Code)
+	}
+	agents := s.Agents()
+	if len(agents) != maxAgents || !slices.ContainsFunc(agents, func(a AgentStatus) bool { return a.Name == "db1" }) {
+		t.Errorf("Expected %d agents including db1, got %d", maxAgents, len(agents))
+	}
+}
+
+func TestDedupCacheWindow(t *testing.T) {
+	c := newDedupCache(time.Minute)
+	now := time.Now()
+	event := notifier.LoginEvent{ID: "x", Username: "alice", Timestamp: now}
+
+	if !c.add(event, now) {
+		t.Error("Expected first event to be new")
+	}
+	if c.add(event, now.Add(30*time.Second)) {
+		t.Error("Expected duplicate within the window")
+	}
+	if !c.add(event, now.Add(2*time.Minute)) {
+		t.Error("Expected event to be new again after the window")
+	}
+
+	c.remove(event)
+	if !c.add(event, now.Add(2*time.Minute)) {
+		t.Error("Expected removed event to be accepted again")
+	}
+}
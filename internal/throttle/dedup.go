@@ -0,0 +1,99 @@
+package throttle
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// Dedup suppresses repeats of an event within a window after it was let
+// through; repeats are identified by the configured fields
+type Dedup struct {
+	window time.Duration
+	key    keyFunc
+
+	mu      sync.Mutex
+	windows map[string]*dedupWindow
+	ended   []notifier.LoginEvent // follow-ups of windows replaced by Allow
+}
+
+// dedupWindow tracks the repeats of one event
+type dedupWindow struct {
+	end time.Time
+	suppressed
+}
+
+// NewDedup creates a deduplicator from configuration
+// Returns nil if deduplication is disabled
+func NewDedup(cfg config.DedupConfig) (*Dedup, error) {
+	if cfg.Window <= 0 {
+		return nil, nil
+	}
+	fields := cfg.Fields
+	if len(fields) == 0 {
+		fields = config.DefaultDedupFields
+	}
+	key, err := newKeyFunc(fields)
+	if err != nil {
+		return nil, fmt.Errorf("dedup: %w", err)
+	}
+	return &Dedup{window: cfg.Window, key: key, windows: make(map[string]*dedupWindow)}, nil
+}
+
+// Allow reports whether event should be notified, or is a repeat
+func (d *Dedup) Allow(event notifier.LoginEvent, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := d.key(event)
+	if w, ok := d.windows[key]; ok {
+		if now.Before(w.end) {
+			w.add(event)
+			return false
+		}
+		if w.count > 0 {
+			d.ended = append(d.ended, w.followUp())
+		}
+	}
+	d.windows[key] = &dedupWindow{end: now.Add(d.window)}
+	return true
+}
+
+// FollowUps ends the windows that are over, returning a follow-up event
+// for each one that suppressed repeats
+func (d *Dedup) FollowUps(now time.Time) []notifier.LoginEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	events := d.ended
+	d.ended = nil
+	for key, w := range d.windows {
+		if now.Before(w.end) {
+			continue
+		}
+		if w.count > 0 {
+			events = append(events, w.followUp())
+		}
+		delete(d.windows, key)
+	}
+	return events
+}
+
+// Drain ends every window, returning the follow-ups still pending
+func (d *Dedup) Drain() []notifier.LoginEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	events := d.ended
+	d.ended = nil
+	for key, w := range d.windows {
+		if w.count > 0 {
+			events = append(events, w.followUp())
+		}
+		delete(d.windows, key)
+	}
+	return events
+}
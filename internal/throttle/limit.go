@@ -0,0 +1,116 @@
+package throttle
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// Limiter is a token-bucket rate limit, with one bucket per key
+type Limiter struct {
+	rate  float64 // tokens per second
+	burst float64
+	key   keyFunc
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket holds the tokens of one key
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	suppressed
+}
+
+// NewLimiter creates a rate limit from configuration
+// Returns nil if the limit is disabled
+func NewLimiter(cfg config.RateLimitConfig) (*Limiter, error) {
+	if cfg.Rate <= 0 {
+		return nil, nil
+	}
+	per := cfg.Per
+	if per <= 0 {
+		per = config.DefaultRateLimitPer
+	}
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = cfg.Rate
+	}
+	key, err := newKeyFunc(cfg.By)
+	if err != nil {
+		return nil, fmt.Errorf("rate_limit: %w", err)
+	}
+	return &Limiter{
+		rate:    float64(cfg.Rate) / per.Seconds(),
+		burst:   float64(burst),
+		key:     key,
+		buckets: make(map[string]*bucket),
+	}, nil
+}
+
+// Allow takes a token for event, reporting whether it may be sent
+func (l *Limiter) Allow(event notifier.LoginEvent, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := l.key(event)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+	if b.tokens < 1 {
+		b.add(event)
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// FollowUps returns a follow-up event for each bucket that suppressed
+// events and has a token again, which the follow-up takes
+// Full buckets with nothing suppressed are dropped
+func (l *Limiter) FollowUps(now time.Time) []notifier.LoginEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var events []notifier.LoginEvent
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		switch {
+		case b.count > 0 && b.tokens >= 1:
+			b.tokens--
+			events = append(events, b.followUp())
+		case b.count == 0 && b.tokens >= l.burst:
+			delete(l.buckets, key)
+		}
+	}
+	return events
+}
+
+// Drain returns the follow-ups still pending, ignoring the limit
+func (l *Limiter) Drain() []notifier.LoginEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var events []notifier.LoginEvent
+	for key, b := range l.buckets {
+		if b.count > 0 {
+			events = append(events, b.followUp())
+		}
+		delete(l.buckets, key)
+	}
+	return events
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = min(l.burst, b.tokens+elapsed.Seconds()*l.rate)
+		b.updated = now
+	}
+}
@@ -0,0 +1,78 @@
+// Package throttle suppresses repeated and excessive notifications, and
+// reports how many were suppressed in follow-up events
+package throttle
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// SweepInterval is how often FollowUps should be called
+const SweepInterval = time.Second
+
+// keyFunc returns the key of an event
+type keyFunc func(notifier.LoginEvent) string
+
+// fieldValues reads the key fields of an event (see config.KeyFields)
+var fieldValues = map[string]func(notifier.LoginEvent) string{
+	"kind":     func(e notifier.LoginEvent) string { return string(e.EventKind()) },
+	"severity": func(e notifier.LoginEvent) string { return string(e.EventSeverity()) },
+	"user":     func(e notifier.LoginEvent) string { return e.Username },
+	"host":     func(e notifier.LoginEvent) string { return e.Hostname },
+	"ip":       func(e notifier.LoginEvent) string { return e.IP },
+	"terminal": func(e notifier.LoginEvent) string { return e.Terminal },
+	"source":   func(e notifier.LoginEvent) string { return e.Source },
+	"os":       func(e notifier.LoginEvent) string { return e.OS },
+	"detail":   func(e notifier.LoginEvent) string { return e.Detail },
+}
+
+// newKeyFunc returns a function joining the given fields of an event
+func newKeyFunc(fields []string) (keyFunc, error) {
+	values := make([]func(notifier.LoginEvent) string, 0, len(fields))
+	for _, f := range fields {
+		if name, ok := strings.CutPrefix(f, "fields."); ok && name != "" {
+			values = append(values, func(e notifier.LoginEvent) string { return e.Fields[name] })
+			continue
+		}
+		value, ok := fieldValues[f]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", f)
+		}
+		values = append(values, value)
+	}
+	return func(e notifier.LoginEvent) string {
+		var b strings.Builder
+		for _, value := range values {
+			b.WriteString(value(e))
+			b.WriteByte(0)
+		}
+		return b.String()
+	}, nil
+}
+
+// suppressed counts the events left out under one key
+type suppressed struct {
+	count int
+	last  notifier.LoginEvent
+}
+
+func (s *suppressed) add(event notifier.LoginEvent) {
+	s.count++
+	s.last = event
+}
+
+// followUp returns a copy of the last suppressed event saying how many
+// were suppressed, and resets the count
+func (s *suppressed) followUp() notifier.LoginEvent {
+	e := s.last
+	e.ID = notifier.NewEventID()
+	e.Fields = maps.Clone(e.Fields)
+	e.SetField(notifier.FieldSuppressed, strconv.Itoa(s.count))
+	*s = suppressed{}
+	return e
+}
@@ -0,0 +1,160 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+var start = time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+func TestDedup(t *testing.T) {
+	d, err := NewDedup(config.DedupConfig{Window: 10 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	login := notifier.LoginEvent{Kind: notifier.KindLogin, Username: "alice", Hostname: "web1", IP: "192.0.2.1", Terminal: "ssh"}
+	session := login
+	session.Terminal = "pts/0"
+	other := login
+	other.Username = "bob"
+
+	steps := []struct {
+		event notifier.LoginEvent
+		at    time.Duration
+		want  bool
+	}{
+		{login, 0, true},
+		{session, time.Second, false}, // same kind, user, host and ip
+		{other, 2 * time.Second, true},
+		{login, 9 * time.Second, false},
+		{login, 10 * time.Second, true}, // the window is over
+	}
+	for i, step := range steps {
+		if got := d.Allow(step.event, start.Add(step.at)); got != step.want {
+			t.Errorf("step %d: Expected Allow() = %v, got %v", i, step.want, got)
+		}
+	}
+
+	// The window that ended at 10s suppressed two repeats; the new one
+	// is still open
+	got := d.FollowUps(start.Add(11 * time.Second))
+	if len(got) != 1 {
+		t.Fatalf("Expected 1 follow-up, got %d", len(got))
+	}
+	if n := got[0].Fields[notifier.FieldSuppressed]; n != "2" {
+		t.Errorf("Expected 2 suppressed, got %q", n)
+	}
+	if got[0].Username != "alice" || got[0].Title() != "🔕 2 similar events suppressed" {
+		t.Errorf("Unexpected follow-up %q for %s", got[0].Title(), got[0].Username)
+	}
+
+	d.Allow(login, start.Add(12*time.Second))
+	got = d.FollowUps(start.Add(20 * time.Second))
+	if len(got) != 1 || got[0].Fields[notifier.FieldSuppressed] != "1" {
+		t.Fatalf("Expected a follow-up for 1 event, got %v", got)
+	}
+	if got := d.FollowUps(start.Add(30 * time.Second)); len(got) != 0 {
+		t.Errorf("Expected follow-ups to be reported once, got %d", len(got))
+	}
+}
+
+func TestDedupFields(t *testing.T) {
+	d, err := NewDedup(config.DedupConfig{Window: time.Minute, Fields: []string{"user", "fields.method"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	password := notifier.LoginEvent{Username: "alice", Fields: map[string]string{notifier.FieldMethod: "password"}}
+	publickey := notifier.LoginEvent{Username: "alice", Fields: map[string]string{notifier.FieldMethod: "publickey"}}
+	if !d.Allow(password, start) || !d.Allow(publickey, start) {
+		t.Error("Expected different methods to be allowed")
+	}
+	if d.Allow(password, start.Add(time.Second)) {
+		t.Error("Expected a repeat to be suppressed")
+	}
+	if got := d.Drain(); len(got) != 1 || got[0].Fields[notifier.FieldSuppressed] != "1" {
+		t.Errorf("Expected the pending follow-up on drain, got %v", got)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	// 2 at once, then 1 every 30s, per user
+	l, err := NewLimiter(config.RateLimitConfig{Rate: 2, Per: time.Minute, By: []string{"user"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := notifier.LoginEvent{Username: "alice"}
+	bob := notifier.LoginEvent{Username: "bob"}
+
+	steps := []struct {
+		event notifier.LoginEvent
+		at    time.Duration
+		want  bool
+	}{
+		{alice, 0, true},
+		{alice, 0, true},
+		{alice, time.Second, false},
+		{alice, 2 * time.Second, false},
+		{bob, 2 * time.Second, true},
+		{alice, 30 * time.Second, true}, // refilled one token
+		{alice, 31 * time.Second, false},
+	}
+	for i, step := range steps {
+		if got := l.Allow(step.event, start.Add(step.at)); got != step.want {
+			t.Errorf("step %d: Expected Allow() = %v, got %v", i, step.want, got)
+		}
+	}
+
+	if got := l.FollowUps(start.Add(40 * time.Second)); len(got) != 0 {
+		t.Errorf("Expected no follow-up without a token, got %d", len(got))
+	}
+	got := l.FollowUps(start.Add(61 * time.Second))
+	if len(got) != 1 || got[0].Username != "alice" || got[0].Fields[notifier.FieldSuppressed] != "3" {
+		t.Fatalf("Expected a follow-up for 3 events of alice, got %v", got)
+	}
+	// The follow-up took the token
+	if l.Allow(alice, start.Add(61*time.Second)) {
+		t.Error("Expected the follow-up to take a token")
+	}
+	if got := l.Drain(); len(got) != 1 || got[0].Fields[notifier.FieldSuppressed] != "1" {
+		t.Errorf("Expected the pending follow-up on drain, got %v", got)
+	}
+}
+
+func TestLimiterBurst(t *testing.T) {
+	l, err := NewLimiter(config.RateLimitConfig{Rate: 1, Per: time.Hour, Burst: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := 0
+	for i := range 10 {
+		// One bucket for the notifier as a whole
+		if l.Allow(notifier.LoginEvent{Username: string(rune('a' + i))}, start) {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("Expected 3 events allowed, got %d", allowed)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	if d, err := NewDedup(config.DedupConfig{}); d != nil || err != nil {
+		t.Errorf("Expected no deduplicator, got %v, %v", d, err)
+	}
+	if l, err := NewLimiter(config.RateLimitConfig{Burst: 5}); l != nil || err != nil {
+		t.Errorf("Expected no limiter, got %v, %v", l, err)
+	}
+}
+
+func TestKeyFields(t *testing.T) {
+	// Every field accepted by the configuration has a value
+	if _, err := newKeyFunc(append(config.KeyFields, "fields.method")); err != nil {
+		t.Error(err)
+	}
+	if _, err := newKeyFunc([]string{"username"}); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+}
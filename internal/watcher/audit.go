@@ -0,0 +1,348 @@
+package watcher
+
+import (
+	"encoding/hex"
+	"os/user"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// Audit record types used for login detection
+const (
+	auditUserLogin = "USER_LOGIN" // login finished (sshd, login, gdm)
+	auditUserAuth  = "USER_AUTH"  // PAM authentication result
+	auditUserStart = "USER_START" // PAM session opened
+	auditCredAcq   = "CRED_ACQ"   // PAM credentials acquired
+	auditUserCmd   = "USER_CMD"   // command run through sudo
+	auditEOE       = "EOE"        // end of a multi-record kernel event
+)
+
+// auditUnsetID is the auid of processes that never logged in
+const auditUnsetID = "4294967295"
+
+// auditHeaderPattern matches "type=USER_LOGIN msg=audit(1700000000.123:456): "
+var auditHeaderPattern = regexp.MustCompile(`^(?:node=\S+\s+)?type=(\S+)\s+msg=audit\((\d+)\.(\d+):(\d+)\):\s*`)
+
+// auditEncodedFields may hold untrusted strings, which auditd logs in
+// quotes when safe and hex-encoded otherwise
+var auditEncodedFields = map[string]bool{
+	"acct": true, "cmd": true, "comm": true, "cwd": true, "data": true,
+	"exe": true, "name": true, "path": true, "proctitle": true,
+}
+
+// auditRecord is one line of the audit log
+type auditRecord struct {
+	Type   string
+	Time   time.Time
+	Serial uint64
+	Fields map[string]string
+}
+
+// parseAuditRecord parses a raw audit log line
+// The nested msg='...' of user-space records and the enriched fields
+// after the 0x1d separator are merged into Fields
+func parseAuditRecord(line string) (auditRecord, bool) {
+	m := auditHeaderPattern.FindStringSubmatch(line)
+	if m == nil {
+		return auditRecord{}, false
+	}
+	sec, _ := strconv.ParseInt(m[2], 10, 64)
+	msec, _ := strconv.ParseInt(m[3], 10, 64)
+	serial, _ := strconv.ParseUint(m[4], 10, 64)
+
+	rec := auditRecord{
+		Type:   m[1],
+		Time:   time.Unix(sec, msec*int64(time.Millisecond)),
+		Serial: serial,
+		Fields: make(map[string]string),
+	}
+	parseAuditFields(line[len(m[0]):], rec.Fields)
+	return rec, true
+}
+
+// parseAuditFields parses space-separated key=value pairs into fields
+// The first occurrence of a key wins
+func parseAuditFields(s string, fields map[string]string) {
+	for {
+		s = strings.TrimLeft(s, " \x1d")
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return
+		}
+		key := s[:eq]
+		if i := strings.IndexAny(key, " \x1d"); i >= 0 {
+			// Token without a value: skip it
+			s = s[i:]
+			continue
+		}
+		s = s[eq+1:]
+
+		var value string
+		switch {
+		case strings.HasPrefix(s, "'"):
+			// Nested user-space message: its fields belong to the record
+			end := strings.IndexByte(s[1:], '\'')
+			if end < 0 {
+				end = len(s) - 1
+			}
+			parseAuditFields(s[1:1+end], fields)
+			s = s[min(len(s), end+2):]
+			continue
+		case strings.HasPrefix(s, `"`):
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				end = len(s) - 1
+			}
+			value = s[1 : 1+end]
+			s = s[min(len(s), end+2):]
+		default:
+			end := strings.IndexAny(s, " \x1d")
+			if end < 0 {
+				end = len(s)
+			}
+			value = decodeAuditValue(key, s[:end])
+			s = s[end:]
+		}
+
+		if _, ok := fields[key]; !ok {
+			fields[key] = value
+		}
+	}
+}
+
+// decodeAuditValue decodes an unquoted value of a field that auditd
+// hex-encodes when it contains spaces or control characters
+func decodeAuditValue(key, value string) string {
+	if !auditEncodedFields[key] || len(value)%2 != 0 || value == "" {
+		return value
+	}
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return value
+	}
+	// proctitle separates arguments with NUL
+	return strings.ReplaceAll(string(decoded), "\x00", " ")
+}
+
+// auditParser turns audit records into events
+// Records sharing a serial form one event: user-space records are complete
+// on their own, kernel events end with EOE or when the serial changes
+// Not safe for concurrent use
+type auditParser struct {
+	hostname string
+	group    []auditRecord
+
+	// sudo logs the command (USER_CMD) before the session (USER_START)
+	commands  map[string]string // pid -> command
+	escalated map[string]bool   // pids already reported
+	users     map[string]string // uid -> name cache
+}
+
+func newAuditParser(hostname string) *auditParser {
+	return &auditParser{
+		hostname:  hostname,
+		commands:  make(map[string]string),
+		escalated: make(map[string]bool),
+		users:     make(map[string]string),
+	}
+}
+
+// parseLine adds a line and returns the events of any completed group
+func (p *auditParser) parseLine(line string) []notifier.LoginEvent {
+	rec, ok := parseAuditRecord(line)
+	if !ok {
+		return nil
+	}
+
+	var events []notifier.LoginEvent
+	if len(p.group) > 0 && p.group[0].Serial != rec.Serial {
+		events = append(events, p.finish()...)
+	}
+	p.group = append(p.group, rec)
+
+	if rec.Type == auditEOE || isUserAuditRecord(rec.Type) {
+		events = append(events, p.finish()...)
+	}
+	return events
+}
+
+// isUserAuditRecord reports whether t is a single-record user-space type
+func isUserAuditRecord(t string) bool {
+	return strings.HasPrefix(t, "USER_") || strings.HasPrefix(t, "CRED_")
+}
+
+// finish turns the current group into events and starts a new one
+func (p *auditParser) finish() []notifier.LoginEvent {
+	group := p.group
+	p.group = nil
+	if len(group) == 0 {
+		return nil
+	}
+
+	// Merge the group; the first record of a handled type drives the event
+	fields := make(map[string]string)
+	var primary *auditRecord
+	for i := range group {
+		for k, v := range group[i].Fields {
+			if _, ok := fields[k]; !ok {
+				fields[k] = v
+			}
+		}
+		if primary == nil && isHandledAuditType(group[i].Type) {
+			primary = &group[i]
+		}
+	}
+	if primary == nil {
+		return nil
+	}
+
+	if event := p.event(primary.Type, primary.Time, fields); event != nil {
+		return []notifier.LoginEvent{*event}
+	}
+	return nil
+}
+
+func isHandledAuditType(t string) bool {
+	switch t {
+	case auditUserLogin, auditUserAuth, auditUserStart, auditCredAcq, auditUserCmd:
+		return true
+	}
+	return false
+}
+
+// event builds an event from the merged fields of a record group
+func (p *auditParser) event(recordType string, timestamp time.Time, fields map[string]string) *notifier.LoginEvent {
+	res := fields["res"]
+	exe := fields["exe"]
+	pid := fields["pid"]
+	escalation := isEscalationExe(exe)
+
+	base := func(kind notifier.EventKind, username string) *notifier.LoginEvent {
+		event := &notifier.LoginEvent{
+			Kind:      kind,
+			Username:  username,
+			Hostname:  p.hostname,
+			IP:        auditValue(fields["addr"]),
+			Terminal:  strings.TrimPrefix(auditValue(fields["terminal"]), "/dev/"),
+			Timestamp: timestamp,
+			OS:        "linux",
+		}
+		if event.IP == "" {
+			event.IP = auditValue(fields["hostname"])
+		}
+		if auid := auditValue(fields["auid"]); auid != "" && auid != auditUnsetID {
+			event.SetField(notifier.FieldAUID, auid)
+		}
+		if res != "" {
+			event.SetField(notifier.FieldResult, res)
+		}
+		if pid != "" {
+			event.SetField(notifier.FieldPID, pid)
+		}
+		return event
+	}
+
+	switch recordType {
+	case auditUserLogin:
+		// Failures are reported by USER_AUTH, which carries the attempted account
+		if res != "success" {
+			return nil
+		}
+		return base(notifier.KindLogin, p.accountUser(fields))
+
+	case auditUserAuth:
+		if res != "failed" {
+			return nil
+		}
+		event := base(notifier.KindFailedAuth, p.accountUser(fields))
+		event.Detail = "Authentication failure"
+		if exe != "" {
+			event.Detail += " (" + exe + ")"
+		}
+		return event
+
+	case auditUserCmd:
+		if escalation && pid != "" {
+			if len(p.commands) >= maxTrackedSessions {
+				clear(p.commands)
+			}
+			p.commands[pid] = fields["cmd"]
+		}
+		return nil
+
+	case auditUserStart, auditCredAcq:
+		// Logins are reported by USER_LOGIN; only su/sudo sessions matter here
+		if !escalation || res != "success" || pid == "" || p.escalated[pid] {
+			return nil
+		}
+		if len(p.escalated) >= maxTrackedSessions {
+			clear(p.escalated)
+		}
+		p.escalated[pid] = true
+
+		event := base(notifier.KindPrivilegeEscalation, p.auidUser(fields))
+		event.SetField(notifier.FieldTargetUser, p.accountUser(fields))
+		if command, ok := p.commands[pid]; ok {
+			event.SetField(notifier.FieldCommand, command)
+			delete(p.commands, pid)
+		}
+		return event
+	}
+	return nil
+}
+
+// isEscalationExe reports whether exe is su or sudo
+func isEscalationExe(exe string) bool {
+	switch exe[strings.LastIndexByte(exe, '/')+1:] {
+	case "su", "sudo":
+		return true
+	}
+	return false
+}
+
+// auditValue maps the "unknown" markers auditd uses to ""
+func auditValue(v string) string {
+	if v == "?" || v == "(none)" || v == "(unknown)" {
+		return ""
+	}
+	return v
+}
+
+// accountUser returns the account a record is about
+func (p *auditParser) accountUser(fields map[string]string) string {
+	if acct := auditValue(fields["acct"]); acct != "" {
+		return acct
+	}
+	if id := fields["ID"]; id != "" {
+		return id
+	}
+	return p.lookupUID(fields["id"])
+}
+
+// auidUser returns the user who originally logged in (audit login uid)
+func (p *auditParser) auidUser(fields map[string]string) string {
+	if name := fields["AUID"]; name != "" && name != "unset" {
+		return name
+	}
+	return p.lookupUID(fields["auid"])
+}
+
+// lookupUID resolves a numeric uid to a user name, or returns it as is
+func (p *auditParser) lookupUID(uid string) string {
+	if uid == "" || uid == auditUnsetID {
+		return ""
+	}
+	if name, ok := p.users[uid]; ok {
+		return name
+	}
+	name := uid
+	if u, err := user.LookupId(uid); err == nil {
+		name = u.Username
+	}
+	p.users[uid] = name
+	return name
+}
@@ -0,0 +1,91 @@
+package watcher
+
+import (
+	"bufio"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+func TestParseAuditRecord(t *testing.T) {
+	line := "type=USER_AUTH msg=audit(1760702461.250:511): pid=2101 uid=0 auid=4294967295 msg='op=PAM:authentication acct=6A6F686E20646F65 exe=\"/usr/sbin/sshd\" addr=203.0.113.7 terminal=ssh res=failed'\x1dUID=\"root\" AUID=\"unset\""
+
+	rec, ok := parseAuditRecord(line)
+	if !ok {
+		t.Fatal("Expected record to parse")
+	}
+	if rec.Type != "USER_AUTH" || rec.Serial != 511 {
+		t.Errorf("Expected USER_AUTH serial 511, got %s serial %d", rec.Type, rec.Serial)
+	}
+	if !rec.Time.Equal(time.Unix(1760702461, 250*int64(time.Millisecond))) {
+		t.Errorf("Unexpected timestamp %v", rec.Time)
+	}
+
+	want := map[string]string{
+		"pid":  "2101",
+		"acct": "john doe", // hex-encoded
+		"exe":  "/usr/sbin/sshd",
+		"addr": "203.0.113.7",
+		"res":  "failed",
+		"UID":  "root", // enriched
+	}
+	for k, v := range want {
+		if rec.Fields[k] != v {
+			t.Errorf("Expected %s '%s', got '%s'", k, v, rec.Fields[k])
+		}
+	}
+
+	if _, ok := parseAuditRecord("Oct 17 12:00:01 host sshd[1]: not an audit record"); ok {
+		t.Error("Expected non-audit line to be rejected")
+	}
+}
+
+func TestParseAuditFixture(t *testing.T) {
+	f, err := os.Open("testdata/audit.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	parser := newAuditParser("testhost")
+	var got []notifier.LoginEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		got = append(got, parser.parseLine(scanner.Text())...)
+	}
+
+	want := []struct {
+		kind     notifier.EventKind
+		username string
+		ip       string
+		terminal string
+		fields   map[string]string
+	}{
+		{notifier.KindLogin, "alice", "192.0.2.10", "pts/1", map[string]string{notifier.FieldAUID: "1000", notifier.FieldResult: "success"}},
+		{notifier.KindFailedAuth, "root", "203.0.113.7", "ssh", map[string]string{notifier.FieldResult: "failed"}},
+		{notifier.KindFailedAuth, "john doe", "2001:db8::7", "ssh", nil},
+		{notifier.KindPrivilegeEscalation, "alice", "", "pts/1", map[string]string{notifier.FieldTargetUser: "root", notifier.FieldCommand: "apt update"}},
+		{notifier.KindPrivilegeEscalation, "bob", "", "pts/2", map[string]string{notifier.FieldTargetUser: "root"}},
+	}
+
+	if len(got) != len(want) {
+		for _, e := range got {
+			t.Logf("got %s %s %v", e.Kind, e.Username, e.Fields)
+		}
+		t.Fatalf("Expected %d events, got %d", len(want), len(got))
+	}
+	for i, w := range want {
+		e := got[i]
+		if e.Kind != w.kind || e.Username != w.username || e.IP != w.ip || e.Terminal != w.terminal {
+			t.Errorf("event[%d]: expected %s %s/%s/%s, got %s %s/%s/%s", i,
+				w.kind, w.username, w.ip, w.terminal, e.Kind, e.Username, e.IP, e.Terminal)
+		}
+		for k, v := range w.fields {
+			if e.Fields[k] != v {
+				t.Errorf("event[%d]: expected %s '%s', got '%s'", i, k, v, e.Fields[k])
+			}
+		}
+	}
+}
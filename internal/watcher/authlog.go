@@ -0,0 +1,71 @@
+package watcher
+
+import (
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// authLogParser turns auth log lines into events, remembering open
+// sessions so that logouts can be correlated with their logins, and sshd
+// connections so that failed attempts are reported once
+// Not safe for concurrent use
+type authLogParser struct {
+	hostname string
+	rules    *ruleSet
+	sessions *sessionTracker
+	failures *failureTracker
+}
+
+// newAuthLogParser creates a parser using rules, or the built-in rules if nil
+func newAuthLogParser(hostname string, rules *ruleSet) *authLogParser {
+	if rules == nil {
+		rules = defaultAuthLogRules
+	}
+	return &authLogParser{
+		hostname: hostname,
+		rules:    rules,
+		sessions: newSessionTracker(),
+		failures: newFailureTracker(),
+	}
+}
+
+// parseLine parses a line that starts with its own timestamp
+func (p *authLogParser) parseLine(line string) *notifier.LoginEvent {
+	return p.parse(line, lineTimestamp(line))
+}
+
+// parse extracts an event from a line logged at timestamp
+// Returns nil if the line is not a login, failure or session end, or
+// repeats a failure already reported
+func (p *authLogParser) parse(line string, timestamp time.Time) *notifier.LoginEvent {
+	if event := p.rules.match(line, p.hostname, "linux", timestamp); event != nil {
+		switch event.EventKind() {
+		case notifier.KindLogin:
+			p.sessions.opened(line, *event)
+		case notifier.KindFailedAuth:
+			if p.failures.duplicate(line, timestamp) {
+				return nil
+			}
+		}
+		return event
+	}
+	p.failures.handle(line)
+	return p.sessions.handle(line, timestamp)
+}
+
+// lineTimestamp returns the timestamp at the start of line, or now
+func lineTimestamp(line string) time.Time {
+	now := time.Now()
+	if timestamp, ok := parseLogTimestamp(line, now); ok {
+		return timestamp
+	}
+	return now
+}
+
+// parseAuthLogLine extracts an event from a single auth log line using
+// the built-in rules
+// Returns nil if the line does not describe a login or failed attempt
+func parseAuthLogLine(line, hostname string) *notifier.LoginEvent {
+	return defaultAuthLogRules.match(line, hostname, "linux", lineTimestamp(line))
+}
@@ -0,0 +1,339 @@
+package watcher
+
+import (
+	"testing"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+func TestParseAuthLogLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		kind     notifier.EventKind
+		username string
+		ip       string
+		terminal string
+		isNil    bool
+	}{
+		{
+			name:     "ssh accepted",
+			line:     "Oct 17 12:00:01 host sshd[123]: Accepted password for alice from 192.168.1.10 port 52814 ssh2",
+			username: "alice",
+			ip:       "192.168.1.10",
+			terminal: "ssh",
+		},
+		{
+			name:     "ssh accepted dotted username",
+			line:     "Oct 17 12:00:01 host sshd-session[123]: Accepted publickey for john.doe from 10.0.0.5 port 40100 ssh2: ED25519 SHA256:abc",
+			username: "john.doe",
+			ip:       "10.0.0.5",
+			terminal: "ssh",
+		},
+		{
+			name:     "ssh accepted ipv6",
+			line:     "Oct 17 12:00:01 host sshd[123]: Accepted publickey for alice from 2001:db8::10 port 52814 ssh2",
+			username: "alice",
+			ip:       "2001:db8::10",
+			terminal: "ssh",
+		},
+		{
+			name:     "ssh accepted ipv4-mapped",
+			line:     "Oct 17 12:00:01 host sshd[123]: Accepted password for alice from ::ffff:192.0.2.44 port 52814 ssh2",
+			username: "alice",
+			ip:       "192.0.2.44",
+			terminal: "ssh",
+		},
+		{
+			name:     "ssh accepted hostname",
+			line:     "Oct 17 12:00:01 host sshd[123]: Accepted password for alice from bastion.example.com port 52814 ssh2",
+			username: "alice",
+			ip:       "bastion.example.com",
+			terminal: "ssh",
+		},
+		{
+			name:     "pam session login",
+			line:     "Oct 17 12:00:01 host login[456]: pam_unix(login:session): session opened for user bob(uid=1000) by LOGIN(uid=0)",
+			username: "bob",
+			terminal: "login",
+		},
+		{
+			name:  "pam session su is ignored",
+			line:  "Oct 17 12:00:01 host su[456]: pam_unix(su:session): session opened for user root(uid=0) by alice(uid=1000)",
+			isNil: true,
+		},
+		{
+			name:  "pam session sudo is ignored",
+			line:  "Oct 17 12:00:01 host sudo[456]: pam_unix(sudo:session): session opened for user root(uid=0) by alice(uid=1000)",
+			isNil: true,
+		},
+		{
+			name:  "pam session sshd is ignored",
+			line:  "Oct 17 12:00:01 host sshd[123]: pam_unix(sshd:session): session opened for user alice(uid=1000) by (uid=0)",
+			isNil: true,
+		},
+		{
+			name:     "tty login",
+			line:     "Oct 17 12:00:01 host login[789]: LOGIN ON tty1 BY bob",
+			username: "bob",
+			terminal: "tty1",
+		},
+		{
+			name:     "ssh failed password",
+			line:     "Oct 17 12:00:01 host sshd[123]: Failed password for root from 203.0.113.7 port 40022 ssh2",
+			kind:     notifier.KindFailedAuth,
+			username: "root",
+			ip:       "203.0.113.7",
+			terminal: "ssh",
+		},
+		{
+			name:     "ssh failed password invalid user",
+			line:     "Oct 17 12:00:01 host sshd[123]: Failed password for invalid user svc-deploy from 203.0.113.7 port 40022 ssh2",
+			kind:     notifier.KindFailedAuth,
+			username: "svc-deploy",
+			ip:       "203.0.113.7",
+			terminal: "ssh",
+		},
+		{
+			name:     "ssh invalid user",
+			line:     "Oct 17 12:00:01 host sshd[123]: Invalid user admin from 203.0.113.7 port 40022",
+			kind:     notifier.KindFailedAuth,
+			username: "admin",
+			ip:       "203.0.113.7",
+			terminal: "ssh",
+		},
+		{
+			name:     "ssh max auth attempts",
+			line:     "Oct 17 12:00:01 host sshd[123]: error: maximum authentication attempts exceeded for root from 203.0.113.7 port 40022 ssh2 [preauth]",
+			kind:     notifier.KindFailedAuth,
+			username: "root",
+			ip:       "203.0.113.7",
+			terminal: "ssh",
+		},
+		{
+			name:     "pam auth failure su",
+			line:     "Oct 17 12:00:01 host su[456]: pam_unix(su:auth): authentication failure; logname=alice uid=1000 euid=0 tty=pts/0 ruser=alice rhost=  user=root",
+			kind:     notifier.KindFailedAuth,
+			username: "root",
+			terminal: "su",
+		},
+		{
+			name:  "pam auth failure sshd is ignored",
+			line:  "Oct 17 12:00:01 host sshd[123]: pam_unix(sshd:auth): authentication failure; logname= uid=0 euid=0 tty=ssh ruser= rhost=203.0.113.7  user=root",
+			isNil: true,
+		},
+		{
+			name:     "tty failed login",
+			line:     "Oct 17 12:00:01 host login[789]: FAILED LOGIN (1) on '/dev/tty1' FOR 'bob', Authentication failure",
+			kind:     notifier.KindFailedAuth,
+			username: "bob",
+			terminal: "tty1",
+		},
+		{
+			name:  "unrelated",
+			line:  "Oct 17 12:00:01 host CRON[1]: (root) CMD (run-parts /etc/cron.hourly)",
+			isNil: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := parseAuthLogLine(tt.line, "testhost")
+			if tt.isNil {
+				if event != nil {
+					t.Fatalf("Expected nil, got %+v", event)
+				}
+				return
+			}
+			if event == nil {
+				t.Fatal("Expected an event, got nil")
+			}
+			if event.EventKind() != kindOrLogin(tt.kind) {
+				t.Errorf("Expected kind '%s', got '%s'", kindOrLogin(tt.kind), event.EventKind())
+			}
+			if event.Username != tt.username {
+				t.Errorf("Expected username '%s', got '%s'", tt.username, event.Username)
+			}
+			if event.IP != tt.ip {
+				t.Errorf("Expected IP '%s', got '%s'", tt.ip, event.IP)
+			}
+			if event.Terminal != tt.terminal {
+				t.Errorf("Expected terminal '%s', got '%s'", tt.terminal, event.Terminal)
+			}
+		})
+	}
+}
+
+func TestParseAuthLogSource(t *testing.T) {
+	tests := []struct {
+		line string
+		addr string
+		port string
+	}{
+		{"sshd[1]: Accepted password for a from 192.0.2.1 port 22 ssh2", "192.0.2.1", "22"},
+		{"sshd[1]: Accepted password for a from 2001:DB8::1 port 2222 ssh2", "2001:db8::1", "2222"},
+		{"sshd[1]: Accepted password for a from ::ffff:10.1.2.3 port 40000 ssh2", "10.1.2.3", "40000"},
+		{"sshd[1]: Accepted password for a from fe80::1%eth0 port 40000 ssh2", "fe80::1%eth0", "40000"},
+		{"sshd[1]: Accepted password for a from host.example.com port 40000 ssh2", "", "40000"},
+	}
+
+	for _, tt := range tests {
+		event := parseAuthLogLine(tt.line, "testhost")
+		if event == nil {
+			t.Fatalf("Expected an event for %q", tt.line)
+		}
+		if tt.addr == "" {
+			if event.Addr.IsValid() {
+				t.Errorf("Expected no address for hostname source, got %v", event.Addr)
+			}
+		} else if event.Addr.String() != tt.addr {
+			t.Errorf("Expected address %s, got %v", tt.addr, event.Addr)
+		}
+		if event.Addr.Is4In6() {
+			t.Errorf("Expected IPv4-mapped address to be unmapped, got %v", event.Addr)
+		}
+		if event.Fields[notifier.FieldPort] != tt.port {
+			t.Errorf("Expected port %s, got '%s'", tt.port, event.Fields[notifier.FieldPort])
+		}
+	}
+}
+
+func TestParseAuthLogKeyInfo(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		fields map[string]string
+	}{
+		{
+			name: "password",
+			line: "sshd[1]: Accepted password for alice from 192.0.2.1 port 22 ssh2",
+			fields: map[string]string{
+				notifier.FieldMethod: "password",
+			},
+		},
+		{
+			name: "keyboard-interactive",
+			line: "sshd[1]: Accepted keyboard-interactive/pam for alice from 192.0.2.1 port 22 ssh2",
+			fields: map[string]string{
+				notifier.FieldMethod: "keyboard-interactive/pam",
+			},
+		},
+		{
+			name: "public key",
+			line: "sshd[1]: Accepted publickey for alice from 192.0.2.1 port 22 ssh2: ED25519 SHA256:Zm9vYmFy",
+			fields: map[string]string{
+				notifier.FieldMethod:      "publickey",
+				notifier.FieldKeyType:     "ED25519",
+				notifier.FieldFingerprint: "SHA256:Zm9vYmFy",
+			},
+		},
+		{
+			name: "certificate",
+			line: "sshd[1]: Accepted publickey for deploy from 192.0.2.1 port 22 ssh2: ED25519-CERT SHA256:Y2VydA ID ci@example.com (serial 4711) CA RSA SHA256:Y2FrZXk",
+			fields: map[string]string{
+				notifier.FieldMethod:        "publickey",
+				notifier.FieldKeyType:       "ED25519-CERT",
+				notifier.FieldFingerprint:   "SHA256:Y2VydA",
+				notifier.FieldCertID:        "ci@example.com",
+				notifier.FieldCertSerial:    "4711",
+				notifier.FieldCAFingerprint: "SHA256:Y2FrZXk",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := parseAuthLogLine(tt.line, "testhost")
+			if event == nil {
+				t.Fatal("Expected an event, got nil")
+			}
+			for k, want := range tt.fields {
+				if got := event.Fields[k]; got != want {
+					t.Errorf("Expected %s '%s', got '%s'", k, want, got)
+				}
+			}
+			if _, ok := tt.fields[notifier.FieldFingerprint]; !ok && event.Fields[notifier.FieldFingerprint] != "" {
+				t.Errorf("Expected no fingerprint, got '%s'", event.Fields[notifier.FieldFingerprint])
+			}
+		})
+	}
+}
+
+func TestParsePrivilegeEscalation(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		user     string
+		target   string
+		terminal string
+		command  string
+		severity notifier.Severity
+	}{
+		{
+			name:     "sudo",
+			line:     "Oct 17 12:00:01 host sudo[900]:    alice : TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/usr/bin/apt update",
+			user:     "alice",
+			target:   "root",
+			terminal: "pts/0",
+			command:  "/usr/bin/apt update",
+		},
+		{
+			name:     "sudo with env",
+			line:     "Oct 17 12:00:01 host sudo[900]: svc-deploy : TTY=unknown ; PWD=/ ; USER=postgres ; ENV=PGDATA=/srv ; COMMAND=/usr/bin/psql",
+			user:     "svc-deploy",
+			target:   "postgres",
+			terminal: "unknown",
+			command:  "/usr/bin/psql",
+		},
+		{
+			name:     "sudo denied",
+			line:     "Oct 17 12:00:01 host sudo[900]:      bob : user NOT in sudoers ; TTY=pts/1 ; PWD=/home/bob ; USER=root ; COMMAND=/bin/bash",
+			user:     "bob",
+			target:   "root",
+			terminal: "pts/1",
+			command:  "/bin/bash",
+			severity: notifier.SeverityHigh,
+		},
+		{
+			name:     "su",
+			line:     "Oct 17 12:00:01 host su[901]: (to root) alice on pts/0",
+			user:     "alice",
+			target:   "root",
+			terminal: "pts/0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := parseAuthLogLine(tt.line, "testhost")
+			if event == nil {
+				t.Fatal("Expected an event, got nil")
+			}
+			if event.Kind != notifier.KindPrivilegeEscalation {
+				t.Errorf("Expected kind '%s', got '%s'", notifier.KindPrivilegeEscalation, event.Kind)
+			}
+			if event.Username != tt.user {
+				t.Errorf("Expected user '%s', got '%s'", tt.user, event.Username)
+			}
+			if event.Fields[notifier.FieldTargetUser] != tt.target {
+				t.Errorf("Expected target '%s', got '%s'", tt.target, event.Fields[notifier.FieldTargetUser])
+			}
+			if event.Terminal != tt.terminal {
+				t.Errorf("Expected terminal '%s', got '%s'", tt.terminal, event.Terminal)
+			}
+			if event.Fields[notifier.FieldCommand] != tt.command {
+				t.Errorf("Expected command '%s', got '%s'", tt.command, event.Fields[notifier.FieldCommand])
+			}
+			if event.Severity != tt.severity {
+				t.Errorf("Expected severity '%s', got '%s'", tt.severity, event.Severity)
+			}
+		})
+	}
+}
+
+func kindOrLogin(kind notifier.EventKind) notifier.EventKind {
+	if kind == "" {
+		return notifier.KindLogin
+	}
+	return kind
+}
@@ -0,0 +1,188 @@
+package watcher
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checkpointSaveInterval bounds how often progress without events is
+// written to disk; lines that produce events are saved immediately
+const checkpointSaveInterval = 10 * time.Second
+
+// Checkpoint records how far a source has been read
+type Checkpoint struct {
+	// Inode identifies the file the offset belongs to
+	Inode uint64 `json:"inode,omitempty"`
+	// Offset is the byte position just after the last consumed line/record
+	Offset int64 `json:"offset,omitempty"`
+	// LastHash is the SHA-256 of the last consumed line/record, used to
+	// check that the file was not rewritten in place while we were down
+	LastHash string `json:"last_hash,omitempty"`
+	// Cursor is the journal cursor of the last consumed entry
+	Cursor string `json:"cursor,omitempty"`
+	// Updated is when the checkpoint was last written
+	Updated time.Time `json:"updated"`
+}
+
+// CheckpointStore persists read positions per source across restarts,
+// so that events that occur while whozere is down are delivered exactly once
+// Safe for concurrent use
+type CheckpointStore struct {
+	path string
+
+	mu      sync.Mutex
+	sources map[string]Checkpoint
+	dirty   bool
+	saved   time.Time
+}
+
+// OpenCheckpointStore loads the state file at path
+// A missing file yields an empty store; the file is written once up front
+// so an unwritable location is reported at startup rather than lost later
+func OpenCheckpointStore(path string) (*CheckpointStore, error) {
+	s := &CheckpointStore{
+		path:    path,
+		sources: make(map[string]Checkpoint),
+		dirty:   true,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("checkpoint: failed to read state file: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.sources); err != nil {
+			return nil, fmt.Errorf("checkpoint: failed to parse state file: %w", err)
+		}
+	}
+
+	if err := s.Save(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the checkpoint for source
+func (s *CheckpointStore) Get(source string) (Checkpoint, bool) {
+	if s == nil {
+		return Checkpoint{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, ok := s.sources[source]
+	return cp, ok
+}
+
+// Update records progress for source and writes the state file if flush
+// is set or the last write is older than checkpointSaveInterval
+func (s *CheckpointStore) Update(source string, cp Checkpoint, flush bool) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cp.Updated = time.Now()
+	s.sources[source] = cp
+	s.dirty = true
+
+	if flush || time.Since(s.saved) >= checkpointSaveInterval {
+		return s.saveLocked()
+	}
+	return nil
+}
+
+// Save writes pending changes to the state file
+func (s *CheckpointStore) Save() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveLocked()
+}
+
+func (s *CheckpointStore) saveLocked() error {
+	if !s.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.sources, "", "  ")
+	if err != nil {
+		return fmt.Errorf("checkpoint: failed to marshal state: %w", err)
+	}
+
+	// Write atomically so a crash never leaves a half-written file
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("checkpoint: failed to create state directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("checkpoint: failed to write state file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("checkpoint: failed to replace state file: %w", err)
+	}
+
+	s.dirty = false
+	s.saved = time.Now()
+	return nil
+}
+
+// hashBytes returns the hex SHA-256 of b
+func hashBytes(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// lastLineBefore returns the line that ends at offset in file (without its
+// trailing newline)
+func lastLineBefore(file io.ReaderAt, offset int64) ([]byte, error) {
+	if offset <= 0 {
+		return nil, nil
+	}
+
+	// Lines longer than this are compared by their tail only
+	const maxLine = 64 * 1024
+	start := offset - maxLine
+	if start < 0 {
+		start = 0
+	}
+
+	buf := make([]byte, offset-start)
+	if _, err := file.ReadAt(buf, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	// Trim the same way the tailer does, so blank lines compare equal
+	buf = bytes.TrimSuffix(buf, []byte("\n"))
+	if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+		buf = buf[i+1:]
+	}
+	return bytes.TrimRight(buf, "\r\n"), nil
+}
+
+// checkpointKey returns the checkpoint key of a watcher reading what
+func (o Options) checkpointKey(what string) string {
+	if o.Source == "" {
+		return what
+	}
+	return o.Source + "/" + what
+}
+
+// getCheckpoint returns the checkpoint of a watcher reading what
+// Checkpoints saved before keys carried the source name are still found
+func (o Options) getCheckpoint(what string) (Checkpoint, bool) {
+	if cp, ok := o.Checkpoints.Get(o.checkpointKey(what)); ok || o.Source == "" {
+		return cp, ok
+	}
+	return o.Checkpoints.Get(what)
+}
@@ -0,0 +1,214 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "state.json")
+
+	store, err := OpenCheckpointStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Expected state file to be created at open: %v", err)
+	}
+
+	want := Checkpoint{Inode: 42, Offset: 1234, LastHash: "abc"}
+	if err := store.Update("linux:/var/log/auth.log", want, true); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+
+	reopened, err := OpenCheckpointStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen store: %v", err)
+	}
+	got, ok := reopened.Get("linux:/var/log/auth.log")
+	if !ok {
+		t.Fatal("Expected checkpoint to survive reopen")
+	}
+	if got.Inode != want.Inode || got.Offset != want.Offset || got.LastHash != want.LastHash {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestNilCheckpointStore(t *testing.T) {
+	var store *CheckpointStore
+	if _, ok := store.Get("x"); ok {
+		t.Error("Expected nil store to have no checkpoints")
+	}
+	if err := store.Update("x", Checkpoint{}, true); err != nil {
+		t.Errorf("Expected nil store update to be a no-op, got %v", err)
+	}
+}
+
+func TestCheckpointKeys(t *testing.T) {
+	store, err := OpenCheckpointStore(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Saved by a version without source names in keys
+	store.Update("journal", Checkpoint{Cursor: "old"}, false)
+
+	sshd := Options{Checkpoints: store, Source: "sshd"}
+	cron := Options{Checkpoints: store, Source: "cron"}
+	if cp, ok := sshd.getCheckpoint("journal"); !ok || cp.Cursor != "old" {
+		t.Errorf("Expected the checkpoint without source name, got %+v", cp)
+	}
+	store.Update(sshd.checkpointKey("journal"), Checkpoint{Cursor: "s1"}, false)
+	store.Update(cron.checkpointKey("journal"), Checkpoint{Cursor: "c1"}, false)
+	if cp, _ := sshd.getCheckpoint("journal"); cp.Cursor != "s1" {
+		t.Errorf("Expected the sshd cursor, got %q", cp.Cursor)
+	}
+	if cp, _ := cron.getCheckpoint("journal"); cp.Cursor != "c1" {
+		t.Errorf("Expected the cron cursor, got %q", cp.Cursor)
+	}
+	if key := (Options{}).checkpointKey("journal"); key != "journal" {
+		t.Errorf("Expected an unchanged key without source, got %q", key)
+	}
+}
+
+func TestLastLineBefore(t *testing.T) {
+	data := "first\nsecond\r\n\nlast"
+	tests := []struct {
+		offset int64
+		want   string
+	}{
+		{0, ""},
+		{int64(len("first\n")), "first"},
+		{int64(len("first\nsecond\r\n")), "second"},
+		{int64(len("first\nsecond\r\n\n")), ""},
+		{int64(len(data)), "last"},
+	}
+
+	for _, tt := range tests {
+		got, err := lastLineBefore(strings.NewReader(data), tt.offset)
+		if err != nil {
+			t.Fatalf("offset %d: unexpected error: %v", tt.offset, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("offset %d: expected %q, got %q", tt.offset, tt.want, got)
+		}
+	}
+}
+
+// readTo consumes the file with a tailer and returns its checkpoint
+func readTo(t *testing.T, path string) Checkpoint {
+	t.Helper()
+	tail := newTailer(path)
+	if err := tail.open(0); err != nil {
+		t.Fatal(err)
+	}
+	defer tail.close()
+	tail.drain(false, func(string) {})
+	return tail.checkpoint()
+}
+
+// resumeLines resumes a tailer from cp and returns the lines it delivers
+func resumeLines(t *testing.T, path string, cp Checkpoint, n int) ([]string, bool) {
+	t.Helper()
+	tail := newTailer(path)
+	tail.forcePoll = true
+	if !tail.resume(cp) {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var c lineCollector
+	done := make(chan struct{})
+	go func() {
+		tail.follow(ctx, c.add)
+		close(done)
+	}()
+	lines := c.waitFor(t, n)
+	cancel()
+	<-done
+	return lines, true
+}
+
+func TestTailerResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.log")
+	appendFile(t, path, "seen one\nseen two\n")
+	cp := readTo(t, path)
+
+	// Written while we were down
+	appendFile(t, path, "missed one\nmissed two\n")
+
+	lines, ok := resumeLines(t, path, cp, 2)
+	if !ok {
+		t.Fatal("Expected tailer to resume from checkpoint")
+	}
+	want := []string{"missed one", "missed two"}
+	for i, w := range want {
+		if lines[i] != w {
+			t.Errorf("line[%d] = %q, want %q", i, lines[i], w)
+		}
+	}
+	if len(lines) != len(want) {
+		t.Errorf("Expected %d lines, got %q", len(want), lines)
+	}
+}
+
+func TestTailerResumeFromEnd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.log")
+	appendFile(t, path, "old one\nold two\n")
+
+	// Opened at the end and stopped before reading a line
+	tail := newTailer(path)
+	if err := tail.open(-1); err != nil {
+		t.Fatal(err)
+	}
+	cp := tail.checkpoint()
+	tail.close()
+
+	appendFile(t, path, "missed\n")
+	lines, ok := resumeLines(t, path, cp, 1)
+	if !ok {
+		t.Fatal("Expected tailer to resume from a checkpoint taken at the end")
+	}
+	if len(lines) != 1 || lines[0] != "missed" {
+		t.Errorf("Expected only the missed line, got %q", lines)
+	}
+}
+
+func TestTailerResumeAcrossRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.log")
+	appendFile(t, path, "seen\n")
+	cp := readTo(t, path)
+
+	// Written, rotated and written again while we were down
+	appendFile(t, path, "before rotation\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "after rotation\n")
+
+	lines, ok := resumeLines(t, path, cp, 2)
+	if !ok {
+		t.Fatal("Expected tailer to resume from the rotated file")
+	}
+	if lines[0] != "before rotation" || lines[1] != "after rotation" {
+		t.Errorf("Expected lines from both files in order, got %q", lines)
+	}
+}
+
+func TestTailerResumeRejectsRewrittenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.log")
+	appendFile(t, path, "seen one\nseen two\n")
+	cp := readTo(t, path)
+
+	// Same inode, different content at the checkpoint
+	if err := os.WriteFile(path, []byte("other one\nother two\nmore\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tail := newTailer(path)
+	if tail.resume(cp) {
+		t.Error("Expected resume to fail for a file rewritten in place")
+	}
+}
@@ -0,0 +1,266 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// A failed source is restarted after sourceRestartDelay, doubling on each
+// consecutive failure up to sourceRestartMaxDelay
+const (
+	sourceRestartDelay    = 5 * time.Second
+	sourceRestartMaxDelay = 5 * time.Minute
+)
+
+// Source is a named watcher with its own detection rules
+type Source struct {
+	Name    string
+	Watcher Watcher
+	// Rules are tried before Options.Rules; a rule of the same name
+	// replaces the global one for this source
+	Rules []config.RuleConfig
+}
+
+// SourceState describes whether a source is working
+type SourceState string
+
+const (
+	SourceStarting SourceState = "starting"
+	SourceRunning  SourceState = "running"
+	SourceFailed   SourceState = "failed" // stopped with an error, restart pending
+	SourceStopped  SourceState = "stopped"
+)
+
+// SourceHealth is a snapshot of the state of one source
+type SourceHealth struct {
+	Name      string
+	Watcher   string // watcher type (e.g. linux, journal)
+	State     SourceState
+	Err       error     // why the source last failed
+	Changed   time.Time // when State last changed
+	Events    uint64    // events reported since startup
+	LastEvent time.Time // when the last event arrived, zero if none yet
+	Restarts  int
+}
+
+// String returns a one-line description for logs
+func (h SourceHealth) String() string {
+	s := fmt.Sprintf("%s (%s): %s since %s, %d events", h.Name, h.Watcher, h.State, h.Changed.Format("2006-01-02 15:04:05"), h.Events)
+	if !h.LastEvent.IsZero() {
+		s += ", last at " + h.LastEvent.Format("2006-01-02 15:04:05")
+	}
+	if h.Restarts > 0 {
+		s += fmt.Sprintf(", %d restarts", h.Restarts)
+	}
+	if h.Err != nil {
+		s += ": " + h.Err.Error()
+	}
+	return s
+}
+
+// Composite runs several sources concurrently into one events channel
+// Events are stamped with the name of their source, and a source that
+// fails is restarted with backoff without affecting the others
+type Composite struct {
+	sources []Source
+
+	// OnStateChange, if set, is called whenever a source changes state
+	// It must not block
+	OnStateChange func(SourceHealth)
+
+	restartDelay time.Duration
+	mu           sync.Mutex
+	health       []SourceHealth
+}
+
+// NewComposite creates a watcher running all sources
+func NewComposite(sources []Source) *Composite {
+	c := &Composite{
+		sources:      sources,
+		restartDelay: sourceRestartDelay,
+		health:       make([]SourceHealth, len(sources)),
+	}
+	now := time.Now()
+	for i, src := range sources {
+		c.health[i] = SourceHealth{
+			Name:    src.Name,
+			Watcher: src.Watcher.Name(),
+			State:   SourceStarting,
+			Changed: now,
+		}
+	}
+	return c
+}
+
+// NewFromSources creates a composite watcher from source configuration
+func NewFromSources(cfgs []config.SourceConfig) (*Composite, error) {
+	sources := make([]Source, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		name := cfg.SourceName()
+		if err := ValidateRules(cfg.Rules); err != nil {
+			return nil, fmt.Errorf("source %s: %w", name, err)
+		}
+		w, err := NewFromConfig(cfg.WatcherConfig)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", name, err)
+		}
+		sources = append(sources, Source{Name: name, Watcher: w, Rules: cfg.Rules})
+	}
+	return NewComposite(sources), nil
+}
+
+// Name returns the watcher name, listing the sources
+func (c *Composite) Name() string {
+	names := make([]string, len(c.sources))
+	for i, src := range c.sources {
+		names[i] = src.Name
+	}
+	return "sources(" + strings.Join(names, ", ") + ")"
+}
+
+// LogFiles returns the log files of all sources, for integrity monitoring
+func (c *Composite) LogFiles() []string {
+	seen := make(map[string]bool)
+	var files []string
+	for _, src := range c.sources {
+		for _, f := range LogFiles(src.Watcher) {
+			if !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+	return files
+}
+
+// Health returns the current state of every source
+func (c *Composite) Health() []SourceHealth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]SourceHealth(nil), c.health...)
+}
+
+// Watch runs all sources (new events only)
+func (c *Composite) Watch(ctx context.Context, events chan<- notifier.LoginEvent) error {
+	return c.WatchWithOptions(ctx, events, Options{})
+}
+
+// WatchWithOptions runs all sources until ctx is cancelled
+func (c *Composite) WatchWithOptions(ctx context.Context, events chan<- notifier.LoginEvent, opts Options) error {
+	var wg sync.WaitGroup
+	for i := range c.sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.run(ctx, i, events, opts)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// run watches source i, restarting it whenever it stops before ctx ends
+func (c *Composite) run(ctx context.Context, i int, events chan<- notifier.LoginEvent, opts Options) {
+	src := c.sources[i]
+	opts.Rules = mergeRules(src.Rules, opts.Rules)
+	opts.Source = src.Name
+
+	// Stamp and count events on their way to the shared channel
+	ch := make(chan notifier.LoginEvent)
+	go func() {
+		for {
+			select {
+			case event := <-ch:
+				event.Source = src.Name
+				c.observe(i)
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	delay := c.restartDelay
+	for {
+		c.setState(i, SourceRunning, nil)
+		started := time.Now()
+		err := src.Watcher.WatchWithOptions(ctx, ch, opts)
+		if ctx.Err() != nil {
+			c.setState(i, SourceStopped, nil)
+			return
+		}
+		if err == nil {
+			err = errors.New("stopped unexpectedly")
+		}
+		c.setState(i, SourceFailed, err)
+
+		// A source that ran for a while starts over with the shortest delay
+		if time.Since(started) > sourceRestartMaxDelay {
+			delay = c.restartDelay
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			c.setState(i, SourceStopped, err)
+			return
+		}
+		delay = min(delay*2, sourceRestartMaxDelay)
+
+		c.mu.Lock()
+		c.health[i].Restarts++
+		c.mu.Unlock()
+		// History was read by the first run; checkpoints cover the gap
+		opts.Since = 0
+	}
+}
+
+func (c *Composite) observe(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.health[i].Events++
+	c.health[i].LastEvent = time.Now()
+}
+
+func (c *Composite) setState(i int, state SourceState, err error) {
+	c.mu.Lock()
+	h := &c.health[i]
+	h.State = state
+	h.Err = err
+	h.Changed = time.Now()
+	snapshot := *h
+	c.mu.Unlock()
+
+	if c.OnStateChange != nil {
+		c.OnStateChange(snapshot)
+	}
+}
+
+// mergeRules returns the source rules followed by the global rules they
+// do not replace
+func mergeRules(source, global []config.RuleConfig) []config.RuleConfig {
+	if len(source) == 0 {
+		return global
+	}
+	names := make(map[string]bool, len(source))
+	for _, r := range source {
+		names[r.Name] = true
+	}
+	merged := append([]config.RuleConfig(nil), source...)
+	for _, r := range global {
+		if !names[r.Name] {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
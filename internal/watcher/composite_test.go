@@ -0,0 +1,152 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// fakeWatcher sends its events, then fails or waits for cancellation
+type fakeWatcher struct {
+	name   string
+	events []notifier.LoginEvent
+	fail   int // number of runs that return an error
+	runs   atomic.Int32
+	source atomic.Value // Options.Source of the last run
+}
+
+func (w *fakeWatcher) Name() string { return w.name }
+
+func (w *fakeWatcher) Watch(ctx context.Context, events chan<- notifier.LoginEvent) error {
+	return w.WatchWithOptions(ctx, events, Options{})
+}
+
+func (w *fakeWatcher) WatchWithOptions(ctx context.Context, events chan<- notifier.LoginEvent, opts Options) error {
+	run := int(w.runs.Add(1))
+	w.source.Store(opts.Source)
+	if run <= w.fail {
+		return errors.New("boom")
+	}
+	for _, event := range w.events {
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func TestCompositeStampsSources(t *testing.T) {
+	auth := &fakeWatcher{name: "linux", events: []notifier.LoginEvent{{Username: "alice"}}}
+	app := &fakeWatcher{name: "file", events: []notifier.LoginEvent{{Username: "bob"}, {Username: "carol"}}}
+	c := NewComposite([]Source{
+		{Name: "auth", Watcher: auth},
+		{Name: "app", Watcher: app},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan notifier.LoginEvent)
+	done := make(chan struct{})
+	go func() {
+		c.WatchWithOptions(ctx, events, Options{})
+		close(done)
+	}()
+
+	got := make(map[string]string)
+	for range 3 {
+		select {
+		case event := <-events:
+			got[event.Username] = event.Source
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for events")
+		}
+	}
+	if auth.source.Load() != "auth" || app.source.Load() != "app" {
+		t.Errorf("Expected sources to be passed to watchers, got %v, %v", auth.source.Load(), app.source.Load())
+	}
+	want := map[string]string{"alice": "auth", "bob": "app", "carol": "app"}
+	for user, source := range want {
+		if got[user] != source {
+			t.Errorf("Expected %s from source '%s', got '%s'", user, source, got[user])
+		}
+	}
+
+	health := c.Health()
+	if health[0].State != SourceRunning || health[1].Events != 2 {
+		t.Errorf("Unexpected health: %v", health)
+	}
+
+	cancel()
+	<-done
+	for _, h := range c.Health() {
+		if h.State != SourceStopped {
+			t.Errorf("Expected %s stopped, got %s", h.Name, h.State)
+		}
+	}
+}
+
+func TestCompositeRestartsFailedSource(t *testing.T) {
+	flaky := &fakeWatcher{name: "journal", fail: 2, events: []notifier.LoginEvent{{Username: "alice"}}}
+	c := NewComposite([]Source{{Name: "journal", Watcher: flaky}})
+	c.restartDelay = time.Millisecond
+
+	var failures atomic.Int32
+	c.OnStateChange = func(h SourceHealth) {
+		if h.State == SourceFailed {
+			failures.Add(1)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan notifier.LoginEvent)
+	go c.WatchWithOptions(ctx, events, Options{Since: time.Hour})
+
+	select {
+	case event := <-events:
+		if event.Source != "journal" {
+			t.Errorf("Expected source 'journal', got '%s'", event.Source)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the restarted source")
+	}
+
+	h := c.Health()[0]
+	if h.Restarts != 2 || failures.Load() != 2 {
+		t.Errorf("Expected 2 restarts and failures, got %d and %d", h.Restarts, failures.Load())
+	}
+	if h.State != SourceRunning || h.Err != nil {
+		t.Errorf("Expected running without error, got %s (%v)", h.State, h.Err)
+	}
+}
+
+func TestMergeRules(t *testing.T) {
+	global := []config.RuleConfig{{Name: "vpn"}, {Name: "app"}}
+	source := []config.RuleConfig{{Name: "app", Pattern: "source"}, {Name: "extra"}}
+
+	merged := mergeRules(source, global)
+	var names []string
+	for _, r := range merged {
+		names = append(names, r.Name)
+	}
+	want := []string{"app", "extra", "vpn"}
+	if len(names) != len(want) {
+		t.Fatalf("Expected rules %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("Expected rules %v, got %v", want, names)
+			break
+		}
+	}
+	if merged[0].Pattern != "source" {
+		t.Error("Expected the source rule to replace the global one")
+	}
+}
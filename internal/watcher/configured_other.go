@@ -0,0 +1,15 @@
+//go:build !linux
+
+package watcher
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/xsddz/whozere/internal/config"
+)
+
+// newConfiguredWatcher is unavailable here: only auto detection is supported
+func newConfiguredWatcher(cfg config.WatcherConfig) (Watcher, error) {
+	return nil, fmt.Errorf("watcher: type %q is not supported on %s", cfg.Type, runtime.GOOS)
+}
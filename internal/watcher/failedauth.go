@@ -0,0 +1,100 @@
+package watcher
+
+import (
+	"regexp"
+	"time"
+)
+
+// sshd lines that report or follow a failed authentication attempt
+var (
+	// "Invalid user xxx from IP port N", logged before the first attempt
+	sshInvalidUserPattern = regexp.MustCompile(`sshd(?:-session)?\[(\d+)\]:\s+Invalid user\s`)
+	// "Failed password for [invalid user ]xxx from IP port N ssh2"
+	sshFailedPattern = regexp.MustCompile(`sshd(?:-session)?\[(\d+)\]:\s+Failed\s+\S+\s+for\s+(invalid user\s+)?`)
+	// "maximum authentication attempts exceeded for xxx ...", logged after
+	// the attempt that used up MaxAuthTries
+	sshMaxAuthPattern = regexp.MustCompile(`sshd(?:-session)?\[(\d+)\]:\s+(?:error: )?maximum authentication attempts exceeded`)
+	// "Connection closed by ...", "Disconnected from ..." or "Disconnecting ..."
+	sshClosedPattern = regexp.MustCompile(`sshd(?:-session)?\[(\d+)\]:\s+(?:Connection closed by|Disconnected from|Disconnecting)\s`)
+)
+
+// sshConnection is what has been reported for one sshd process
+type sshConnection struct {
+	pid         string
+	invalidUser bool // "Invalid user" reported, no attempt yet
+	failed      bool // an attempt was reported
+	last        time.Time
+}
+
+// failureTracker correlates sshd lines by process ID so that each failed
+// attempt is reported once: sshd logs "Invalid user" before the "Failed
+// ... for invalid user" line of the first attempt, and "maximum
+// authentication attempts exceeded" after the "Failed" line of the last
+// Not safe for concurrent use
+type failureTracker struct {
+	byPID map[string]*sshConnection
+}
+
+func newFailureTracker() *failureTracker {
+	return &failureTracker{byPID: make(map[string]*sshConnection)}
+}
+
+// duplicate reports whether the failed_auth event read from line repeats
+// an attempt already reported for the same connection
+func (t *failureTracker) duplicate(line string, timestamp time.Time) bool {
+	if matches := sshInvalidUserPattern.FindStringSubmatch(line); matches != nil {
+		c := t.connection(matches[1], timestamp)
+		c.invalidUser = true
+		return false
+	}
+	if matches := sshFailedPattern.FindStringSubmatch(line); matches != nil {
+		c := t.connection(matches[1], timestamp)
+		announced := c.invalidUser && matches[2] != ""
+		c.invalidUser = false
+		c.failed = true
+		return announced
+	}
+	if matches := sshMaxAuthPattern.FindStringSubmatch(line); matches != nil {
+		c := t.byPID[matches[1]]
+		t.forget(matches[1])
+		return c != nil && c.failed
+	}
+	return false
+}
+
+// handle forgets connections closed by line
+func (t *failureTracker) handle(line string) {
+	if matches := sshClosedPattern.FindStringSubmatch(line); matches != nil {
+		t.forget(matches[1])
+	}
+}
+
+// connection returns the tracked connection of pid, adding it if needed
+func (t *failureTracker) connection(pid string, timestamp time.Time) *sshConnection {
+	c := t.byPID[pid]
+	if c == nil {
+		t.evict()
+		c = &sshConnection{pid: pid}
+		t.byPID[pid] = c
+	}
+	c.last = timestamp
+	return c
+}
+
+func (t *failureTracker) forget(pid string) {
+	delete(t.byPID, pid)
+}
+
+// evict drops the least recently seen connection once the tracker is full
+func (t *failureTracker) evict() {
+	if len(t.byPID) < maxTrackedSessions {
+		return
+	}
+	var oldest *sshConnection
+	for _, c := range t.byPID {
+		if oldest == nil || c.last.Before(oldest.last) {
+			oldest = c
+		}
+	}
+	t.forget(oldest.pid)
+}
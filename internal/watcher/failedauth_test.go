@@ -0,0 +1,81 @@
+package watcher
+
+import (
+	"testing"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// failures returns the failed_auth events parsed from lines
+func failures(parser *authLogParser, lines []string) []notifier.LoginEvent {
+	var events []notifier.LoginEvent
+	for _, line := range lines {
+		if event := parser.parseLine(line); event != nil && event.Kind == notifier.KindFailedAuth {
+			events = append(events, *event)
+		}
+	}
+	return events
+}
+
+func TestFailureTrackerInvalidUser(t *testing.T) {
+	parser := newAuthLogParser("testhost", nil)
+
+	// What sshd logs for three password attempts at an unknown user, with
+	// MaxAuthTries 3
+	lines := []string{
+		"2026-10-17T12:00:00+00:00 host sshd[5150]: Invalid user admin from 203.0.113.9 port 51000",
+		"2026-10-17T12:00:01+00:00 host sshd[5150]: pam_unix(sshd:auth): check pass; user unknown",
+		"2026-10-17T12:00:01+00:00 host sshd[5150]: pam_unix(sshd:auth): authentication failure; logname= uid=0 euid=0 tty=ssh ruser= rhost=203.0.113.9",
+		"2026-10-17T12:00:03+00:00 host sshd[5150]: Failed password for invalid user admin from 203.0.113.9 port 51000 ssh2",
+		"2026-10-17T12:00:05+00:00 host sshd[5150]: Failed password for invalid user admin from 203.0.113.9 port 51000 ssh2",
+		"2026-10-17T12:00:07+00:00 host sshd[5150]: Failed password for invalid user admin from 203.0.113.9 port 51000 ssh2",
+		"2026-10-17T12:00:07+00:00 host sshd[5150]: error: maximum authentication attempts exceeded for invalid user admin from 203.0.113.9 port 51000 ssh2 [preauth]",
+		"2026-10-17T12:00:07+00:00 host sshd[5150]: Disconnecting invalid user admin 203.0.113.9 port 51000: Too many authentication failures [preauth]",
+	}
+
+	events := failures(parser, lines)
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 failed attempts, got %d: %+v", len(events), events)
+	}
+	for _, event := range events {
+		if event.Username != "admin" || event.IP != "203.0.113.9" {
+			t.Errorf("Expected admin from 203.0.113.9, got %s from %s", event.Username, event.IP)
+		}
+	}
+	if events[0].Detail != "Invalid user" || events[1].Detail != "Failed password" {
+		t.Errorf("Expected the invalid user, then failed passwords, got %q, %q", events[0].Detail, events[1].Detail)
+	}
+}
+
+func TestFailureTrackerConnections(t *testing.T) {
+	parser := newAuthLogParser("testhost", nil)
+
+	lines := []string{
+		// A key-only scanner: nothing but the invalid user
+		"2026-10-17T12:00:00+00:00 host sshd[6001]: Invalid user oracle from 198.51.100.7 port 40000",
+		"2026-10-17T12:00:00+00:00 host sshd[6001]: Connection closed by invalid user oracle 198.51.100.7 port 40000 [preauth]",
+		// A known user, interleaved with another connection
+		"2026-10-17T12:00:01+00:00 host sshd[6002]: Failed password for root from 198.51.100.8 port 40001 ssh2",
+		"2026-10-17T12:00:01+00:00 host sshd[6003]: Invalid user test from 198.51.100.9 port 40002",
+		"2026-10-17T12:00:02+00:00 host sshd[6002]: Failed password for root from 198.51.100.8 port 40001 ssh2",
+		"2026-10-17T12:00:02+00:00 host sshd[6003]: Failed password for invalid user test from 198.51.100.9 port 40002 ssh2",
+		"2026-10-17T12:00:03+00:00 host sshd[6002]: error: maximum authentication attempts exceeded for root from 198.51.100.8 port 40001 ssh2 [preauth]",
+		// Without a failed attempt, the limit is the only report
+		"2026-10-17T12:00:04+00:00 host sshd[6004]: error: maximum authentication attempts exceeded for bob from 198.51.100.10 port 40003 ssh2 [preauth]",
+	}
+
+	var got []string
+	for _, event := range failures(parser, lines) {
+		got = append(got, event.Username)
+	}
+	want := []string{"oracle", "root", "test", "root", "bob"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, got)
+			break
+		}
+	}
+}
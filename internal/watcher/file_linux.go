@@ -0,0 +1,103 @@
+//go:build linux
+
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// FileWatcher watches any text log, such as an application's own login log
+// Only custom rules apply: the built-in auth log rules are not used
+type FileWatcher struct {
+	hostname string
+	logFile  string
+}
+
+// Name returns the watcher name
+func (w *FileWatcher) Name() string {
+	return "file"
+}
+
+// LogFiles returns the watched file, for integrity monitoring
+func (w *FileWatcher) LogFiles() []string {
+	if _, err := os.Stat(w.logFile); err != nil {
+		return nil
+	}
+	return []string{w.logFile}
+}
+
+// Watch monitors the file for login events (new events only)
+func (w *FileWatcher) Watch(ctx context.Context, events chan<- notifier.LoginEvent) error {
+	return w.WatchWithOptions(ctx, events, Options{})
+}
+
+// WatchWithOptions monitors the file with specific options
+func (w *FileWatcher) WatchWithOptions(ctx context.Context, events chan<- notifier.LoginEvent, opts Options) error {
+	rules, err := compileRules(opts.Rules, nil)
+	if err != nil {
+		return fmt.Errorf("file: %w", err)
+	}
+	parser := newAuthLogParser(w.hostname, rules)
+
+	what := w.Name() + ":" + w.logFile
+	source := opts.checkpointKey(what)
+	tail := newTailer(w.logFile)
+	resumed := false
+	if cp, ok := opts.getCheckpoint(what); ok {
+		resumed = tail.resume(cp)
+	}
+
+	// History is read from the start of the file, dropping older events
+	var cutoff time.Time
+	var history int64 // bytes of the file that are history
+	var clock historyClock
+	if !resumed {
+		offset := int64(-1)
+		if opts.Since > 0 {
+			cutoff = time.Now().Add(-opts.Since)
+			offset = 0
+		}
+		if err := tail.open(offset); err != nil {
+			return fmt.Errorf("file: %w", err)
+		}
+		if offset < 0 {
+			// Save the position, so that lines logged before a restart
+			// are not skipped if none of them is read first
+			opts.Checkpoints.Update(source, tail.checkpoint(), true)
+		} else {
+			history = tail.info.Size()
+		}
+	}
+
+	err = tail.follow(ctx, func(line string) {
+		var event *notifier.LoginEvent
+		if !cutoff.IsZero() && tail.offset <= history {
+			// Lines without a timestamp are as old as the line before
+			// them, and dropped if no line before them has one
+			timestamp, ok := clock.timestamp(line)
+			event = parser.parse(line, timestamp)
+			if event != nil && (!ok || event.Timestamp.Before(cutoff)) {
+				event = nil
+			}
+		} else {
+			event = parser.parseLine(line)
+		}
+		if event != nil {
+			select {
+			case events <- *event:
+			case <-ctx.Done():
+				return
+			}
+		}
+		opts.Checkpoints.Update(source, tail.checkpoint(), event != nil)
+	})
+	if err != nil {
+		return fmt.Errorf("file: %w", err)
+	}
+	return nil
+}
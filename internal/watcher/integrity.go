@@ -123,14 +123,7 @@ func (m *LogIntegrityMonitor) checkIntegrity(alerts chan<- notifier.LoginEvent)
 		// Check if file was deleted
 		if err != nil {
 			if os.IsNotExist(err) && m.opts.DetectDeletion && oldState != nil {
-				alerts <- notifier.LoginEvent{
-					Username:  "SECURITY",
-					Hostname:  hostname,
-					Terminal:  "log-integrity",
-					Timestamp: time.Now(),
-					OS:        getOS(),
-					IP:        fmt.Sprintf("⚠️ Log file DELETED: %s", file),
-				}
+				alerts <- integrityAlert(hostname, file, "deleted", fmt.Sprintf("Log file DELETED: %s", file))
 				delete(m.states, file)
 			}
 			continue
@@ -147,40 +140,19 @@ func (m *LogIntegrityMonitor) checkIntegrity(alerts chan<- notifier.LoginEvent)
 			if newState.size < oldState.size {
 				dropPercent := float64(oldState.size-newState.size) / float64(oldState.size) * 100
 				if dropPercent >= float64(m.opts.FileSizeDropThreshold) {
-					alerts <- notifier.LoginEvent{
-						Username:  "SECURITY",
-						Hostname:  hostname,
-						Terminal:  "log-integrity",
-						Timestamp: time.Now(),
-						OS:        getOS(),
-						IP:        fmt.Sprintf("⚠️ Log file TRUNCATED: %s (%.0f%% smaller)", file, dropPercent),
-					}
+					alerts <- integrityAlert(hostname, file, "truncated", fmt.Sprintf("Log file TRUNCATED: %s (%.0f%% smaller)", file, dropPercent))
 				}
 			}
 		}
 
 		// Check for inode change (file replaced)
 		if m.opts.DetectInodeChange && oldState.inode != newState.inode {
-			alerts <- notifier.LoginEvent{
-				Username:  "SECURITY",
-				Hostname:  hostname,
-				Terminal:  "log-integrity",
-				Timestamp: time.Now(),
-				OS:        getOS(),
-				IP:        fmt.Sprintf("⚠️ Log file REPLACED: %s (inode changed)", file),
-			}
+			alerts <- integrityAlert(hostname, file, "replaced", fmt.Sprintf("Log file REPLACED: %s (inode changed)", file))
 		}
 
 		// Check for permission change
 		if m.opts.DetectPermissionChange && oldState.mode != newState.mode {
-			alerts <- notifier.LoginEvent{
-				Username:  "SECURITY",
-				Hostname:  hostname,
-				Terminal:  "log-integrity",
-				Timestamp: time.Now(),
-				OS:        getOS(),
-				IP:        fmt.Sprintf("⚠️ Log file PERMISSIONS changed: %s (%v → %v)", file, oldState.mode, newState.mode),
-			}
+			alerts <- integrityAlert(hostname, file, "permissions", fmt.Sprintf("Log file PERMISSIONS changed: %s (%v → %v)", file, oldState.mode, newState.mode))
 		}
 
 		// Update state
@@ -188,6 +160,22 @@ func (m *LogIntegrityMonitor) checkIntegrity(alerts chan<- notifier.LoginEvent)
 	}
 }
 
+// integrityAlert builds a log tampering event
+func integrityAlert(hostname, file, change, detail string) notifier.LoginEvent {
+	return notifier.LoginEvent{
+		Kind:      notifier.KindIntegrity,
+		Severity:  notifier.SeverityCritical,
+		Hostname:  hostname,
+		Timestamp: time.Now(),
+		OS:        getOS(),
+		Detail:    detail,
+		Fields: map[string]string{
+			notifier.FieldFile:   file,
+			notifier.FieldChange: change,
+		},
+	}
+}
+
 func getOS() string {
 	switch {
 	case fileExists("/var/log/auth.log") || fileExists("/var/log/secure"):
@@ -0,0 +1,71 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// journalEntry is a single record from `journalctl -o json`
+// Values are kept raw because journald encodes non-UTF-8 fields as byte arrays
+type journalEntry map[string]json.RawMessage
+
+// field returns a journal field as a string, or "" if it is missing
+func (e journalEntry) field(name string) string {
+	raw, ok := e[name]
+	if !ok {
+		return ""
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+
+	// Binary-safe fields are encoded as an array of byte values
+	var ints []int
+	if err := json.Unmarshal(raw, &ints); err == nil {
+		b := make([]byte, len(ints))
+		for i, v := range ints {
+			b[i] = byte(v)
+		}
+		return string(b)
+	}
+
+	return ""
+}
+
+// timestamp returns the wall clock time the entry was logged
+func (e journalEntry) timestamp() time.Time {
+	if t, ok := parseJournalTimestamp(e.field("__REALTIME_TIMESTAMP")); ok {
+		return t
+	}
+	return time.Now()
+}
+
+// line rebuilds a syslog-style "ident[pid]: message" line from the entry
+// so that it can be matched by the same patterns as text auth logs
+func (e journalEntry) line() string {
+	ident := e.field("SYSLOG_IDENTIFIER")
+	if ident == "" {
+		ident = e.field("_COMM")
+	}
+
+	pid := e.field("SYSLOG_PID")
+	if pid == "" {
+		pid = e.field("_PID")
+	}
+
+	if pid == "" {
+		return fmt.Sprintf("%s: %s", ident, e.field("MESSAGE"))
+	}
+	return fmt.Sprintf("%s[%s]: %s", ident, pid, e.field("MESSAGE"))
+}
+
+// parseJournalEntry extracts an event from a journal entry
+// Returns nil if the entry does not describe a login, failure or session end
+func (p *authLogParser) parseJournalEntry(entry journalEntry) *notifier.LoginEvent {
+	return p.parse(entry.line(), entry.timestamp())
+}
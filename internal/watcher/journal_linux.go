@@ -0,0 +1,109 @@
+//go:build linux
+
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// JournalWatcher watches the systemd journal for login events
+// Used on distributions that do not write a text auth log
+type JournalWatcher struct {
+	hostname string
+}
+
+// Name returns the watcher name
+func (w *JournalWatcher) Name() string {
+	return "journal"
+}
+
+// Watch monitors the systemd journal for login events (new events only)
+func (w *JournalWatcher) Watch(ctx context.Context, events chan<- notifier.LoginEvent) error {
+	return w.WatchWithOptions(ctx, events, Options{})
+}
+
+// WatchWithOptions monitors the systemd journal with specific options
+func (w *JournalWatcher) WatchWithOptions(ctx context.Context, events chan<- notifier.LoginEvent, opts Options) error {
+	rules, err := compileRules(opts.Rules, authLogRules)
+	if err != nil {
+		return fmt.Errorf("journal: %w", err)
+	}
+
+	args := []string{"-o", "json", "--no-pager", "-f"}
+	source := opts.checkpointKey(w.Name())
+	if cp, ok := opts.getCheckpoint(w.Name()); ok && cp.Cursor != "" {
+		// Resume after the last entry read by the previous run
+		args = append(args, "-n", "all", "--after-cursor", cp.Cursor)
+	} else if opts.Since > 0 {
+		minutes := int(opts.Since.Minutes())
+		if minutes < 1 {
+			minutes = 1
+		}
+		args = append(args, "-n", "all", "--since", fmt.Sprintf("%d minutes ago", minutes))
+	} else if cursor := currentJournalCursor(ctx); cursor != "" {
+		// Start from the newest entry and save it right away, so that
+		// entries written before the first event survive a restart
+		opts.Checkpoints.Update(source, Checkpoint{Cursor: cursor}, true)
+		args = append(args, "-n", "all", "--after-cursor", cursor)
+	} else {
+		args = append(args, "-n", "0")
+	}
+	// Only auth (4) and authpriv (10) facilities carry login records
+	args = append(args, "SYSLOG_FACILITY=4", "SYSLOG_FACILITY=10")
+
+	cmd := exec.CommandContext(ctx, "journalctl", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("journal: failed to create stdout pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("journal: failed to start journalctl: %w", err)
+	}
+
+	parser := newAuthLogParser(w.hostname, rules)
+	decoder := json.NewDecoder(stdout)
+	for {
+		var entry journalEntry
+		if err := decoder.Decode(&entry); err != nil {
+			break
+		}
+
+		event := parser.parseJournalEntry(entry)
+		if event != nil {
+			select {
+			case events <- *event:
+			case <-ctx.Done():
+				cmd.Wait()
+				return nil
+			}
+		}
+		if cursor := entry.field("__CURSOR"); cursor != "" {
+			opts.Checkpoints.Update(source, Checkpoint{Cursor: cursor}, event != nil)
+		}
+	}
+
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("journal: journalctl exited: %w", err)
+	}
+	return nil
+}
+
+// currentJournalCursor returns the cursor of the newest journal entry,
+// or "" if the journal is empty or cannot be read
+func currentJournalCursor(ctx context.Context) string {
+	out, err := exec.CommandContext(ctx, "journalctl", "-o", "json", "--no-pager", "-q", "-n", "1").Output()
+	if err != nil {
+		return ""
+	}
+	var entry journalEntry
+	if err := json.Unmarshal(out, &entry); err != nil {
+		return ""
+	}
+	return entry.field("__CURSOR")
+}
@@ -0,0 +1,106 @@
+package watcher
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+func TestParseJournalFixture(t *testing.T) {
+	f, err := os.Open("testdata/journal.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	parser := newAuthLogParser("testhost", nil)
+	var got []notifier.LoginEvent
+	decoder := json.NewDecoder(f)
+	for decoder.More() {
+		var entry journalEntry
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatalf("Failed to decode fixture: %v", err)
+		}
+		if event := parser.parseJournalEntry(entry); event != nil {
+			got = append(got, *event)
+		}
+	}
+
+	want := []struct {
+		username  string
+		ip        string
+		terminal  string
+		timestamp time.Time
+	}{
+		{"alice", "192.168.1.100", "ssh", time.UnixMicro(1760702401123456)},
+		{"root", "", "crond", time.UnixMicro(1760702460000000)},
+		{"bob", "", "tty1", time.UnixMicro(1760702520500000)},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d events, got %d: %+v", len(want), len(got), got)
+	}
+
+	for i, w := range want {
+		e := got[i]
+		if e.Username != w.username {
+			t.Errorf("event[%d]: expected username '%s', got '%s'", i, w.username, e.Username)
+		}
+		if e.IP != w.ip {
+			t.Errorf("event[%d]: expected IP '%s', got '%s'", i, w.ip, e.IP)
+		}
+		if e.Terminal != w.terminal {
+			t.Errorf("event[%d]: expected terminal '%s', got '%s'", i, w.terminal, e.Terminal)
+		}
+		if !e.Timestamp.Equal(w.timestamp) {
+			t.Errorf("event[%d]: expected timestamp %v, got %v", i, w.timestamp, e.Timestamp)
+		}
+		if e.Hostname != "testhost" {
+			t.Errorf("event[%d]: expected hostname 'testhost', got '%s'", i, e.Hostname)
+		}
+	}
+}
+
+func TestJournalEntryLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+		want  string
+	}{
+		{
+			name:  "identifier and syslog pid",
+			entry: `{"SYSLOG_IDENTIFIER":"sshd","SYSLOG_PID":"10","_PID":"11","_COMM":"sshd","MESSAGE":"hello"}`,
+			want:  "sshd[10]: hello",
+		},
+		{
+			name:  "fallback to comm and pid",
+			entry: `{"_PID":"11","_COMM":"login","MESSAGE":"hello"}`,
+			want:  "login[11]: hello",
+		},
+		{
+			name:  "no pid",
+			entry: `{"SYSLOG_IDENTIFIER":"kernel","MESSAGE":"hello"}`,
+			want:  "kernel: hello",
+		},
+		{
+			name:  "binary message",
+			entry: `{"SYSLOG_IDENTIFIER":"su","MESSAGE":[104,105]}`,
+			want:  "su: hi",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entry journalEntry
+			if err := json.Unmarshal([]byte(tt.entry), &entry); err != nil {
+				t.Fatal(err)
+			}
+			if got := entry.line(); got != tt.want {
+				t.Errorf("line() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
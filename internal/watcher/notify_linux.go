@@ -0,0 +1,87 @@
+//go:build linux
+
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Events on the followed file: appends, rotation by rename, removal
+const inotifyFileMask = syscall.IN_MODIFY | syscall.IN_MOVE_SELF | syscall.IN_DELETE_SELF | syscall.IN_ATTRIB
+
+// Events on the parent directory: a new file created or renamed into place
+const inotifyDirMask = syscall.IN_CREATE | syscall.IN_MOVED_TO
+
+// inotifyNotifier wakes the tailer on inotify events
+type inotifyNotifier struct {
+	fd     int
+	file   *os.File
+	fileWd int
+	events chan struct{}
+}
+
+func newFileNotifier(path string) (fileNotifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(path), inotifyDirMask); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	n := &inotifyNotifier{
+		fd:     fd,
+		fileWd: -1,
+		events: make(chan struct{}, 1),
+	}
+	// The file may not exist yet; the directory watch reports its creation
+	n.Rewatch(path)
+
+	// A non-blocking fd lets the runtime poller wake Read, and Close unblock it
+	n.file = os.NewFile(uintptr(fd), "inotify")
+	go n.run()
+
+	return n, nil
+}
+
+// run turns raw inotify reads into coalesced wake-ups
+func (n *inotifyNotifier) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		if _, err := n.file.Read(buf); err != nil {
+			return
+		}
+		select {
+		case n.events <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Events signals that the file or its directory changed
+func (n *inotifyNotifier) Events() <-chan struct{} {
+	return n.events
+}
+
+// Rewatch moves the file watch to the file now at path
+func (n *inotifyNotifier) Rewatch(path string) error {
+	if n.fileWd >= 0 {
+		syscall.InotifyRmWatch(n.fd, uint32(n.fileWd))
+		n.fileWd = -1
+	}
+	wd, err := syscall.InotifyAddWatch(n.fd, path, inotifyFileMask)
+	if err != nil {
+		return err
+	}
+	n.fileWd = wd
+	return nil
+}
+
+// Close stops the notifier
+func (n *inotifyNotifier) Close() error {
+	return n.file.Close()
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package watcher
+
+// newFileNotifier is unavailable here; the tailer falls back to polling
+func newFileNotifier(path string) (fileNotifier, error) {
+	return nil, errNotifyUnsupported
+}
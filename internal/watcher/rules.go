@@ -0,0 +1,265 @@
+package watcher
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// sshKeyPattern matches the key and certificate details sshd appends to
+// publickey logins:
+// ": ED25519 SHA256:..." or
+// ": ED25519-CERT SHA256:... ID alice@corp (serial 42) CA ED25519 SHA256:..."
+const sshKeyPattern = `(?:\s+ssh2)?(?::\s+(?P<key_type>\S+)\s+(?P<fingerprint>\S+)` +
+	`(?:\s+ID\s+(?P<cert_id>.+?)\s+\(serial\s+(?P<cert_serial>\d+)\)\s+CA\s+\S+\s+(?P<ca_fingerprint>\S+))?)?`
+
+// authLogRules are the built-in rules for syslog-style auth logs
+// Login rules come first so that a line is never reported as both
+var authLogRules = []config.RuleConfig{
+	{
+		// "Accepted password for user from IP port N ssh2"
+		// The source is an IPv4/IPv6 address, or a hostname with UseDNS yes
+		// OpenSSH 9.8+ logs from the per-connection "sshd-session" process
+		Name:    "ssh-accepted",
+		Pattern: `sshd(?:-session)?\[\d+\]:\s+Accepted\s+(?P<method>\S+)\s+for\s+(?P<user>\S+)\s+from\s+(?P<ip>\S+)\s+port\s+(?P<port>\d+)` + sshKeyPattern,
+		Fields:  map[string]string{config.RuleFieldTerminal: "ssh"},
+	},
+	{
+		// "sudo: alice : TTY=pts/0 ; PWD=/home/alice ; USER=root ; COMMAND=/usr/bin/id"
+		// Rejected attempts carry a reason before TTY=
+		Name:     "sudo-denied",
+		Pattern:  `\bsudo(?:\[\d+\])?:\s+(?P<user>\S+)\s+:\s+(?P<reason>[^;]*(?:incorrect password attempts?|NOT in sudoers|command not allowed|a password is required))\s+;\s+TTY=(?P<terminal>\S+)\s+;.*?\bUSER=(?P<target_user>\S+)\s+;.*?\bCOMMAND=(?P<command>.*)$`,
+		Kind:     string(notifier.KindPrivilegeEscalation),
+		Severity: string(notifier.SeverityHigh),
+		Detail:   "sudo denied: ${reason}",
+	},
+	{
+		Name:    "sudo",
+		Pattern: `\bsudo(?:\[\d+\])?:\s+(?P<user>\S+)\s+:\s+TTY=(?P<terminal>\S+)\s+;.*?\bUSER=(?P<target_user>\S+)\s+;.*?\bCOMMAND=(?P<command>.*)$`,
+		Kind:    string(notifier.KindPrivilegeEscalation),
+	},
+	{
+		// "su: (to root) alice on pts/0"
+		Name:    "su",
+		Pattern: `\bsu(?:\[\d+\])?:\s+\(to (?P<target_user>\S+)\)\s+(?P<user>\S+)\s+on\s+(?P<terminal>\S+)`,
+		Kind:    string(notifier.KindPrivilegeEscalation),
+	},
+	{
+		// "pam_unix(login:session): session opened for user bob(uid=1000) by LOGIN(uid=0)"
+		// sshd sessions are already reported by the "Accepted" line, and
+		// su/sudo sessions by the privilege escalation rules
+		Name:    "pam-session",
+		Pattern: `pam_unix\((?P<terminal>[\w-]+):session\):\s+session opened for user\s+(?P<user>[^\s(]+)`,
+		Exclude: `pam_unix\((?:sshd|su|su-l|sudo|sudo-i):session\)`,
+	},
+	{
+		// "LOGIN ON tty1 BY user"
+		Name:    "tty-login",
+		Pattern: `LOGIN ON\s+(?P<terminal>\S+)\s+BY\s+(?P<user>\S+)`,
+	},
+	{
+		// "Failed password for [invalid user ]user from IP port N ssh2"
+		Name:    "ssh-failed",
+		Pattern: `sshd(?:-session)?\[\d+\]:\s+Failed\s+(?P<method>\S+)\s+for\s+(?:invalid user\s+)?(?P<user>\S+)\s+from\s+(?P<ip>\S+)\s+port\s+(?P<port>\d+)`,
+		Kind:    string(notifier.KindFailedAuth),
+		Fields:  map[string]string{config.RuleFieldTerminal: "ssh"},
+		Detail:  "Failed ${method}",
+	},
+	{
+		// "Invalid user xxx from IP port N"
+		// Counts as the first attempt: the "Failed ... for invalid user"
+		// line that follows it is dropped (see failureTracker)
+		Name:    "ssh-invalid-user",
+		Pattern: `sshd(?:-session)?\[\d+\]:\s+Invalid user\s+(?P<user>\S*)\s+from\s+(?P<ip>\S+)(?:\s+port\s+(?P<port>\d+))?`,
+		Kind:    string(notifier.KindFailedAuth),
+		Fields:  map[string]string{config.RuleFieldTerminal: "ssh"},
+		Detail:  "Invalid user",
+	},
+	{
+		// "maximum authentication attempts exceeded for user from IP port N ssh2"
+		// Dropped after a "Failed ..." line of the same connection
+		Name:    "ssh-max-auth",
+		Pattern: `sshd(?:-session)?\[\d+\]:\s+(?:error: )?maximum authentication attempts exceeded for\s+(?:invalid user\s+)?(?P<user>\S+)\s+from\s+(?P<ip>\S+)(?:\s+port\s+(?P<port>\d+))?`,
+		Kind:    string(notifier.KindFailedAuth),
+		Fields:  map[string]string{config.RuleFieldTerminal: "ssh"},
+		Detail:  "Maximum authentication attempts exceeded",
+	},
+	{
+		// "pam_unix(su:auth): authentication failure; logname=... rhost=... user=xxx"
+		// sshd failures are already reported by the "Failed ..." line
+		Name:    "pam-auth-failure",
+		Pattern: `pam_unix\((?P<terminal>[\w-]+):auth\):\s+authentication failure;.*?\brhost=(?P<ip>\S*)(?:\s+user=(?P<user>\S+))?`,
+		Exclude: `pam_unix\(sshd:auth\)`,
+		Kind:    string(notifier.KindFailedAuth),
+		Detail:  "Authentication failure",
+	},
+	{
+		// "FAILED LOGIN (1) on '/dev/tty1' FOR 'user', Authentication failure"
+		Name:    "tty-failed",
+		Pattern: `FAILED LOGIN \(\d+\) on '(?:/dev/)?(?P<terminal>[^']+)' FOR '(?P<user>[^']+)'`,
+		Kind:    string(notifier.KindFailedAuth),
+		Detail:  "Authentication failure",
+	},
+}
+
+// defaultAuthLogRules are the compiled built-in auth log rules
+var defaultAuthLogRules = mustCompileRules(nil, authLogRules)
+
+// rule is a compiled detection rule
+type rule struct {
+	name     string
+	pattern  *regexp.Regexp
+	exclude  *regexp.Regexp
+	kind     notifier.EventKind
+	severity notifier.Severity
+	fields   map[string]string // event field -> expansion template
+	detail   string
+}
+
+// ruleSet is an ordered list of rules; the first match wins
+type ruleSet struct {
+	rules []*rule
+}
+
+// ValidateRules checks that custom rules compile, including their kinds
+// and severities
+func ValidateRules(custom []config.RuleConfig) error {
+	_, err := compileRules(custom, nil)
+	return err
+}
+
+// compileRules compiles custom rules followed by the built-in ones
+// A custom rule replaces (or, if disabled, removes) the built-in rule of
+// the same name
+func compileRules(custom, builtin []config.RuleConfig) (*ruleSet, error) {
+	overridden := make(map[string]bool)
+	var all []config.RuleConfig
+	for _, rc := range custom {
+		overridden[rc.Name] = true
+		if !rc.Disabled {
+			all = append(all, rc)
+		}
+	}
+	for _, rc := range builtin {
+		if !overridden[rc.Name] {
+			all = append(all, rc)
+		}
+	}
+
+	set := &ruleSet{}
+	for _, rc := range all {
+		r, err := compileRule(rc)
+		if err != nil {
+			return nil, err
+		}
+		set.rules = append(set.rules, r)
+	}
+	return set, nil
+}
+
+func mustCompileRules(custom, builtin []config.RuleConfig) *ruleSet {
+	set, err := compileRules(custom, builtin)
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+func compileRule(rc config.RuleConfig) (*rule, error) {
+	pattern, err := regexp.Compile(rc.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: invalid pattern: %w", rc.Name, err)
+	}
+
+	r := &rule{
+		name:     rc.Name,
+		pattern:  pattern,
+		kind:     notifier.EventKind(rc.Kind),
+		severity: notifier.Severity(rc.Severity),
+		fields:   make(map[string]string),
+		detail:   rc.Detail,
+	}
+
+	if rc.Exclude != "" {
+		if r.exclude, err = regexp.Compile(rc.Exclude); err != nil {
+			return nil, fmt.Errorf("rule %s: invalid exclude: %w", rc.Name, err)
+		}
+	}
+	if r.kind == "" {
+		r.kind = notifier.KindLogin
+	}
+	if !r.kind.Valid() {
+		return nil, fmt.Errorf("rule %s: unknown kind %q", rc.Name, rc.Kind)
+	}
+	if r.severity != "" && !r.severity.Valid() {
+		return nil, fmt.Errorf("rule %s: unknown severity %q", rc.Name, rc.Severity)
+	}
+
+	// Named groups map to fields of the same name unless mapped explicitly
+	for _, field := range config.RuleFields {
+		if pattern.SubexpIndex(field) >= 0 {
+			r.fields[field] = "${" + field + "}"
+		}
+	}
+	for field, template := range rc.Fields {
+		r.fields[field] = template
+	}
+	return r, nil
+}
+
+// match builds an event from the first rule matching line
+// Returns nil if no rule matches
+func (s *ruleSet) match(line, hostname, goos string, timestamp time.Time) *notifier.LoginEvent {
+	for _, r := range s.rules {
+		if event := r.match(line, hostname, goos, timestamp); event != nil {
+			return event
+		}
+	}
+	return nil
+}
+
+func (r *rule) match(line, hostname, goos string, timestamp time.Time) *notifier.LoginEvent {
+	submatches := r.pattern.FindStringSubmatchIndex(line)
+	if submatches == nil {
+		return nil
+	}
+	if r.exclude != nil && r.exclude.MatchString(line) {
+		return nil
+	}
+
+	expand := func(template string) string {
+		return string(r.pattern.ExpandString(nil, template, line, submatches))
+	}
+
+	event := &notifier.LoginEvent{
+		Kind:      r.kind,
+		Severity:  r.severity,
+		Hostname:  hostname,
+		Timestamp: timestamp,
+		OS:        goos,
+		Detail:    expand(r.detail),
+	}
+
+	for field, template := range r.fields {
+		value := expand(template)
+		switch field {
+		case config.RuleFieldUser:
+			event.Username = value
+		case config.RuleFieldIP:
+			event.IP = value
+		case config.RuleFieldTerminal:
+			event.Terminal = value
+		default:
+			// Other rule fields are event fields of the same name
+			if value != "" {
+				event.SetField(field, value)
+			}
+		}
+	}
+	// IPv6, IPv4-mapped addresses and hostnames are all accepted
+	event.ParseSource()
+	return event
+}
@@ -0,0 +1,112 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+func TestCustomRules(t *testing.T) {
+	custom := []config.RuleConfig{
+		{
+			Name:    "xrdp",
+			Pattern: `xrdp-sesman\[\d+\]: .*login successful for user: (?P<user>\S+) on display \d+`,
+			Fields:  map[string]string{config.RuleFieldTerminal: "xrdp"},
+		},
+		{
+			Name:     "vsftpd",
+			Pattern:  `vsftpd\[\d+\]: \[(\S+)\] FAIL LOGIN: Client "(?:::ffff:)?([\d.]+)"`,
+			Kind:     "failed_auth",
+			Severity: "medium",
+			Fields: map[string]string{
+				config.RuleFieldUser:     "$1",
+				config.RuleFieldIP:       "$2",
+				config.RuleFieldTerminal: "ftp",
+			},
+			Detail: "FTP login failed for $1",
+		},
+		// Replace a built-in rule
+		{
+			Name:     "tty-login",
+			Pattern:  `LOGIN ON\s+(?P<terminal>\S+)\s+BY\s+(?P<user>\S+)`,
+			Kind:     "login",
+			Severity: "medium",
+		},
+		// Remove a built-in rule
+		{Name: "pam-session", Disabled: true},
+	}
+
+	rules, err := compileRules(custom, authLogRules)
+	if err != nil {
+		t.Fatalf("Failed to compile rules: %v", err)
+	}
+	now := time.Now()
+
+	event := rules.match("Oct 17 12:00:01 host xrdp-sesman[900]: [INFO ] login successful for user: svc-deploy on display 10", "h", "linux", now)
+	if event == nil {
+		t.Fatal("Expected xrdp rule to match")
+	}
+	if event.Username != "svc-deploy" || event.Terminal != "xrdp" || event.EventKind() != notifier.KindLogin {
+		t.Errorf("Unexpected xrdp event: %+v", event)
+	}
+
+	event = rules.match(`Oct 17 12:00:01 host vsftpd[77]: [bob] FAIL LOGIN: Client "::ffff:203.0.113.9"`, "h", "linux", now)
+	if event == nil {
+		t.Fatal("Expected vsftpd rule to match")
+	}
+	if event.Username != "bob" || event.IP != "203.0.113.9" || event.Terminal != "ftp" {
+		t.Errorf("Unexpected vsftpd event: %+v", event)
+	}
+	if event.EventKind() != notifier.KindFailedAuth || event.Severity != notifier.SeverityMedium {
+		t.Errorf("Expected failed_auth/medium, got %s/%s", event.EventKind(), event.Severity)
+	}
+	if event.Detail != "FTP login failed for bob" {
+		t.Errorf("Expected expanded detail, got '%s'", event.Detail)
+	}
+
+	event = rules.match("Oct 17 12:00:01 host login[789]: LOGIN ON tty1 BY bob", "h", "linux", now)
+	if event == nil || event.Severity != notifier.SeverityMedium {
+		t.Errorf("Expected overridden tty rule with medium severity, got %+v", event)
+	}
+
+	if event := rules.match("Oct 17 12:00:01 host su[456]: pam_unix(su:session): session opened for user root(uid=0) by alice(uid=1000)", "h", "linux", now); event != nil {
+		t.Errorf("Expected disabled pam-session rule not to match, got %+v", event)
+	}
+
+	// Built-ins still apply
+	event = rules.match("Oct 17 12:00:01 host sshd[123]: Failed publickey for root from 203.0.113.7 port 40022 ssh2", "h", "linux", now)
+	if event == nil {
+		t.Fatal("Expected built-in ssh-failed rule to match")
+	}
+	if event.Fields[notifier.FieldMethod] != "publickey" || event.Fields[notifier.FieldPort] != "40022" {
+		t.Errorf("Expected method and port fields, got %v", event.Fields)
+	}
+	if event.Detail != "Failed publickey" {
+		t.Errorf("Expected detail 'Failed publickey', got '%s'", event.Detail)
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    config.RuleConfig
+		wantErr bool
+	}{
+		{"valid", config.RuleConfig{Name: "a", Pattern: `x`, Kind: "failed_auth", Severity: "high"}, false},
+		{"default kind", config.RuleConfig{Name: "a", Pattern: `x`}, false},
+		{"unknown kind", config.RuleConfig{Name: "a", Pattern: `x`, Kind: "logon"}, true},
+		{"unknown severity", config.RuleConfig{Name: "a", Pattern: `x`, Severity: "urgent"}, true},
+		{"bad pattern", config.RuleConfig{Name: "a", Pattern: `(`}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRules([]config.RuleConfig{tt.rule})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error: %v, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package watcher
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// maxTrackedSessions bounds memory when session ends are never logged
+const maxTrackedSessions = 4096
+
+// logindLinkWindow is how soon after a login logind must register a session
+// for the two to be treated as the same session
+const logindLinkWindow = 10 * time.Second
+
+// Patterns to detect session start and end in auth log lines
+var (
+	// Process tag: "sshd[1234]:"
+	procPattern = regexp.MustCompile(`([\w.-]+)\[(\d+)\]:`)
+	// PAM session closed: "pam_unix(sshd:session): session closed for user xxx"
+	pamClosedPattern = regexp.MustCompile(`pam_unix\(([\w-]+):session\):\s+session closed for user\s+([^\s(]+)`)
+	// SSH disconnect: "Disconnected from user xxx IP port N"
+	sshDisconnectPattern = regexp.MustCompile(`sshd(?:-session)?\[\d+\]:\s+Disconnected from user\s+(\S+)`)
+	// logind session start: "New session 12 of user xxx."
+	logindNewPattern = regexp.MustCompile(`systemd-logind\[\d+\]:\s+New session\s+(\S+)\s+of user\s+([^\s.]+)`)
+	// logind session end: "Removed session 12."
+	logindRemovedPattern = regexp.MustCompile(`systemd-logind\[\d+\]:\s+Removed session\s+([^\s.]+)`)
+)
+
+// trackedSession is a login whose end has not been seen yet
+type trackedSession struct {
+	login     notifier.LoginEvent
+	pid       string
+	sessionID string
+}
+
+// sessionTracker correlates session ends with their logins by process ID
+// (sshd, su, login) or logind session ID, so logouts carry a duration
+// Not safe for concurrent use
+type sessionTracker struct {
+	byPID     map[string]*trackedSession
+	bySession map[string]*trackedSession
+	// lastByUser is the most recent login per user, used to link the
+	// logind session that follows it
+	lastByUser map[string]*trackedSession
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{
+		byPID:      make(map[string]*trackedSession),
+		bySession:  make(map[string]*trackedSession),
+		lastByUser: make(map[string]*trackedSession),
+	}
+}
+
+// opened records a login event read from line
+func (t *sessionTracker) opened(line string, login notifier.LoginEvent) {
+	matches := procPattern.FindStringSubmatch(line)
+	if matches == nil {
+		return
+	}
+
+	s := &trackedSession{login: login, pid: matches[2]}
+	t.evict()
+	t.byPID[s.pid] = s
+	t.lastByUser[login.Username] = s
+}
+
+// handle inspects a line for session boundaries
+// Returns a logout event when the line ends a tracked session
+func (t *sessionTracker) handle(line string, timestamp time.Time) *notifier.LoginEvent {
+	if matches := logindNewPattern.FindStringSubmatch(line); matches != nil {
+		id, user := matches[1], matches[2]
+		if s := t.lastByUser[user]; s != nil && s.sessionID == "" &&
+			timestamp.Sub(s.login.Timestamp) < logindLinkWindow {
+			s.sessionID = id
+			t.bySession[id] = s
+		}
+		return nil
+	}
+
+	if matches := logindRemovedPattern.FindStringSubmatch(line); matches != nil {
+		if s := t.bySession[matches[1]]; s != nil {
+			return t.closed(s, timestamp)
+		}
+		return nil
+	}
+
+	if pamClosedPattern.MatchString(line) || sshDisconnectPattern.MatchString(line) {
+		if matches := procPattern.FindStringSubmatch(line); matches != nil {
+			if s := t.byPID[matches[2]]; s != nil {
+				return t.closed(s, timestamp)
+			}
+		}
+	}
+
+	return nil
+}
+
+// closed forgets a session and builds its logout event
+func (t *sessionTracker) closed(s *trackedSession, timestamp time.Time) *notifier.LoginEvent {
+	t.forget(s)
+
+	duration := timestamp.Sub(s.login.Timestamp)
+	if duration < 0 {
+		duration = 0
+	}
+
+	logout := s.login
+	logout.ID = ""
+	logout.Kind = notifier.KindLogout
+	logout.Severity = ""
+	logout.Timestamp = timestamp
+	logout.Detail = "Session lasted " + duration.Round(time.Second).String()
+	logout.Fields = nil
+	logout.SetField(notifier.FieldDuration, duration.Round(time.Second).String())
+	logout.SetField(notifier.FieldPID, s.pid)
+	if s.sessionID != "" {
+		logout.SetField(notifier.FieldSessionID, s.sessionID)
+	}
+	return &logout
+}
+
+// forget removes every reference to s
+func (t *sessionTracker) forget(s *trackedSession) {
+	if t.byPID[s.pid] == s {
+		delete(t.byPID, s.pid)
+	}
+	if s.sessionID != "" && t.bySession[s.sessionID] == s {
+		delete(t.bySession, s.sessionID)
+	}
+	if t.lastByUser[s.login.Username] == s {
+		delete(t.lastByUser, s.login.Username)
+	}
+}
+
+// evict drops the oldest session once the tracker is full
+func (t *sessionTracker) evict() {
+	if len(t.byPID) < maxTrackedSessions {
+		return
+	}
+	var oldest *trackedSession
+	for _, s := range t.byPID {
+		if oldest == nil || s.login.Timestamp.Before(oldest.login.Timestamp) {
+			oldest = s
+		}
+	}
+	t.forget(oldest)
+}
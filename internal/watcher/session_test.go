@@ -0,0 +1,106 @@
+package watcher
+
+import (
+	"testing"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+func TestSessionTrackerSSH(t *testing.T) {
+	parser := newAuthLogParser("testhost", nil)
+
+	lines := []string{
+		"2026-10-17T12:00:00+00:00 host sshd[4242]: Accepted publickey for alice from 192.168.1.10 port 52814 ssh2",
+		"2026-10-17T12:00:00+00:00 host sshd[4242]: pam_unix(sshd:session): session opened for user alice(uid=1000) by (uid=0)",
+		"2026-10-17T12:00:00+00:00 host systemd-logind[700]: New session 12 of user alice.",
+		"2026-10-17T12:05:30+00:00 host sshd[4242]: Disconnected from user alice 192.168.1.10 port 52814",
+		"2026-10-17T12:05:30+00:00 host sshd[4242]: pam_unix(sshd:session): session closed for user alice",
+		"2026-10-17T12:05:30+00:00 host systemd-logind[700]: Removed session 12.",
+	}
+
+	var logouts []notifier.LoginEvent
+	for _, line := range lines {
+		if event := parser.parseLine(line); event != nil && event.Kind == notifier.KindLogout {
+			logouts = append(logouts, *event)
+		}
+	}
+
+	if len(logouts) != 1 {
+		t.Fatalf("Expected exactly 1 logout, got %d", len(logouts))
+	}
+
+	logout := logouts[0]
+	if logout.Username != "alice" || logout.IP != "192.168.1.10" || logout.Terminal != "ssh" {
+		t.Errorf("Logout should inherit login details, got %+v", logout)
+	}
+	if logout.Fields[notifier.FieldDuration] != "5m30s" {
+		t.Errorf("Expected duration '5m30s', got '%s'", logout.Fields[notifier.FieldDuration])
+	}
+	if logout.Fields[notifier.FieldPID] != "4242" {
+		t.Errorf("Expected pid '4242', got '%s'", logout.Fields[notifier.FieldPID])
+	}
+	if logout.Fields[notifier.FieldSessionID] != "12" {
+		t.Errorf("Expected session_id '12', got '%s'", logout.Fields[notifier.FieldSessionID])
+	}
+}
+
+func TestSessionTrackerLogindFirst(t *testing.T) {
+	parser := newAuthLogParser("testhost", nil)
+
+	lines := []string{
+		"2026-10-17T12:00:00+00:00 host sshd[100]: Accepted password for bob from 10.0.0.5 port 40000 ssh2",
+		"2026-10-17T12:00:01+00:00 host systemd-logind[700]: New session 3 of user bob.",
+		"2026-10-17T12:00:02+00:00 host systemd-logind[700]: Removed session 3.",
+		"2026-10-17T12:00:02+00:00 host sshd[100]: pam_unix(sshd:session): session closed for user bob",
+	}
+
+	var logouts []notifier.LoginEvent
+	for _, line := range lines {
+		if event := parser.parseLine(line); event != nil && event.Kind == notifier.KindLogout {
+			logouts = append(logouts, *event)
+		}
+	}
+
+	if len(logouts) != 1 {
+		t.Fatalf("Expected exactly 1 logout, got %d", len(logouts))
+	}
+	if logouts[0].Fields[notifier.FieldDuration] != "2s" {
+		t.Errorf("Expected duration '2s', got '%s'", logouts[0].Fields[notifier.FieldDuration])
+	}
+}
+
+func TestSessionTrackerPAM(t *testing.T) {
+	parser := newAuthLogParser("testhost", nil)
+
+	parser.parseLine("2026-10-17T12:00:00+00:00 host login[555]: pam_unix(login:session): session opened for user bob(uid=1000) by LOGIN(uid=0)")
+	event := parser.parseLine("2026-10-17T12:10:00+00:00 host login[555]: pam_unix(login:session): session closed for user bob")
+
+	if event == nil || event.Kind != notifier.KindLogout {
+		t.Fatalf("Expected a logout, got %+v", event)
+	}
+	if event.Terminal != "login" {
+		t.Errorf("Expected terminal 'login', got '%s'", event.Terminal)
+	}
+	if event.Fields[notifier.FieldDuration] != "10m0s" {
+		t.Errorf("Expected duration '10m0s', got '%s'", event.Fields[notifier.FieldDuration])
+	}
+}
+
+func TestSessionTrackerSuIsNotASession(t *testing.T) {
+	parser := newAuthLogParser("testhost", nil)
+
+	// su is reported as privilege escalation, so its session end is not a logout
+	parser.parseLine("2026-10-17T12:00:00+00:00 host su[555]: pam_unix(su:session): session opened for user root(uid=0) by alice(uid=1000)")
+	if event := parser.parseLine("2026-10-17T12:10:00+00:00 host su[555]: pam_unix(su:session): session closed for user root"); event != nil {
+		t.Errorf("Expected nil, got %+v", event)
+	}
+}
+
+func TestSessionTrackerUnknownSession(t *testing.T) {
+	parser := newAuthLogParser("testhost", nil)
+
+	// Sessions opened before whozere started cannot be correlated
+	if event := parser.parseLine("2026-10-17T12:00:00+00:00 host sshd[9]: pam_unix(sshd:session): session closed for user alice"); event != nil {
+		t.Errorf("Expected nil for an untracked session, got %+v", event)
+	}
+}
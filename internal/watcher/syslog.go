@@ -0,0 +1,215 @@
+package watcher
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// maxSyslogHosts bounds the per-host parsers kept by the syslog receiver
+const maxSyslogHosts = 4096
+
+// syslogMessage is a parsed RFC3164 or RFC5424 message
+type syslogMessage struct {
+	Priority  int // facility*8 + severity, -1 if missing
+	Timestamp time.Time
+	Hostname  string // "" if the sender did not include one
+	AppName   string
+	ProcID    string
+	Message   string
+}
+
+// line rebuilds a syslog-style "app[pid]: message" line from the message
+// so that it can be matched by the same patterns as text auth logs
+func (m syslogMessage) line() string {
+	if m.ProcID == "" {
+		return m.AppName + ": " + m.Message
+	}
+	return m.AppName + "[" + m.ProcID + "]: " + m.Message
+}
+
+// parseSyslogMessage parses a message in either RFC5424 format
+// ("<34>1 2026-10-17T12:00:01Z host sshd 123 - - msg") or the BSD RFC3164
+// format ("<34>Oct 17 12:00:01 host sshd[123]: msg")
+// Missing timestamps default to now
+func parseSyslogMessage(data string, now time.Time) (syslogMessage, bool) {
+	data = strings.TrimRight(data, "\r\n\x00")
+	if data == "" {
+		return syslogMessage{}, false
+	}
+
+	msg := syslogMessage{Priority: -1}
+	if strings.HasPrefix(data, "<") {
+		end := strings.IndexByte(data, '>')
+		if end < 2 || end > 4 {
+			return syslogMessage{}, false
+		}
+		pri, err := strconv.Atoi(data[1:end])
+		if err != nil || pri > 191 {
+			return syslogMessage{}, false
+		}
+		msg.Priority = pri
+		data = data[end+1:]
+	}
+
+	if rest, ok := strings.CutPrefix(data, "1 "); ok {
+		if parseRFC5424(rest, now, &msg) {
+			return msg, true
+		}
+	}
+	parseRFC3164(data, now, &msg)
+	return msg, true
+}
+
+// parseRFC5424 parses what follows "<PRI>1 "
+func parseRFC5424(s string, now time.Time, msg *syslogMessage) bool {
+	fields := strings.SplitN(s, " ", 6)
+	if len(fields) < 5 {
+		return false
+	}
+	nilValue := func(v string) string {
+		if v == "-" {
+			return ""
+		}
+		return v
+	}
+
+	if fields[0] != "-" {
+		t, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			return false
+		}
+		msg.Timestamp = t
+	} else {
+		msg.Timestamp = now
+	}
+	msg.Hostname = nilValue(fields[1])
+	msg.AppName = nilValue(fields[2])
+	msg.ProcID = nilValue(fields[3])
+	// fields[4] is MSGID
+
+	rest := ""
+	if len(fields) == 6 {
+		rest = skipStructuredData(fields[5])
+	}
+	msg.Message = strings.TrimPrefix(strings.TrimPrefix(rest, " "), "\ufeff")
+	return true
+}
+
+// skipStructuredData returns what follows the STRUCTURED-DATA of an
+// RFC5424 message: "-" or one or more "[id param="value"...]" elements
+func skipStructuredData(s string) string {
+	if rest, ok := strings.CutPrefix(s, "-"); ok {
+		return rest
+	}
+	for strings.HasPrefix(s, "[") {
+		inQuotes := false
+		end := -1
+		for i := 1; i < len(s) && end < 0; i++ {
+			switch s[i] {
+			case '\\':
+				i++ // escaped ", ] or \
+			case '"':
+				inQuotes = !inQuotes
+			case ']':
+				if !inQuotes {
+					end = i
+				}
+			}
+		}
+		if end < 0 {
+			return ""
+		}
+		s = s[end+1:]
+	}
+	return s
+}
+
+// parseRFC3164 parses a BSD syslog message after its priority
+// Senders vary: the timestamp, hostname and pid are all optional
+func parseRFC3164(s string, now time.Time, msg *syslogMessage) {
+	msg.Timestamp = now
+	if t, ok := parseLogTimestamp(s, now); ok {
+		msg.Timestamp = t
+		s = skipTimestamp(s)
+	}
+
+	// The hostname is the next word unless that word is already the tag
+	if word, rest, ok := strings.Cut(s, " "); ok && !strings.HasSuffix(word, ":") && !strings.Contains(word, "[") {
+		msg.Hostname = word
+		s = rest
+	}
+
+	// TAG[pid]: message
+	tag, message, ok := strings.Cut(s, ": ")
+	if !ok || strings.Contains(tag, " ") {
+		msg.Message = s
+		return
+	}
+	if open := strings.IndexByte(tag, '['); open > 0 && strings.HasSuffix(tag, "]") {
+		msg.ProcID = tag[open+1 : len(tag)-1]
+		tag = tag[:open]
+	}
+	msg.AppName = tag
+	msg.Message = message
+}
+
+// skipTimestamp removes the timestamp recognized by parseLogTimestamp
+func skipTimestamp(s string) string {
+	s = strings.TrimLeft(s, " ")
+	if len(s) >= len(time.Stamp) {
+		if _, err := time.Parse(time.Stamp, s[:len(time.Stamp)]); err == nil {
+			return strings.TrimLeft(s[len(time.Stamp):], " ")
+		}
+	}
+	// ISO timestamp: a single word
+	_, rest, _ := strings.Cut(s, " ")
+	return rest
+}
+
+// syslogReceiver turns messages from many hosts into events, keeping a
+// parser per host so sessions are correlated within each host
+// Not safe for concurrent use
+type syslogReceiver struct {
+	rules   *ruleSet
+	parsers map[string]*authLogParser
+}
+
+func newSyslogReceiver(rules *ruleSet) *syslogReceiver {
+	return &syslogReceiver{
+		rules:   rules,
+		parsers: make(map[string]*authLogParser),
+	}
+}
+
+// handle extracts an event from a message sent by sender
+// The host is the hostname in the header, or the sender if there is none
+func (r *syslogReceiver) handle(msg syslogMessage, sender string) *notifier.LoginEvent {
+	host := msg.Hostname
+	if host == "" {
+		host = sender
+	}
+
+	parser, ok := r.parsers[host]
+	if !ok {
+		if len(r.parsers) >= maxSyslogHosts {
+			clear(r.parsers)
+		}
+		parser = newAuthLogParser(host, r.rules)
+		r.parsers[host] = parser
+	}
+
+	event := parser.parse(msg.line(), msg.Timestamp)
+	if event == nil {
+		return nil
+	}
+	// The sending host's OS is not known
+	event.OS = ""
+	// A relay forwards messages of other hosts
+	if sender != "" && sender != host {
+		event.SetField(notifier.FieldSender, sender)
+	}
+	return event
+}
@@ -0,0 +1,322 @@
+package watcher
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// maxSyslogMessage is the largest message accepted; longer TCP frames
+// close the connection
+const maxSyslogMessage = 64 * 1024
+
+// maxFrameLengthDigits bounds the octet count of a TCP frame
+var maxFrameLengthDigits = len(strconv.Itoa(maxSyslogMessage))
+
+// TCP connection limits, so that idle or slow senders cannot hold
+// goroutines and file descriptors
+const (
+	// syslogIdleTimeout closes connections that send no frame for this long
+	syslogIdleTimeout = 5 * time.Minute
+	// maxSyslogConns bounds concurrent connections; further ones are closed
+	maxSyslogConns = 256
+)
+
+// syslogPacket is a raw message and the address it came from
+type syslogPacket struct {
+	data   string
+	sender string
+}
+
+// SyslogWatcher receives syslog messages forwarded by other hosts
+// Events carry the hostname from the message header, so one instance
+// can watch many hosts
+type SyslogWatcher struct {
+	cfg   config.SyslogConfig
+	allow []netip.Prefix
+
+	idleTimeout time.Duration
+	maxConns    int
+}
+
+// NewSyslogWatcher creates a syslog receiver
+func NewSyslogWatcher(cfg config.SyslogConfig) (*SyslogWatcher, error) {
+	w := &SyslogWatcher{cfg: cfg, idleTimeout: syslogIdleTimeout, maxConns: maxSyslogConns}
+	if w.cfg.Listen == "" {
+		w.cfg.Listen = config.DefaultSyslogListen
+	}
+	if w.cfg.Protocol == "" {
+		w.cfg.Protocol = config.SyslogUDP
+	}
+	for _, a := range cfg.Allow {
+		prefix, err := config.ParsePrefix(a)
+		if err != nil {
+			return nil, fmt.Errorf("syslog: allow: %w", err)
+		}
+		w.allow = append(w.allow, prefix)
+	}
+	return w, nil
+}
+
+// Name returns the watcher name
+func (w *SyslogWatcher) Name() string {
+	return "syslog"
+}
+
+// LogFiles returns nil: no local file is read
+func (w *SyslogWatcher) LogFiles() []string {
+	return nil
+}
+
+// Watch receives login events from the network
+func (w *SyslogWatcher) Watch(ctx context.Context, events chan<- notifier.LoginEvent) error {
+	return w.WatchWithOptions(ctx, events, Options{})
+}
+
+// WatchWithOptions receives login events from the network
+// Since and Checkpoints do not apply: senders are not replayed
+func (w *SyslogWatcher) WatchWithOptions(ctx context.Context, events chan<- notifier.LoginEvent, opts Options) error {
+	rules, err := compileRules(opts.Rules, authLogRules)
+	if err != nil {
+		return fmt.Errorf("syslog: %w", err)
+	}
+
+	packets := make(chan syslogPacket, 64)
+	if _, err := w.listen(ctx, packets); err != nil {
+		return fmt.Errorf("syslog: %w", err)
+	}
+
+	receiver := newSyslogReceiver(rules)
+	for {
+		select {
+		case p := <-packets:
+			msg, ok := parseSyslogMessage(p.data, time.Now())
+			if !ok {
+				continue
+			}
+			if event := receiver.handle(msg, p.sender); event != nil {
+				select {
+				case events <- *event:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// listen starts receiving messages into packets until ctx is cancelled
+// Returns the address listened on
+func (w *SyslogWatcher) listen(ctx context.Context, packets chan<- syslogPacket) (net.Addr, error) {
+	if w.cfg.Protocol == config.SyslogUDP {
+		conn, err := net.ListenPacket("udp", w.cfg.Listen)
+		if err != nil {
+			return nil, err
+		}
+		context.AfterFunc(ctx, func() { conn.Close() })
+		go w.serveUDP(ctx, conn, packets)
+		return conn.LocalAddr(), nil
+	}
+
+	ln, err := net.Listen("tcp", w.cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+	if w.cfg.Protocol == config.SyslogTLS {
+		tlsConfig, err := w.tlsConfig()
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	context.AfterFunc(ctx, func() { ln.Close() })
+	go w.serveTCP(ctx, ln, packets)
+	return ln.Addr(), nil
+}
+
+// tlsConfig loads the server certificate and, if set, the client CAs
+func (w *SyslogWatcher) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(w.cfg.CertFile, w.cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if w.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(w.cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", w.cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// allowed reports whether messages from addr are accepted
+func (w *SyslogWatcher) allowed(addr netip.Addr) bool {
+	if len(w.allow) == 0 {
+		return true
+	}
+	addr = addr.Unmap()
+	for _, p := range w.allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// senderAddr returns the IP of a remote address
+func senderAddr(addr net.Addr) netip.Addr {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
+
+func (w *SyslogWatcher) serveUDP(ctx context.Context, conn net.PacketConn, packets chan<- syslogPacket) {
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		sender := senderAddr(from)
+		if !w.allowed(sender) {
+			continue
+		}
+		select {
+		case packets <- syslogPacket{data: string(buf[:n]), sender: sender.String()}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *SyslogWatcher) serveTCP(ctx context.Context, ln net.Listener, packets chan<- syslogPacket) {
+	conns := make(chan struct{}, w.maxConns)
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// Temporary failure such as running out of file descriptors
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		sender := senderAddr(conn.RemoteAddr())
+		if !w.allowed(sender) {
+			conn.Close()
+			continue
+		}
+		select {
+		case conns <- struct{}{}:
+		default:
+			log.Printf("Too many syslog connections, closing the one from %s", sender)
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer func() { <-conns }()
+			w.serveConn(ctx, conn, sender.String(), packets)
+		}()
+	}
+}
+
+// serveConn reads messages framed by octet counting ("42 <34>...") or
+// by newlines (RFC 6587) until the connection closes or is idle
+func (w *SyslogWatcher) serveConn(ctx context.Context, conn net.Conn, sender string, packets chan<- syslogPacket) {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	reader := bufio.NewReaderSize(conn, maxSyslogMessage)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(w.idleTimeout)); err != nil {
+			return
+		}
+		data, err := readSyslogFrame(reader)
+		if err != nil {
+			return
+		}
+		select {
+		case packets <- syslogPacket{data: data, sender: sender}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readSyslogFrame reads one message from a TCP stream
+func readSyslogFrame(r *bufio.Reader) (string, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return "", err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+		// Octet counting: "MSG-LEN SP SYSLOG-MSG"
+		// The length is read a byte at a time, so a sender cannot make us
+		// buffer an endless prefix
+		n := 0
+		for digits := 0; ; digits++ {
+			c, err := r.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			if c == ' ' {
+				break
+			}
+			if c < '0' || c > '9' || digits == maxFrameLengthDigits {
+				return "", errors.New("invalid frame length")
+			}
+			n = n*10 + int(c-'0')
+		}
+		if n > maxSyslogMessage {
+			return "", fmt.Errorf("frame length %d over %d bytes", n, maxSyslogMessage)
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+
+	// Non-transparent framing: one message per line
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("message longer than %d bytes", maxSyslogMessage)
+	}
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return "", err
+	}
+	return string(line), nil
+}
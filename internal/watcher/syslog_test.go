@@ -0,0 +1,376 @@
+package watcher
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/config"
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+func TestParseSyslogMessage(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		data     string
+		priority int
+		hostname string
+		app      string
+		pid      string
+		message  string
+		time     time.Time
+	}{
+		{
+			name:     "rfc3164",
+			data:     "<38>Oct 17 12:00:01 web1 sshd[123]: Accepted password for alice from 192.0.2.1 port 22 ssh2\n",
+			priority: 38, hostname: "web1", app: "sshd", pid: "123",
+			message: "Accepted password for alice from 192.0.2.1 port 22 ssh2",
+			time:    time.Date(2026, 10, 17, 12, 0, 1, 0, time.UTC),
+		},
+		{
+			name:     "rfc3164 without hostname",
+			data:     "<38>Oct 17 12:00:01 su: (to root) bob on pts/0",
+			priority: 38, app: "su", message: "(to root) bob on pts/0",
+			time: time.Date(2026, 10, 17, 12, 0, 1, 0, time.UTC),
+		},
+		{
+			name:     "rfc3164 with iso timestamp",
+			data:     "<86>2026-10-17T12:00:01+02:00 fw1 login[7]: LOGIN ON tty1 BY root",
+			priority: 86, hostname: "fw1", app: "login", pid: "7", message: "LOGIN ON tty1 BY root",
+			time: time.Date(2026, 10, 17, 10, 0, 1, 0, time.UTC),
+		},
+		{
+			name:     "no priority or timestamp",
+			data:     "switch1 sshd[9]: Failed password for admin from 192.0.2.9 port 4000 ssh2",
+			priority: -1, hostname: "switch1", app: "sshd", pid: "9",
+			message: "Failed password for admin from 192.0.2.9 port 4000 ssh2",
+			time:    now,
+		},
+		{
+			name:     "rfc5424",
+			data:     `<86>1 2026-10-17T12:00:01.5Z db1 sshd 4242 - [origin ip="10.0.0.5"][meta x="a\]b"] ` + "\ufeff" + "Accepted publickey for bob from 2001:db8::1 port 50000 ssh2",
+			priority: 86, hostname: "db1", app: "sshd", pid: "4242",
+			message: "Accepted publickey for bob from 2001:db8::1 port 50000 ssh2",
+			time:    time.Date(2026, 10, 17, 12, 0, 1, 500000000, time.UTC),
+		},
+		{
+			name:     "rfc5424 nil values",
+			data:     "<13>1 - - app - ID47 - hello",
+			priority: 13, app: "app", message: "hello",
+			time: now,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, ok := parseSyslogMessage(tt.data, now)
+			if !ok {
+				t.Fatal("Expected message to parse")
+			}
+			if msg.Priority != tt.priority || msg.Hostname != tt.hostname || msg.AppName != tt.app || msg.ProcID != tt.pid {
+				t.Errorf("Expected <%d> %s %s[%s], got <%d> %s %s[%s]", tt.priority, tt.hostname, tt.app, tt.pid,
+					msg.Priority, msg.Hostname, msg.AppName, msg.ProcID)
+			}
+			if msg.Message != tt.message {
+				t.Errorf("Expected message '%s', got '%s'", tt.message, msg.Message)
+			}
+			if !msg.Timestamp.Equal(tt.time) {
+				t.Errorf("Expected time %v, got %v", tt.time, msg.Timestamp)
+			}
+		})
+	}
+
+	if _, ok := parseSyslogMessage("<999>bad", now); ok {
+		t.Error("Expected invalid priority to be rejected")
+	}
+}
+
+func TestSyslogReceiverHostname(t *testing.T) {
+	receiver := newSyslogReceiver(defaultAuthLogRules)
+	now := time.Now()
+
+	msg, _ := parseSyslogMessage("<38>Oct 17 12:00:01 web1 sshd[1]: Accepted password for alice from 192.0.2.1 port 22 ssh2", now)
+	event := receiver.handle(msg, "10.0.0.2")
+	if event == nil {
+		t.Fatal("Expected an event")
+	}
+	if event.Hostname != "web1" || event.Username != "alice" || event.IP != "192.0.2.1" {
+		t.Errorf("Unexpected event: %s@%s from %s", event.Username, event.Hostname, event.IP)
+	}
+	if event.Fields[notifier.FieldSender] != "10.0.0.2" {
+		t.Errorf("Expected sender '10.0.0.2', got '%s'", event.Fields[notifier.FieldSender])
+	}
+	if event.OS != "" {
+		t.Errorf("Expected unknown OS, got '%s'", event.OS)
+	}
+
+	// Without a hostname in the header the sender identifies the host
+	msg, _ = parseSyslogMessage("<38>Oct 17 12:00:01 su: (to root) bob on pts/0", now)
+	event = receiver.handle(msg, "10.0.0.3")
+	if event == nil || event.Hostname != "10.0.0.3" || event.Kind != notifier.KindPrivilegeEscalation {
+		t.Fatalf("Expected escalation on 10.0.0.3, got %+v", event)
+	}
+	if _, ok := event.Fields[notifier.FieldSender]; ok {
+		t.Error("Expected no sender field when it is the host itself")
+	}
+
+	msg, _ = parseSyslogMessage("<38>Oct 17 12:00:01 web1 cron[5]: (root) CMD (true)", now)
+	if event := receiver.handle(msg, "10.0.0.2"); event != nil {
+		t.Errorf("Expected no event, got %+v", event)
+	}
+}
+
+func TestSyslogWatcherUDP(t *testing.T) {
+	w, err := NewSyslogWatcher(config.SyslogConfig{Listen: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	packets := make(chan syslogPacket, 1)
+	addr, err := w.listen(ctx, packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("udp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("<38>Oct 17 12:00:01 web1 sshd[1]: hello"))
+
+	p := receivePacket(t, packets)
+	if p.data != "<38>Oct 17 12:00:01 web1 sshd[1]: hello" || p.sender != "127.0.0.1" {
+		t.Errorf("Unexpected packet %+v", p)
+	}
+}
+
+func TestSyslogWatcherTCPFraming(t *testing.T) {
+	w, err := NewSyslogWatcher(config.SyslogConfig{Listen: "127.0.0.1:0", Protocol: config.SyslogTCP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	packets := make(chan syslogPacket, 3)
+	addr, err := w.listen(ctx, packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Octet counted frames may contain newlines
+	conn.Write([]byte("13 <13>1 - - a\nb5 <13>x<13>line one\n<13>line two\n"))
+	conn.Close()
+
+	for _, want := range []string{"<13>1 - - a\nb", "<13>x", "<13>line one\n", "<13>line two\n"} {
+		if p := receivePacket(t, packets); p.data != want {
+			t.Errorf("Expected frame %q, got %q", want, p.data)
+		}
+	}
+}
+
+func TestReadSyslogFrameLength(t *testing.T) {
+	tests := []struct {
+		input string
+		ok    bool
+	}{
+		{"5 <13>x", true},
+		{"65536 " + strings.Repeat("x", 65536), true},
+		{"65537 x", false},
+		{"1x <13>x", false},
+		// An endless prefix is rejected without reading it all
+		{strings.Repeat("9", 1<<20), false},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReaderSize(strings.NewReader(tt.input), maxSyslogMessage)
+		_, err := readSyslogFrame(r)
+		if (err == nil) != tt.ok {
+			t.Errorf("readSyslogFrame(%.20q) error = %v, want ok %v", tt.input, err, tt.ok)
+		}
+		if !tt.ok && r.Buffered() > maxSyslogMessage {
+			t.Errorf("Expected a bounded read, %d bytes buffered", r.Buffered())
+		}
+	}
+}
+
+func TestSyslogWatcherConnectionLimits(t *testing.T) {
+	w, err := NewSyslogWatcher(config.SyslogConfig{Listen: "127.0.0.1:0", Protocol: config.SyslogTCP})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.idleTimeout = 100 * time.Millisecond
+	w.maxConns = 1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	packets := make(chan syslogPacket, 1)
+	addr, err := w.listen(ctx, packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idle, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idle.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	// Over the limit: closed right away
+	extra, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer extra.Close()
+	extra.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := extra.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the extra connection to be closed, got %v", err)
+	}
+
+	// Idle: closed after the timeout, freeing its slot
+	idle.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := idle.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected the idle connection to be closed, got %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("<13>after\n"))
+	if p := receivePacket(t, packets); p.data != "<13>after\n" {
+		t.Errorf("Unexpected packet %+v", p)
+	}
+}
+
+func TestSyslogWatcherAllow(t *testing.T) {
+	w, err := NewSyslogWatcher(config.SyslogConfig{Listen: "127.0.0.1:0", Protocol: config.SyslogTCP, Allow: []string{"192.0.2.0/24"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	packets := make(chan syslogPacket, 1)
+	addr, err := w.listen(ctx, packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("<13>denied\n"))
+
+	select {
+	case p := <-packets:
+		t.Errorf("Expected sender to be rejected, got %+v", p)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestSyslogWatcherTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCert(t, dir)
+
+	w, err := NewSyslogWatcher(config.SyslogConfig{
+		Listen:   "127.0.0.1:0",
+		Protocol: config.SyslogTLS,
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	packets := make(chan syslogPacket, 1)
+	addr, err := w.listen(ctx, packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := tls.Dial("tcp", addr.String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("<13>secure\n"))
+
+	if p := receivePacket(t, packets); p.data != "<13>secure\n" {
+		t.Errorf("Unexpected packet %+v", p)
+	}
+
+	bad := &SyslogWatcher{cfg: config.SyslogConfig{Listen: "127.0.0.1:0", Protocol: config.SyslogTLS, CertFile: "/nonexistent", KeyFile: keyFile}}
+	if _, err := bad.listen(ctx, packets); err == nil {
+		t.Error("Expected an error for a missing certificate")
+	}
+}
+
+func receivePacket(t *testing.T, packets <-chan syslogPacket) syslogPacket {
+	t.Helper()
+	select {
+	case p := <-packets:
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a message")
+	}
+	return syslogPacket{}
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
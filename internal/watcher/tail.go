@@ -0,0 +1,268 @@
+package watcher
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tailPollInterval is how often the polling fallback checks for new data
+const tailPollInterval = 250 * time.Millisecond
+
+// tailRescanInterval is how often the event-driven tailer re-checks the
+// file even without events, in case a notification was lost
+const tailRescanInterval = 30 * time.Second
+
+// fileNotifier wakes the tailer when the followed file may have changed
+type fileNotifier interface {
+	// Events signals (coalesced) that the file or its directory changed
+	Events() <-chan struct{}
+	// Rewatch moves the per-file watch to the file now at path
+	Rewatch(path string) error
+	Close() error
+}
+
+// tailer follows a log file like `tail -F`: it reads appended lines,
+// detects truncation, and on rotation drains the old file fully before
+// switching to the new one
+type tailer struct {
+	path    string
+	file    *os.File
+	reader  *bufio.Reader
+	info    os.FileInfo // identity of the open file
+	offset  int64       // bytes of complete lines consumed from file
+	partial []byte      // unterminated last line, held until its newline arrives
+	last    string      // last line consumed, for checkpoints
+	// forcePoll disables event notification (used by tests)
+	forcePoll bool
+}
+
+func newTailer(path string) *tailer {
+	return &tailer{path: path}
+}
+
+// open opens the file at path and seeks to offset, or to the end if
+// offset is negative
+func (t *tailer) open(offset int64) error {
+	return t.openFile(t.path, offset)
+}
+
+// openFile is like open but reads name, which may be a rotated copy of
+// path; the next check then drains it and moves on to path
+func (t *tailer) openFile(name string, offset int64) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if offset < 0 || offset > info.Size() {
+		offset = info.Size()
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+	// The line before offset, so that a checkpoint taken before reading
+	// anything can be resumed
+	last, err := lastLineBefore(file, offset)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	t.close()
+	t.file = file
+	t.reader = bufio.NewReader(file)
+	t.info = info
+	t.offset = offset
+	t.partial = nil
+	t.last = string(last)
+	return nil
+}
+
+// resume reopens the file a checkpoint was taken from and seeks to the
+// checkpoint offset
+// The file is looked up by inode, including rotated copies (path.1,
+// path-YYYYMMDD), so lines written before a rotation while we were down
+// are not lost; the hash of the line before the offset must match, so a
+// file rewritten in place is not resumed at a bogus position
+// Returns false if no matching file was found
+func (t *tailer) resume(cp Checkpoint) bool {
+	if cp.Inode == 0 {
+		return false
+	}
+
+	candidates := []string{t.path, t.path + ".1"}
+	if rotated, err := filepath.Glob(t.path + "-*"); err == nil {
+		candidates = append(candidates, rotated...)
+	}
+
+	for _, name := range candidates {
+		info, err := os.Stat(name)
+		if err != nil || getInode(info) != cp.Inode || info.Size() < cp.Offset {
+			continue
+		}
+
+		file, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		last, err := lastLineBefore(file, cp.Offset)
+		file.Close()
+		if err != nil || hashBytes(last) != cp.LastHash {
+			continue
+		}
+
+		if t.openFile(name, cp.Offset) != nil {
+			continue
+		}
+		t.last = string(last)
+		return true
+	}
+	return false
+}
+
+// checkpoint returns the position after the last consumed line
+func (t *tailer) checkpoint() Checkpoint {
+	if t.info == nil {
+		return Checkpoint{}
+	}
+	return Checkpoint{
+		Inode:    getInode(t.info),
+		Offset:   t.offset,
+		LastHash: hashBytes([]byte(t.last)),
+	}
+}
+
+func (t *tailer) close() {
+	if t.file != nil {
+		t.file.Close()
+		t.file = nil
+	}
+}
+
+// drain passes every complete line available to fn
+// If final is set the unterminated last line is passed too, since no
+// more data will be appended to this file
+func (t *tailer) drain(final bool, fn func(line string)) {
+	if t.file == nil {
+		return
+	}
+	for {
+		chunk, err := t.reader.ReadBytes('\n')
+		if len(chunk) > 0 {
+			t.partial = append(t.partial, chunk...)
+		}
+		if err != nil {
+			break
+		}
+		line := strings.TrimRight(string(t.partial), "\r\n")
+		t.offset += int64(len(t.partial))
+		t.partial = t.partial[:0]
+		t.last = line
+		fn(line)
+	}
+	if final && len(t.partial) > 0 {
+		line := strings.TrimRight(string(t.partial), "\r\n")
+		t.offset += int64(len(t.partial))
+		t.partial = t.partial[:0]
+		t.last = line
+		fn(line)
+	}
+}
+
+// check handles truncation and rotation
+// Returns true if a new file was opened, or an error if the file can no
+// longer be read
+func (t *tailer) check(fn func(line string)) (bool, error) {
+	info, err := os.Stat(t.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Rotated away and not recreated yet: keep reading the old file
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if t.file == nil {
+		// File appeared after we started: read it from the beginning
+		err := t.open(0)
+		return err == nil, err
+	}
+
+	if !os.SameFile(info, t.info) {
+		// Rotated: finish the old file before switching
+		t.drain(true, fn)
+		err := t.open(0)
+		return err == nil, err
+	}
+
+	if info.Size() < t.offset {
+		// Truncated in place (copytruncate)
+		if _, err := t.file.Seek(0, io.SeekStart); err == nil {
+			t.reader.Reset(t.file)
+			t.offset = 0
+			t.partial = nil
+		}
+	}
+	return false, nil
+}
+
+// follow reads lines until ctx is cancelled, or the file can no longer be
+// read
+// It uses filesystem notifications where available and falls back to polling
+func (t *tailer) follow(ctx context.Context, fn func(line string)) error {
+	defer t.close()
+
+	var watch fileNotifier
+	if !t.forcePoll {
+		if n, err := newFileNotifier(t.path); err == nil {
+			watch = n
+			defer n.Close()
+		}
+	}
+
+	interval := tailPollInterval
+	var events <-chan struct{}
+	if watch != nil {
+		interval = tailRescanInterval
+		events = watch.Events()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		t.drain(false, fn)
+		opened, err := t.check(fn)
+		if err != nil {
+			return err
+		}
+		if opened {
+			if watch != nil {
+				watch.Rewatch(t.path)
+			}
+			t.drain(false, fn)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-events:
+		case <-ticker.C:
+		}
+	}
+}
+
+// errNotifyUnsupported is returned where filesystem notifications are unavailable
+var errNotifyUnsupported = errors.New("file notifications not supported")
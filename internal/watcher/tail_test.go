@@ -0,0 +1,179 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lineCollector gathers lines delivered by a tailer
+type lineCollector struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (c *lineCollector) add(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, line)
+}
+
+// waitFor waits until n lines have been collected and returns them
+func (c *lineCollector) waitFor(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		if len(c.lines) >= n {
+			lines := append([]string(nil), c.lines...)
+			c.mu.Unlock()
+			return lines
+		}
+		c.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.Fatalf("Timed out waiting for %d lines, got %d: %q", n, len(c.lines), c.lines)
+	return nil
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testTailerRotation(t *testing.T, forcePoll bool) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "auth.log")
+	appendFile(t, path, "old line before start\n")
+
+	tail := newTailer(path)
+	tail.forcePoll = forcePoll
+	if err := tail.open(-1); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var c lineCollector
+	done := make(chan struct{})
+	go func() {
+		tail.follow(ctx, c.add)
+		close(done)
+	}()
+
+	appendFile(t, path, "one\n")
+	c.waitFor(t, 1)
+
+	// A line written in two parts is delivered once, whole
+	appendFile(t, path, "tw")
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, "o\n")
+	c.waitFor(t, 2)
+
+	// Rotate: the writer keeps appending to the renamed file until it
+	// reopens, and those lines must not be lost
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", "three\n")
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, "four\n")
+	c.waitFor(t, 4)
+
+	// Truncate in place (copytruncate)
+	// Give the poller a chance to see the file shrink before it regrows
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * tailPollInterval)
+	appendFile(t, path, "five\n")
+	lines := c.waitFor(t, 5)
+
+	cancel()
+	<-done
+
+	want := []string{"one", "two", "three", "four", "five"}
+	for i, w := range want {
+		if lines[i] != w {
+			t.Errorf("line[%d] = %q, want %q", i, lines[i], w)
+		}
+	}
+}
+
+func TestTailerNotify(t *testing.T) {
+	testTailerRotation(t, false)
+}
+
+func TestTailerPoll(t *testing.T) {
+	testTailerRotation(t, true)
+}
+
+func TestTailerFileCreatedLater(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secure")
+
+	tail := newTailer(path)
+	if err := tail.open(-1); err == nil {
+		t.Fatal("Expected open to fail for a missing file")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var c lineCollector
+	go tail.follow(ctx, c.add)
+
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, "first\n")
+	if lines := c.waitFor(t, 1); lines[0] != "first" {
+		t.Errorf("Expected 'first', got %q", lines[0])
+	}
+}
+
+func TestTailerFollowFails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "log")
+	path := filepath.Join(dir, "auth.log")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "first\n")
+
+	tail := newTailer(path)
+	tail.forcePoll = true
+	if err := tail.open(-1); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		var c lineCollector
+		done <- tail.follow(context.Background(), c.add)
+	}()
+
+	// The directory is replaced by a file: the path can no longer be read
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dir, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected follow to fail")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected follow to return")
+	}
+}
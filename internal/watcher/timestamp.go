@@ -0,0 +1,85 @@
+package watcher
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// isoLayouts are the ISO8601 variants written by rsyslog (high precision
+// format) and `journalctl -o short-iso-precise`, which omits the colon
+// in the zone offset
+var isoLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+}
+
+// parseLogTimestamp parses the timestamp at the start of a log line
+// Supported formats:
+//   - classic syslog: "Oct 17 12:00:01" (year inferred from now)
+//   - RFC3339/ISO8601: "2026-10-17T12:00:01.123456+08:00"
+//   - macOS compact style: "2026-10-17 12:00:01.123456" (local time)
+//
+// Fractional seconds are accepted in all formats
+func parseLogTimestamp(line string, now time.Time) (time.Time, bool) {
+	line = strings.TrimLeft(line, " ")
+
+	// Classic syslog timestamp has no year
+	if len(line) >= len(time.Stamp) {
+		if t, err := time.ParseInLocation(time.Stamp, line[:len(time.Stamp)], now.Location()); err == nil {
+			return inferYear(t, now), true
+		}
+	}
+
+	field, rest, _ := strings.Cut(line, " ")
+
+	for _, layout := range isoLayouts {
+		if t, err := time.Parse(layout, field); err == nil {
+			return t, true
+		}
+	}
+
+	// Date and time separated by a space, no zone
+	clock, _, _ := strings.Cut(rest, " ")
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", field+" "+clock, now.Location()); err == nil {
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// inferYear places a year-less syslog timestamp in the year that makes it
+// closest to now, so that "Dec 31" lines read on Jan 1 land in last year
+func inferYear(t time.Time, now time.Time) time.Time {
+	t = time.Date(now.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), now.Location())
+	// Allow a day of clock skew before deciding the line is from last year
+	if t.After(now.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t
+}
+
+// parseJournalTimestamp parses a journal __REALTIME_TIMESTAMP value
+// (microseconds since the Unix epoch)
+func parseJournalTimestamp(usec string) (time.Time, bool) {
+	v, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(v), true
+}
+
+// historyClock dates lines read back from a log, where a line without its
+// own timestamp (such as a continuation line) was logged with the one before
+type historyClock struct {
+	last time.Time
+}
+
+// timestamp returns the time line was logged, and false if neither it nor
+// any line before it has a timestamp
+func (c *historyClock) timestamp(line string) (time.Time, bool) {
+	if t, ok := parseLogTimestamp(line, time.Now()); ok {
+		c.last = t
+	}
+	return c.last, !c.last.IsZero()
+}
@@ -0,0 +1,126 @@
+package watcher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLogTimestamp(t *testing.T) {
+	cst := time.FixedZone("CST", 8*3600)
+	now := time.Date(2026, 10, 17, 12, 30, 0, 0, cst)
+
+	tests := []struct {
+		name string
+		line string
+		want time.Time
+		ok   bool
+	}{
+		{
+			name: "classic syslog",
+			line: "Oct 17 12:00:01 host sshd[123]: Accepted password for alice from 10.0.0.1 port 22 ssh2",
+			want: time.Date(2026, 10, 17, 12, 0, 1, 0, cst),
+			ok:   true,
+		},
+		{
+			name: "classic syslog padded day",
+			line: "Oct  7 08:15:00 host login[1]: LOGIN ON tty1 BY bob",
+			want: time.Date(2026, 10, 7, 8, 15, 0, 0, cst),
+			ok:   true,
+		},
+		{
+			name: "rfc3339 high precision",
+			line: "2026-10-17T12:00:01.123456+08:00 host sshd[123]: message",
+			want: time.Date(2026, 10, 17, 12, 0, 1, 123456000, cst),
+			ok:   true,
+		},
+		{
+			name: "rfc3339 utc",
+			line: "2026-10-17T04:00:01Z host sshd[123]: message",
+			want: time.Date(2026, 10, 17, 4, 0, 1, 0, time.UTC),
+			ok:   true,
+		},
+		{
+			name: "journalctl short-iso-precise",
+			line: "2026-10-17T12:00:01.500000+0800 host sshd[123]: message",
+			want: time.Date(2026, 10, 17, 12, 0, 1, 500000000, cst),
+			ok:   true,
+		},
+		{
+			name: "macos compact",
+			line: "2026-10-17 12:00:01.250 Df sshd[123:456] message",
+			want: time.Date(2026, 10, 17, 12, 0, 1, 250000000, cst),
+			ok:   true,
+		},
+		{
+			name: "no timestamp",
+			line: "sshd[123]: Accepted password for alice",
+			ok:   false,
+		},
+		{
+			name: "empty",
+			line: "",
+			ok:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseLogTimestamp(tt.line, now)
+			if ok != tt.ok {
+				t.Fatalf("parseLogTimestamp() ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && !got.Equal(tt.want) {
+				t.Errorf("parseLogTimestamp() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInferYearAcrossNewYear(t *testing.T) {
+	now := time.Date(2027, 1, 1, 0, 5, 0, 0, time.UTC)
+
+	got, ok := parseLogTimestamp("Dec 31 23:59:58 host sshd[1]: message", now)
+	if !ok {
+		t.Fatal("parseLogTimestamp() failed")
+	}
+	want := time.Date(2026, 12, 31, 23, 59, 58, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	got, _ = parseLogTimestamp("Jan  1 00:04:00 host sshd[1]: message", now)
+	want = time.Date(2027, 1, 1, 0, 4, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestParseJournalTimestamp(t *testing.T) {
+	got, ok := parseJournalTimestamp("1760702401123456")
+	if !ok {
+		t.Fatal("parseJournalTimestamp() failed")
+	}
+	if got.UnixMicro() != 1760702401123456 {
+		t.Errorf("Expected 1760702401123456, got %d", got.UnixMicro())
+	}
+
+	if _, ok := parseJournalTimestamp("not-a-number"); ok {
+		t.Error("Expected failure for invalid timestamp")
+	}
+}
+
+func TestHistoryClock(t *testing.T) {
+	var clock historyClock
+
+	if _, ok := clock.timestamp("login ok for alice"); ok {
+		t.Error("Expected no timestamp before the first dated line")
+	}
+
+	want := time.Date(2026, 10, 17, 12, 0, 1, 0, time.UTC)
+	if got, ok := clock.timestamp("2026-10-17T12:00:01Z app: login ok for bob"); !ok || !got.Equal(want) {
+		t.Errorf("Expected %v, got %v (%v)", want, got, ok)
+	}
+	if got, ok := clock.timestamp("login ok for carol"); !ok || !got.Equal(want) {
+		t.Errorf("Expected the previous timestamp %v, got %v (%v)", want, got, ok)
+	}
+}
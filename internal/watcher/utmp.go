@@ -0,0 +1,209 @@
+package watcher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// utmpRecordSize is the size of a glibc struct utmp on 64-bit Linux
+// (x86_64, arm64), which keeps 32-bit timestamps for compatibility
+const utmpRecordSize = 384
+
+// Field offsets within a glibc struct utmp record
+const (
+	utmpOffType    = 0   // int16 ut_type (+2 bytes padding)
+	utmpOffPID     = 4   // int32 ut_pid
+	utmpOffLine    = 8   // char ut_line[32]
+	utmpOffID      = 40  // char ut_id[4]
+	utmpOffUser    = 44  // char ut_user[32]
+	utmpOffHost    = 76  // char ut_host[256]
+	utmpOffSession = 336 // int32 ut_session (after int16 ut_exit[2])
+	utmpOffTvSec   = 340 // int32 ut_tv.tv_sec
+	utmpOffTvUsec  = 344 // int32 ut_tv.tv_usec
+	utmpOffAddr    = 348 // int32 ut_addr_v6[4]
+)
+
+// utmpType is the ut_type of a utmp record
+type utmpType int16
+
+// Record types from <utmp.h> that whozere acts on
+const (
+	utBootTime    utmpType = 2
+	utUserProcess utmpType = 7
+	utDeadProcess utmpType = 8
+)
+
+// utmpRecord is a decoded utmp/wtmp/btmp entry
+type utmpRecord struct {
+	Type    utmpType
+	PID     int32
+	Line    string // device name without /dev/ (e.g. pts/0, ssh:notty)
+	ID      string // inittab ID or terminal suffix
+	User    string
+	Host    string // remote host name, or kernel version for boot records
+	Session int32
+	Time    time.Time
+	Addr    netip.Addr // remote address, invalid if not recorded
+}
+
+// decodeUtmpRecord decodes a single record in the given byte order
+func decodeUtmpRecord(b []byte, order binary.ByteOrder) (utmpRecord, error) {
+	if len(b) < utmpRecordSize {
+		return utmpRecord{}, fmt.Errorf("utmp: short record (%d bytes)", len(b))
+	}
+
+	rec := utmpRecord{
+		Type:    utmpType(int16(order.Uint16(b[utmpOffType:]))),
+		PID:     int32(order.Uint32(b[utmpOffPID:])),
+		Line:    cString(b[utmpOffLine:utmpOffID]),
+		ID:      cString(b[utmpOffID:utmpOffUser]),
+		User:    cString(b[utmpOffUser:utmpOffHost]),
+		Host:    cString(b[utmpOffHost : utmpOffHost+256]),
+		Session: int32(order.Uint32(b[utmpOffSession:])),
+		Time: time.Unix(
+			int64(int32(order.Uint32(b[utmpOffTvSec:]))),
+			int64(int32(order.Uint32(b[utmpOffTvUsec:])))*int64(time.Microsecond),
+		),
+	}
+
+	// ut_addr_v6 holds an IPv4 address in its first word only;
+	// the words are stored in network byte order
+	var addr [16]byte
+	copy(addr[:], b[utmpOffAddr:utmpOffAddr+16])
+	switch {
+	case addr == [16]byte{}:
+	case bytes.Equal(addr[4:], make([]byte, 12)):
+		rec.Addr = netip.AddrFrom4([4]byte{addr[0], addr[1], addr[2], addr[3]})
+	default:
+		rec.Addr = netip.AddrFrom16(addr).Unmap()
+	}
+
+	return rec, nil
+}
+
+// readUtmpRecords decodes all complete records from r
+// A trailing partial record (file still being written) is ignored
+func readUtmpRecords(r io.Reader, order binary.ByteOrder) ([]utmpRecord, error) {
+	var records []utmpRecord
+	buf := make([]byte, utmpRecordSize)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return records, nil
+			}
+			return records, err
+		}
+		rec, err := decodeUtmpRecord(buf, order)
+		if err != nil {
+			return records, err
+		}
+		records = append(records, rec)
+	}
+}
+
+// cString returns the NUL-terminated string at the start of b
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// utmpParser turns wtmp/btmp records into events, pairing
+// DEAD_PROCESS records with the login on the same line
+// Not safe for concurrent use
+type utmpParser struct {
+	hostname string
+	// failures marks a btmp file, where every record is a failed attempt
+	failures bool
+	sessions map[string]notifier.LoginEvent
+}
+
+func newUtmpParser(hostname string, failures bool) *utmpParser {
+	return &utmpParser{
+		hostname: hostname,
+		failures: failures,
+		sessions: make(map[string]notifier.LoginEvent),
+	}
+}
+
+// parse returns the event described by rec, or nil
+func (p *utmpParser) parse(rec utmpRecord) *notifier.LoginEvent {
+	if p.failures {
+		if rec.User == "" {
+			return nil
+		}
+		event := p.event(rec)
+		event.Kind = notifier.KindFailedAuth
+		event.Detail = "Authentication failure"
+		return &event
+	}
+
+	switch rec.Type {
+	case utUserProcess:
+		if rec.User == "" {
+			return nil
+		}
+		event := p.event(rec)
+		event.Kind = notifier.KindLogin
+		p.sessions[rec.Line] = p.event(rec)
+		return &event
+
+	case utDeadProcess:
+		login, ok := p.sessions[rec.Line]
+		if !ok {
+			return nil
+		}
+		delete(p.sessions, rec.Line)
+
+		duration := rec.Time.Sub(login.Timestamp)
+		if duration < 0 {
+			duration = 0
+		}
+		logout := login
+		logout.Kind = notifier.KindLogout
+		logout.Timestamp = rec.Time
+		logout.Detail = "Session lasted " + duration.Round(time.Second).String()
+		logout.SetField(notifier.FieldDuration, duration.Round(time.Second).String())
+		return &logout
+
+	case utBootTime:
+		// Sessions do not survive a reboot and never get a DEAD_PROCESS record
+		p.sessions = make(map[string]notifier.LoginEvent)
+	}
+
+	return nil
+}
+
+// event builds the common part of an event from rec
+func (p *utmpParser) event(rec utmpRecord) notifier.LoginEvent {
+	event := notifier.LoginEvent{
+		Username:  rec.User,
+		Hostname:  p.hostname,
+		Terminal:  rec.Line,
+		Timestamp: rec.Time,
+		OS:        "linux",
+	}
+	// sshd records failed attempts as "ssh:notty"
+	if strings.HasPrefix(rec.Line, "ssh:") {
+		event.Terminal = "ssh"
+	}
+	if rec.Addr.IsValid() && !rec.Addr.IsUnspecified() {
+		event.IP = rec.Addr.String()
+	} else if rec.Host != "" && !strings.HasPrefix(rec.Host, ":") {
+		// X11 displays (":0") are not remote hosts
+		event.IP = rec.Host
+	}
+	if rec.PID > 0 {
+		event.SetField(notifier.FieldPID, strconv.Itoa(int(rec.PID)))
+	}
+	return event
+}
@@ -0,0 +1,226 @@
+//go:build linux
+
+package watcher
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// Default locations of the binary login accounting files
+const (
+	wtmpFile = "/var/log/wtmp" // logins, logouts and boots
+	btmpFile = "/var/log/btmp" // failed login attempts
+)
+
+// UtmpWatcher watches the binary wtmp and btmp files for login events
+// It works even where auth logs are disabled, since login, sshd and
+// display managers write these records directly
+type UtmpWatcher struct {
+	hostname string
+	wtmpFile string
+	btmpFile string
+}
+
+// Name returns the watcher name
+func (w *UtmpWatcher) Name() string {
+	return "wtmp"
+}
+
+// Watch monitors wtmp/btmp for login events (new events only)
+func (w *UtmpWatcher) Watch(ctx context.Context, events chan<- notifier.LoginEvent) error {
+	return w.WatchWithOptions(ctx, events, Options{})
+}
+
+// WatchWithOptions monitors wtmp/btmp with specific options
+func (w *UtmpWatcher) WatchWithOptions(ctx context.Context, events chan<- notifier.LoginEvent, opts Options) error {
+	var cutoff time.Time
+	if opts.Since > 0 {
+		cutoff = time.Now().Add(-opts.Since)
+	}
+
+	followers := []*utmpFollower{
+		newUtmpFollower(w.wtmpFile, newUtmpParser(w.hostname, false), opts.Since > 0),
+		newUtmpFollower(w.btmpFile, newUtmpParser(w.hostname, true), opts.Since > 0),
+	}
+	for _, f := range followers {
+		if cp, ok := opts.getCheckpoint(w.source(f)); ok {
+			f.resume = &cp
+		}
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		for _, f := range followers {
+			offset, started := f.offset, f.started
+			polled := f.poll(cutoff)
+			for _, event := range polled {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			// Save the starting position durably as well, so that records
+			// written before the first event survive a restart
+			opened := f.started && !started
+			if f.offset != offset || opened {
+				opts.Checkpoints.Update(opts.checkpointKey(w.source(f)), f.checkpoint(), len(polled) > 0 || opened)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// source names a follower in the checkpoint store
+func (w *UtmpWatcher) source(f *utmpFollower) string {
+	return w.Name() + ":" + f.path
+}
+
+// utmpFollower reads records appended to a utmp-format file,
+// starting over when the file is rotated or truncated
+type utmpFollower struct {
+	path    string
+	parser  *utmpParser
+	offset  int64
+	inode   uint64
+	started bool
+	// fromStart reads existing records on first poll (history replay)
+	fromStart bool
+	// resume is the checkpoint to continue from on first poll, if any
+	resume  *Checkpoint
+	resumed bool
+	// lastHash is the hash of the last record read, for checkpoints
+	lastHash string
+}
+
+func newUtmpFollower(path string, parser *utmpParser, fromStart bool) *utmpFollower {
+	return &utmpFollower{path: path, parser: parser, fromStart: fromStart}
+}
+
+// poll returns events for records written since the last poll
+// Records older than cutoff still update session state but are not returned
+func (f *utmpFollower) poll(cutoff time.Time) []notifier.LoginEvent {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil
+	}
+	size := info.Size() - info.Size()%utmpRecordSize
+	inode := getInode(info)
+
+	if !f.started {
+		f.started = true
+		f.inode = inode
+		switch {
+		case f.resumeAt(file, inode, size):
+			// Continue after the last record read by the previous run
+			f.resumed = true
+		case !f.fromStart:
+			// Only watch new records
+			f.offset = size
+			f.lastHash = recordHash(file, size)
+			return nil
+		}
+	}
+	if f.resumed {
+		// The checkpoint covers the downtime exactly, so cutoff does not apply
+		cutoff = time.Time{}
+	}
+
+	// Rotated (new file) or truncated: read the new file from the start
+	if inode != f.inode || size < f.offset {
+		f.inode = inode
+		f.offset = 0
+	}
+	if size == f.offset {
+		return nil
+	}
+
+	records, err := readUtmpRecords(io.NewSectionReader(file, f.offset, size-f.offset), binary.NativeEndian)
+	f.offset += int64(len(records)) * utmpRecordSize
+	if len(records) > 0 {
+		f.lastHash = recordHash(file, f.offset)
+	}
+
+	var events []notifier.LoginEvent
+	for _, rec := range records {
+		if event := f.parser.parse(rec); event != nil && !event.Timestamp.Before(cutoff) {
+			events = append(events, *event)
+		}
+	}
+	return events
+}
+
+// resumeAt moves to the checkpoint offset if the checkpoint belongs to
+// the open file and the record before it is unchanged
+func (f *utmpFollower) resumeAt(file io.ReaderAt, inode uint64, size int64) bool {
+	cp := f.resume
+	f.resume = nil
+	if cp == nil || cp.Inode != inode || cp.Offset > size || cp.Offset%utmpRecordSize != 0 {
+		return false
+	}
+	if recordHash(file, cp.Offset) != cp.LastHash {
+		return false
+	}
+	f.offset = cp.Offset
+	f.lastHash = cp.LastHash
+	return true
+}
+
+// checkpoint returns the position after the last record read
+func (f *utmpFollower) checkpoint() Checkpoint {
+	return Checkpoint{Inode: f.inode, Offset: f.offset, LastHash: f.lastHash}
+}
+
+// recordHash hashes the record that ends at offset
+func recordHash(file io.ReaderAt, offset int64) string {
+	if offset < utmpRecordSize {
+		return hashBytes(nil)
+	}
+	buf := make([]byte, utmpRecordSize)
+	if _, err := file.ReadAt(buf, offset-utmpRecordSize); err != nil {
+		return ""
+	}
+	return hashBytes(buf)
+}
+
+// readWtmpHistory returns wtmp login events at or after cutoff
+func readWtmpHistory(path, hostname string, cutoff time.Time) ([]notifier.LoginEvent, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := readUtmpRecords(file, binary.NativeEndian)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := newUtmpParser(hostname, false)
+	var events []notifier.LoginEvent
+	for _, rec := range records {
+		if event := parser.parse(rec); event != nil && !event.Timestamp.Before(cutoff) {
+			events = append(events, *event)
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,137 @@
+package watcher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/xsddz/whozere/internal/notifier"
+)
+
+// encodeUtmpRecord builds a glibc struct utmp record for tests
+func encodeUtmpRecord(typ utmpType, pid int32, line, user, host string, at time.Time, addr netip.Addr) []byte {
+	b := make([]byte, utmpRecordSize)
+	order := binary.LittleEndian
+	order.PutUint16(b[utmpOffType:], uint16(typ))
+	order.PutUint32(b[utmpOffPID:], uint32(pid))
+	copy(b[utmpOffLine:utmpOffID], line)
+	copy(b[utmpOffUser:utmpOffHost], user)
+	copy(b[utmpOffHost:utmpOffHost+256], host)
+	order.PutUint32(b[utmpOffTvSec:], uint32(at.Unix()))
+	order.PutUint32(b[utmpOffTvUsec:], uint32(at.Nanosecond()/1000))
+	if addr.Is4() {
+		a := addr.As4()
+		copy(b[utmpOffAddr:], a[:])
+	} else if addr.IsValid() {
+		a := addr.As16()
+		copy(b[utmpOffAddr:], a[:])
+	}
+	return b
+}
+
+func TestDecodeUtmpRecord(t *testing.T) {
+	at := time.Date(2026, 10, 17, 12, 0, 0, 250000000, time.UTC)
+
+	tests := []struct {
+		name string
+		addr netip.Addr
+		want string
+	}{
+		{"ipv4", netip.MustParseAddr("192.168.1.10"), "192.168.1.10"},
+		{"ipv6", netip.MustParseAddr("2001:db8::42"), "2001:db8::42"},
+		{"ipv4-mapped", netip.MustParseAddr("::ffff:10.0.0.1"), "10.0.0.1"},
+		{"none", netip.Addr{}, "invalid IP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := encodeUtmpRecord(utUserProcess, 4242, "pts/0", "alice", "client.example.com", at, tt.addr)
+			rec, err := decodeUtmpRecord(b, binary.LittleEndian)
+			if err != nil {
+				t.Fatalf("decodeUtmpRecord() failed: %v", err)
+			}
+			if rec.Type != utUserProcess || rec.PID != 4242 {
+				t.Errorf("Unexpected type/pid: %d/%d", rec.Type, rec.PID)
+			}
+			if rec.Line != "pts/0" || rec.User != "alice" || rec.Host != "client.example.com" {
+				t.Errorf("Unexpected strings: %q %q %q", rec.Line, rec.User, rec.Host)
+			}
+			if !rec.Time.Equal(at) {
+				t.Errorf("Expected time %v, got %v", at, rec.Time)
+			}
+			if rec.Addr.String() != tt.want {
+				t.Errorf("Expected addr %s, got %s", tt.want, rec.Addr)
+			}
+		})
+	}
+
+	if _, err := decodeUtmpRecord(make([]byte, 100), binary.LittleEndian); err == nil {
+		t.Error("Expected error for short record")
+	}
+}
+
+func TestUtmpParserWtmp(t *testing.T) {
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	ip := netip.MustParseAddr("203.0.113.9")
+
+	var buf bytes.Buffer
+	buf.Write(encodeUtmpRecord(utBootTime, 0, "~", "reboot", "6.8.0-generic", start, netip.Addr{}))
+	buf.Write(encodeUtmpRecord(utUserProcess, 100, "pts/0", "alice", "203.0.113.9", start.Add(time.Minute), ip))
+	buf.Write(encodeUtmpRecord(utUserProcess, 200, "tty1", "bob", "", start.Add(2*time.Minute), netip.Addr{}))
+	buf.Write(encodeUtmpRecord(utDeadProcess, 100, "pts/0", "", "", start.Add(3*time.Minute), netip.Addr{}))
+	// Trailing partial record is ignored
+	buf.Write(make([]byte, 100))
+
+	records, err := readUtmpRecords(&buf, binary.LittleEndian)
+	if err != nil {
+		t.Fatalf("readUtmpRecords() failed: %v", err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("Expected 4 records, got %d", len(records))
+	}
+
+	parser := newUtmpParser("testhost", false)
+	var events []notifier.LoginEvent
+	for _, rec := range records {
+		if event := parser.parse(rec); event != nil {
+			events = append(events, *event)
+		}
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d: %+v", len(events), events)
+	}
+	if events[0].Kind != notifier.KindLogin || events[0].Username != "alice" || events[0].IP != "203.0.113.9" || events[0].Terminal != "pts/0" {
+		t.Errorf("Unexpected first login: %+v", events[0])
+	}
+	if events[1].Kind != notifier.KindLogin || events[1].Username != "bob" || events[1].IP != "" {
+		t.Errorf("Unexpected second login: %+v", events[1])
+	}
+	if events[2].Kind != notifier.KindLogout || events[2].Username != "alice" {
+		t.Errorf("Unexpected logout: %+v", events[2])
+	}
+	if events[2].Fields[notifier.FieldDuration] != "2m0s" {
+		t.Errorf("Expected duration '2m0s', got '%s'", events[2].Fields[notifier.FieldDuration])
+	}
+}
+
+func TestUtmpParserBtmp(t *testing.T) {
+	at := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	rec, err := decodeUtmpRecord(encodeUtmpRecord(6, 300, "ssh:notty", "admin", "198.51.100.7", at, netip.MustParseAddr("198.51.100.7")), binary.LittleEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := newUtmpParser("testhost", true).parse(rec)
+	if event == nil {
+		t.Fatal("Expected a failed login event")
+	}
+	if event.Kind != notifier.KindFailedAuth {
+		t.Errorf("Expected kind '%s', got '%s'", notifier.KindFailedAuth, event.Kind)
+	}
+	if event.Terminal != "ssh" || event.Username != "admin" || event.IP != "198.51.100.7" {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/xsddz/whozere/internal/config"
 	"github.com/xsddz/whozere/internal/notifier"
 )
 
@@ -12,6 +13,19 @@ type Options struct {
 	// Since specifies how far back to check for login events
 	// Zero means only watch new events (no history)
 	Since time.Duration
+
+	// Checkpoints persists read positions so a restart resumes where the
+	// previous run stopped; a source with a valid checkpoint ignores Since
+	// Nil disables checkpointing
+	Checkpoints *CheckpointStore
+
+	// Rules are custom detection rules, tried before the built-in ones
+	Rules []config.RuleConfig
+
+	// Source is the configured name of the source being watched, set by
+	// Composite; it prefixes checkpoint keys, so that sources of the same
+	// type keep their own positions
+	Source string
 }
 
 // Watcher is the interface for login detection
@@ -33,8 +47,30 @@ func New() (Watcher, error) {
 	return newPlatformWatcher()
 }
 
+// NewFromConfig creates the watcher selected in configuration
+// The auto type (or none) picks the best watcher for the platform
+func NewFromConfig(cfg config.WatcherConfig) (Watcher, error) {
+	switch cfg.Type {
+	case "", config.WatcherAuto:
+		return newPlatformWatcher()
+	case config.WatcherSyslog:
+		// Network receiver, available on every platform
+		return NewSyslogWatcher(cfg.Syslog)
+	}
+	return newConfiguredWatcher(cfg)
+}
+
 // PlatformLogFiles returns the log files monitored on the current platform
 // Returns nil for platforms that don't use log files (e.g., macOS, Windows)
 func PlatformLogFiles() []string {
 	return platformLogFiles()
 }
+
+// LogFiles returns the log files w reads, for integrity monitoring
+// Watchers that do not report their files fall back to PlatformLogFiles
+func LogFiles(w Watcher) []string {
+	if lf, ok := w.(interface{ LogFiles() []string }); ok {
+		return lf.LogFiles()
+	}
+	return PlatformLogFiles()
+}
@@ -12,9 +12,20 @@ import (
 	"strings"
 	"time"
 
+	"github.com/xsddz/whozere/internal/config"
 	"github.com/xsddz/whozere/internal/notifier"
 )
 
+// darwinRules are the built-in rules for macOS unified log lines
+var darwinRules = []config.RuleConfig{
+	{
+		// "sshd: Accepted publickey for user from IP port N ssh2"
+		Name:    "ssh-accepted",
+		Pattern: `sshd.*Accepted\s+(?P<method>\S+)\s+for\s+(?P<user>\S+)\s+from\s+(?P<ip>\S+)(?:\s+port\s+(?P<port>\d+))?` + sshKeyPattern,
+		Fields:  map[string]string{config.RuleFieldTerminal: "ssh"},
+	},
+}
+
 // DarwinWatcher watches for login events on macOS
 type DarwinWatcher struct {
 	hostname string
@@ -40,22 +51,25 @@ func (w *DarwinWatcher) Watch(ctx context.Context, events chan<- notifier.LoginE
 
 // WatchWithOptions monitors macOS system logs with specific options
 func (w *DarwinWatcher) WatchWithOptions(ctx context.Context, events chan<- notifier.LoginEvent, opts Options) error {
-	// Patterns to detect login events
-	sshPattern := regexp.MustCompile(`sshd.*Accepted\s+\w+\s+for\s+(\w+)\s+from\s+([\d\.]+)`)
+	rules, err := compileRules(opts.Rules, darwinRules)
+	if err != nil {
+		return fmt.Errorf("darwin: %w", err)
+	}
+
+	// Patterns to detect login events without a user in the line
 	consolePattern := regexp.MustCompile(`loginwindow.*Login Window.*[Ll]ogin|User logged in`)
 	screenSharePattern := regexp.MustCompile(`screensharingd.*[Aa]uthenticat|[Cc]onnect`)
 
 	processLine := func(line string) *notifier.LoginEvent {
-		// Check SSH login
-		if matches := sshPattern.FindStringSubmatch(line); matches != nil {
-			return &notifier.LoginEvent{
-				Username:  matches[1],
-				Hostname:  w.hostname,
-				IP:        matches[2],
-				Terminal:  "ssh",
-				Timestamp: time.Now(),
-				OS:        "darwin",
-			}
+		now := time.Now()
+		timestamp, ok := parseLogTimestamp(line, now)
+		if !ok {
+			timestamp = now
+		}
+
+		// Check SSH login and custom rules
+		if event := rules.match(line, w.hostname, "darwin", timestamp); event != nil {
+			return event
 		}
 
 		// Check console login
@@ -68,7 +82,7 @@ func (w *DarwinWatcher) WatchWithOptions(ctx context.Context, events chan<- noti
 				Username:  user,
 				Hostname:  w.hostname,
 				Terminal:  "console",
-				Timestamp: time.Now(),
+				Timestamp: timestamp,
 				OS:        "darwin",
 			}
 		}
@@ -79,7 +93,7 @@ func (w *DarwinWatcher) WatchWithOptions(ctx context.Context, events chan<- noti
 				Username:  "screensharing",
 				Hostname:  w.hostname,
 				Terminal:  "vnc",
-				Timestamp: time.Now(),
+				Timestamp: timestamp,
 				OS:        "darwin",
 			}
 		}
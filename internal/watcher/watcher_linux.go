@@ -6,12 +6,13 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"regexp"
 	"strings"
 	"time"
 
+	"github.com/xsddz/whozere/internal/config"
 	"github.com/xsddz/whozere/internal/notifier"
 )
 
@@ -28,9 +29,17 @@ func newPlatformWatcher() (Watcher, error) {
 	}
 
 	// Determine which log file to watch
-	logFile := "/var/log/auth.log" // Debian/Ubuntu
-	if _, err := os.Stat(logFile); os.IsNotExist(err) {
-		logFile = "/var/log/secure" // RHEL/CentOS
+	logFile := findAuthLogFile()
+	if logFile == "" {
+		// Journal-only systems (Fedora, Arch, minimal Debian) have no text auth log
+		if _, err := exec.LookPath("journalctl"); err == nil {
+			return &JournalWatcher{hostname: hostname}, nil
+		}
+		// No syslog at all: fall back to the binary login records
+		if _, err := os.Stat(wtmpFile); err == nil {
+			return &UtmpWatcher{hostname: hostname, wtmpFile: wtmpFile, btmpFile: btmpFile}, nil
+		}
+		logFile = "/var/log/secure"
 	}
 
 	return &LinuxWatcher{
@@ -39,6 +48,54 @@ func newPlatformWatcher() (Watcher, error) {
 	}, nil
 }
 
+// newConfiguredWatcher creates the watcher of the configured type
+func newConfiguredWatcher(cfg config.WatcherConfig) (Watcher, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	path := func(def string) string {
+		if cfg.Path != "" {
+			return cfg.Path
+		}
+		return def
+	}
+
+	switch cfg.Type {
+	case config.WatcherAuthLog:
+		logFile := cfg.Path
+		if logFile == "" {
+			if logFile = findAuthLogFile(); logFile == "" {
+				return nil, fmt.Errorf("watcher: no auth log found, set watcher.path")
+			}
+		}
+		return &LinuxWatcher{hostname: hostname, logFile: logFile}, nil
+	case config.WatcherJournal:
+		return &JournalWatcher{hostname: hostname}, nil
+	case config.WatcherWtmp:
+		return &UtmpWatcher{hostname: hostname, wtmpFile: path(wtmpFile), btmpFile: btmpFile}, nil
+	case config.WatcherAuditd:
+		return &AuditWatcher{hostname: hostname, logFile: path(auditLogFile)}, nil
+	case config.WatcherFile:
+		return &FileWatcher{hostname: hostname, logFile: cfg.Path}, nil
+	}
+	return nil, fmt.Errorf("watcher: unknown type %q", cfg.Type)
+}
+
+// findAuthLogFile returns the text auth log in use, or "" if there is none
+func findAuthLogFile() string {
+	for _, path := range []string{
+		"/var/log/auth.log", // Debian/Ubuntu
+		"/var/log/secure",   // RHEL/CentOS
+	} {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
 // Name returns the watcher name
 func (w *LinuxWatcher) Name() string {
 	return "linux"
@@ -51,233 +108,133 @@ func (w *LinuxWatcher) Watch(ctx context.Context, events chan<- notifier.LoginEv
 
 // WatchWithOptions monitors Linux auth logs with specific options
 func (w *LinuxWatcher) WatchWithOptions(ctx context.Context, events chan<- notifier.LoginEvent, opts Options) error {
-	// Patterns to detect login events
-	// SSH login: "Accepted password for user from IP port ..."
-	// SSH login: "Accepted publickey for user from IP port ..."
-	sshPattern := regexp.MustCompile(`sshd\[\d+\]:\s+Accepted\s+\w+\s+for\s+(\w+)\s+from\s+([\d\.]+)\s+port\s+\d+`)
-	// PAM session opened: "pam_unix(sshd:session): session opened for user xxx"
-	pamPattern := regexp.MustCompile(`pam_unix\((\w+):session\):\s+session opened for user\s+(\w+)`)
-	// TTY login: "LOGIN ON ttyX BY user"
-	ttyPattern := regexp.MustCompile(`LOGIN ON\s+(\w+)\s+BY\s+(\w+)`)
-
-	processLine := func(line string) *notifier.LoginEvent {
-		// Check SSH login
-		if matches := sshPattern.FindStringSubmatch(line); matches != nil {
-			return &notifier.LoginEvent{
-				Username:  matches[1],
-				Hostname:  w.hostname,
-				IP:        matches[2],
-				Terminal:  "ssh",
-				Timestamp: time.Now(),
-				OS:        "linux",
-			}
-		}
-
-		// Check PAM session
-		if matches := pamPattern.FindStringSubmatch(line); matches != nil {
-			service := matches[1]
-			user := matches[2]
-			// Avoid duplicate with SSH pattern
-			if service != "sshd" {
-				return &notifier.LoginEvent{
-					Username:  user,
-					Hostname:  w.hostname,
-					Terminal:  service,
-					Timestamp: time.Now(),
-					OS:        "linux",
-				}
-			}
-		}
-
-		// Check TTY login
-		if matches := ttyPattern.FindStringSubmatch(line); matches != nil {
-			return &notifier.LoginEvent{
-				Username:  matches[2],
-				Hostname:  w.hostname,
-				Terminal:  matches[1],
-				Timestamp: time.Now(),
-				OS:        "linux",
-			}
-		}
-
-		return nil
+	// A single parser spans history and live lines so sessions opened
+	// before startup can still be closed
+	rules, err := compileRules(opts.Rules, authLogRules)
+	if err != nil {
+		return fmt.Errorf("linux: %w", err)
+	}
+	parser := newAuthLogParser(w.hostname, rules)
+
+	// Resume after the last line read by the previous run, if any; this
+	// covers exactly the downtime, so no history replay is needed
+	what := w.Name() + ":" + w.logFile
+	source := opts.checkpointKey(what)
+	tail := newTailer(w.logFile)
+	resumed := false
+	if cp, ok := opts.getCheckpoint(what); ok {
+		resumed = tail.resume(cp)
 	}
 
-	// If since is specified, first check historical logs using journalctl or tail
-	if opts.Since > 0 {
+	// If since is specified, first check historical logs using journalctl or the log file
+	if opts.Since > 0 && !resumed {
 		minutes := int(opts.Since.Minutes())
 		if minutes < 1 {
 			minutes = 1
 		}
+		cutoff := time.Now().Add(-opts.Since)
 
 		// Try journalctl first (systemd)
+		// ISO timestamps carry the year and zone, so the cutoff is exact
 		journalCmd := exec.CommandContext(ctx, "journalctl",
 			"--since", fmt.Sprintf("%d minutes ago", minutes),
 			"-u", "sshd",
+			"-o", "short-iso-precise",
 			"--no-pager",
 		)
 
 		if output, err := journalCmd.Output(); err == nil {
-			lines := strings.Split(string(output), "\n")
-			for _, line := range lines {
-				if event := processLine(line); event != nil {
-					select {
-					case events <- *event:
-					case <-ctx.Done():
-						return ctx.Err()
-					}
-				}
-			}
-		} else {
-			// Fallback: read log file with tail
-			tailCmd := exec.CommandContext(ctx, "tail", "-n", "1000", w.logFile)
-			if output, err := tailCmd.Output(); err == nil {
-				lines := strings.Split(string(output), "\n")
-				cutoff := time.Now().Add(-opts.Since)
-				for _, line := range lines {
-					if event := processLine(line); event != nil {
-						// Note: We can't accurately parse log timestamps easily,
-						// so we send all matching events from tail output
-						if event.Timestamp.After(cutoff) || true {
-							select {
-							case events <- *event:
-							case <-ctx.Done():
-								return ctx.Err()
-							}
-						}
-					}
-				}
-			}
-		}
-	}
-
-	// Now watch for new events by tailing the log file
-	// Use a reopenable file watcher to handle log rotation/truncation
-	go func() {
-		var file *os.File
-		var reader *bufio.Reader
-		var currentInode uint64
-		var currentSize int64
-
-		openFile := func() error {
-			if file != nil {
-				file.Close()
-			}
-			var err error
-			file, err = os.Open(w.logFile)
-			if err != nil {
+			if err := w.replay(ctx, parser, strings.NewReader(string(output)), cutoff, events); err != nil {
 				return err
 			}
-			// Seek to end of file to only watch new entries
-			pos, err := file.Seek(0, 2)
+		} else if file, err := os.Open(w.logFile); err == nil {
+			// Fallback: read the log file directly
+			err := w.replay(ctx, parser, file, cutoff, events)
+			file.Close()
 			if err != nil {
-				file.Close()
 				return err
 			}
-			currentSize = pos
-
-			// Get current inode
-			if info, err := file.Stat(); err == nil {
-				currentInode = getInode(info)
+		} else if history, err := readWtmpHistory(wtmpFile, w.hostname, cutoff); err == nil {
+			// Last resort: wtmp records every login even without syslog
+			for _, event := range history {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
 			}
-
-			reader = bufio.NewReader(file)
-			return nil
 		}
+	}
 
-		// Initial open
-		if err := openFile(); err != nil {
-			return
+	// Now watch for new events by tailing the log file
+	// The tailer follows the file across rotation/truncation
+	if !resumed {
+		// Seek to end of file to only watch new entries
+		if err := tail.open(-1); err != nil {
+			return fmt.Errorf("linux: %w", err)
 		}
-		defer func() {
-			if file != nil {
-				file.Close()
-			}
-		}()
-
-		checkTicker := time.NewTicker(5 * time.Second)
-		defer checkTicker.Stop()
-
-		for {
+		// Save the position, so that lines logged before a restart are
+		// not skipped if none of them is read first
+		opts.Checkpoints.Update(source, tail.checkpoint(), true)
+	}
+	err = tail.follow(ctx, func(line string) {
+		event := parser.parseLine(line)
+		if event != nil {
 			select {
+			case events <- *event:
 			case <-ctx.Done():
 				return
-			case <-checkTicker.C:
-				// Check if file was rotated/truncated
-				info, err := os.Stat(w.logFile)
-				if err != nil {
-					// File might be temporarily unavailable, retry later
-					continue
-				}
-
-				newInode := getInode(info)
-				newSize := info.Size()
-
-				needReopen := false
-
-				// File was replaced (inode changed)
-				if newInode != currentInode && newInode != 0 {
-					needReopen = true
-				}
-
-				// File was truncated (size shrunk)
-				if newSize < currentSize {
-					needReopen = true
-				}
-
-				if needReopen {
-					if err := openFile(); err == nil {
-						// After reopen, seek to beginning to read new content
-						file.Seek(0, 0)
-						currentSize = 0
-						if info, err := file.Stat(); err == nil {
-							currentInode = getInode(info)
-						}
-						reader = bufio.NewReader(file)
-					}
-				}
-			default:
-				line, err := reader.ReadString('\n')
-				if err != nil {
-					// No new lines, wait a bit
-					time.Sleep(100 * time.Millisecond)
-					continue
-				}
-
-				// Update current size after reading
-				if pos, err := file.Seek(0, 1); err == nil {
-					currentSize = pos
-				}
-
-				if event := processLine(line); event != nil {
-					select {
-					case events <- *event:
-					case <-ctx.Done():
-						return
-					}
-				}
 			}
 		}
-	}()
-
-	<-ctx.Done()
+		// Lines that produced an event are saved right away so they are
+		// never delivered twice; other progress is saved periodically
+		opts.Checkpoints.Update(source, tail.checkpoint(), event != nil)
+	})
+	if err != nil {
+		return fmt.Errorf("linux: %w", err)
+	}
 	return nil
 }
 
-// GetRecentLogins returns recent login records using 'last' command
-func GetRecentLogins() ([]string, error) {
-	data, err := os.ReadFile("/var/log/wtmp")
+// LogFiles returns the auth log, for integrity monitoring
+func (w *LinuxWatcher) LogFiles() []string {
+	if _, err := os.Stat(w.logFile); err != nil {
+		return nil
+	}
+	return []string{w.logFile}
+}
+
+// replay sends login events read from r that occurred at or after cutoff
+func (w *LinuxWatcher) replay(ctx context.Context, parser *authLogParser, r io.Reader, cutoff time.Time, events chan<- notifier.LoginEvent) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		event := parser.parseLine(scanner.Text())
+		if event == nil || event.Timestamp.Before(cutoff) {
+			continue
+		}
+		select {
+		case events <- *event:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return scanner.Err()
+}
+
+// GetRecentLogins returns login and logout records from wtmp, like 'last'
+func GetRecentLogins() ([]notifier.LoginEvent, error) {
+	hostname, err := os.Hostname()
 	if err != nil {
-		return nil, err
+		hostname = "unknown"
 	}
-	return strings.Split(string(data), "\n"), nil
+	return readWtmpHistory(wtmpFile, hostname, time.Time{})
 }
 
 // platformLogFiles returns log files for Linux
+// Returns nil when logins are read from the systemd journal
 func platformLogFiles() []string {
-	logFile := "/var/log/auth.log" // Debian/Ubuntu
-	if _, err := os.Stat(logFile); os.IsNotExist(err) {
-		logFile = "/var/log/secure" // RHEL/CentOS
+	if logFile := findAuthLogFile(); logFile != "" {
+		return []string{logFile}
 	}
-	return []string{logFile}
+	return nil
 }
@@ -41,7 +41,7 @@ func (w *WindowsWatcher) Watch(ctx context.Context, events chan<- notifier.Login
 func (w *WindowsWatcher) WatchWithOptions(ctx context.Context, events chan<- notifier.LoginEvent, opts Options) error {
 	// Pattern to extract username from event
 	userPattern := regexp.MustCompile(`Account Name:\s+(\S+)`)
-	ipPattern := regexp.MustCompile(`Source Network Address:\s+([\d\.]+)`)
+	ipPattern := regexp.MustCompile(`Source Network Address:\s+([0-9A-Fa-f:.%]+)`)
 	logonTypePattern := regexp.MustCompile(`Logon Type:\s+(\d+)`)
 
 	processEvent := func(eventData string) *notifier.LoginEvent {